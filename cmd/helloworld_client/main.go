@@ -23,11 +23,16 @@ import (
 	"context"
 	"crypto/tls"
 	"flag"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
+	"strings"
 	"time"
 
+	tenant "helloworld/pkg/tenant"
+	tlsconfig "helloworld/pkg/tlsconfig"
 	pb "helloworld/proto/helloworld"
 
 	"github.com/google/uuid"
@@ -35,6 +40,7 @@ import (
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/protobuf/encoding/protojson"
 )
 
@@ -43,19 +49,79 @@ const (
 	defaultName    = "world"
 )
 
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+/* log what the TLS handshake ended up with, handy to tell the GLB, the gateway and a pod apart */
+func logTLS(p *peer.Peer) {
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return
+	}
+
+	state := tlsInfo.State
+	server := ""
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		server = fmt.Sprintf("%v %v (issuer %v)", cert.Subject, cert.DNSNames, cert.Issuer)
+	}
+
+	log.Printf("TLS connection to %v: %v, ALPN %q, SNI %q, server certificate %v",
+		p.Addr, tlsVersions[state.Version], state.NegotiatedProtocol, state.ServerName, server)
+}
+
+/*
+the shard to retry against if the server rejected the tenant as WRONG_SHARD and named the owner's address, unless
+maxRedirects were already followed
+*/
+func redirectTarget(err error, redirects int, maxRedirects int) (tenant.WrongShard, bool) {
+	wrongShard, ok := tenant.WrongShardFromError(err)
+	if !ok {
+		return tenant.WrongShard{}, false
+	}
+
+	if wrongShard.OwnerAddress == "" || redirects >= maxRedirects {
+		log.Printf("Tenant %v is not served by shard %q, owner: %q", wrongShard.TenantId, wrongShard.Shard, wrongShard.OwnerShard)
+		return tenant.WrongShard{}, false
+	}
+
+	return wrongShard, true
+}
+
 func main() {
 	connecttls := flag.Bool("tls", true, "connect over TLS")
 	verifytls := flag.Bool("verifytls", true, "verify TLS")
+	caFile := flag.String("ca", "", "PEM bundle of CAs to verify the server certificate with, default is the system roots")
+	certFile := flag.String("cert", "", "client certificate for mutual TLS")
+	keyFile := flag.String("key", "", "client private key for mutual TLS")
+	serverName := flag.String("server-name", "", "server name for SNI, certificate verification and the :authority header, e.g. when dialing an IP")
+	alpn := flag.String("alpn", "", "comma separated ALPN protocols to offer, h2 is always offered")
 	address := flag.String("addr", defaultAddress, "address to connect to, default localhost:50051")
 	name := flag.String("name", defaultName, "name, default is world")
 	tenantId := flag.String("tenant", "", "tenantId to connect to, default will generated one")
 	useStream := flag.Bool ("stream", false, "use streaming rpc, default false to use unary rpc")
 	streamCount := flag.Int("stream-count", -1, "for streaming rpc, send this many requests, -1 for infinite")
 	streamIntervalMSecs := flag.Int("stream-interval-msecs", -1, "for streaming rpc, wait this number of milliseconds between requests, -1 for random")
+	token := flag.String("token", "", "bearer token to authenticate the tenant with, for servers using --tenant-identity=jwt")
+	tokenFile := flag.String("token-file", "", "read the bearer token from this file")
+	maxRedirects := flag.Int("max-redirects", 3, "follow this many WRONG_SHARD redirects to the shard that owns the tenant, 0 to disable")
 
 	flag.Parse()
 
-	if *tenantId == "" {
+	if *tokenFile != "" {
+		b, err := ioutil.ReadFile(*tokenFile)
+		if err != nil {
+			log.Fatalf("could not read token: %v", err)
+		}
+		*token = strings.TrimSpace(string(b))
+	}
+
+	// with a token the server takes the tenant from it, only send X-Tenant-Id if it was asked for
+	if *tenantId == "" && *token == "" {
 		defaultTenantId := uuid.New().String()
 		tenantId = &defaultTenantId
 	}
@@ -63,27 +129,82 @@ func main() {
 	log.Printf("Connecting to %v as %s with TenantID: %v", *address, *name, *tenantId)
 	// Set up a connection to the server.
 
-	grpcOptions := make([]grpc.DialOption, 0)
-
+	var tlsConfig *tls.Config
 	if *connecttls {
 		log.Printf("Connecting over TLS ...")
-		config := &tls.Config{
+		if !*verifytls {
+			log.Printf("Not verifying the server certificate")
+		}
+
+		var nextProtos []string
+		if *alpn != "" {
+			nextProtos = strings.Split(*alpn, ",")
+		}
+
+		config, err := tlsconfig.ClientConfig(tlsconfig.ClientOptions{
+			CAFile:             *caFile,
+			CertFile:           *certFile,
+			KeyFile:            *keyFile,
+			NextProtos:         nextProtos,
 			InsecureSkipVerify: !*verifytls,
+		})
+		if err != nil {
+			log.Fatalf("could not set up TLS: %v", err)
 		}
-		grpcOptions = append(grpcOptions, grpc.WithTransportCredentials(credentials.NewTLS(config)))
-	} else {
-		grpcOptions = append(grpcOptions, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		tlsConfig = config
+	}
+
+	/* the server name only applies to --addr, redirects go to the owner's own name */
+	dialOptions := func(serverName string) []grpc.DialOption {
+		creds := insecure.NewCredentials()
+		if tlsConfig != nil {
+			config := tlsConfig.Clone()
+			config.ServerName = serverName
+			creds = credentials.NewTLS(config)
+		}
+
+		opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+		if serverName != "" {
+			// the gateway routes on :authority, which otherwise stays the dialed address
+			opts = append(opts, grpc.WithAuthority(serverName))
+		}
+		return opts
 	}
 
-	conn, err := grpc.Dial(*address, grpcOptions...)
+	conn, err := grpc.Dial(*address, dialOptions(*serverName)...)
 
 	//conn, err := grpc.Dial(address, grpc.WithInsecure())
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
 	}
-	defer conn.Close()
+	defer func() { conn.Close() }()
 	c := pb.NewGreeterClient(conn)
 
+	/* if the server says the tenant lives on another shard, reconnect there */
+	redirects := 0
+	followRedirect := func(err error) bool {
+		wrongShard, ok := redirectTarget(err, redirects, *maxRedirects)
+		if !ok {
+			return false
+		}
+		redirects++
+
+		log.Printf("Tenant %v is not served by shard %q, redirecting to shard %q at %v",
+			*tenantId, wrongShard.Shard, wrongShard.OwnerShard, wrongShard.OwnerAddress)
+
+		newConn, dialErr := grpc.Dial(wrongShard.OwnerAddress, dialOptions("")...)
+		if dialErr != nil {
+			log.Printf("did not connect to %v: %v", wrongShard.OwnerAddress, dialErr)
+			return false
+		}
+
+		conn.Close()
+		conn = newConn
+		c = pb.NewGreeterClient(conn)
+
+		return true
+	}
+
 	var ctx context.Context
 	var cancel context.CancelFunc
 
@@ -97,12 +218,24 @@ func main() {
 	}
 
 	/* set up the tenant id in the metadata */
-	ctx = metadata.AppendToOutgoingContext(ctx, "X-Tenant-Id", *tenantId)
+	if *tenantId != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "X-Tenant-Id", *tenantId)
+	}
+
+	if *token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "Authorization", "Bearer "+*token)
+	}
 	req := &pb.HelloRequest{Name: *name}
 
 	// unary RPC call and exit
 	if !*useStream {
-		r, err := c.SayHello(ctx, req)
+		p := &peer.Peer{}
+		r, err := c.SayHello(ctx, req, grpc.Peer(p))
+		for err != nil && followRedirect(err) {
+			r, err = c.SayHello(ctx, req, grpc.Peer(p))
+		}
+		logTLS(p)
+
 		if err != nil {
 			log.Fatalf("could not greet: %v", err)
 		}
@@ -114,7 +247,8 @@ func main() {
 	total := *streamCount
 
 	// start streaming RPC
-	stream, err := c.StreamingHello(ctx)
+	p := &peer.Peer{}
+	stream, err := c.StreamingHello(ctx, grpc.Peer(p))
 	if err != nil {
 		log.Fatalf("could not start streaming RPC: %v", err.Error())
 	}
@@ -137,11 +271,23 @@ func main() {
 			break
 		}
 		
+		if err != nil && i == 0 && followRedirect(err) {
+			// rejected before any reply, start the stream again on the owning shard
+			stream, err = c.StreamingHello(ctx, grpc.Peer(p))
+			if err != nil {
+				log.Fatalf("could not start streaming RPC: %v", err.Error())
+			}
+			continue
+		}
+
 		if err != nil {
 			log.Printf("Error receiving reply: %v", err.Error())
 			break
 		}
 
+		if i == 0 {
+			logTLS(p)
+		}
 		log.Printf("Response: %v", protojson.Format(r))
 
 		i = i + 1
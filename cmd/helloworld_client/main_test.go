@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+
+	tenant "helloworld/pkg/tenant"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRedirectTarget(t *testing.T) {
+	config, err := tenant.ParseTenantConfig([]byte(`
+shard: a
+shards:
+- name: b
+  address: hellogrpc-b.example.com:443
+  prefix: ["b-"]
+- name: c
+  prefix: ["c-"]
+allowed_tenants:
+- prefix: ["a-"]
+denied_tenants:
+- exactMatch: ["a-blocked"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rejection := func(tenantId string) error {
+		return config.RejectionError(config.Evaluate(tenantId))
+	}
+
+	tests := []struct {
+		name      string
+		err       error
+		redirects int
+		want      tenant.WrongShard
+		ok        bool
+	}{
+		{
+			name: "owner with an address",
+			err:  rejection("b-1"),
+			want: tenant.WrongShard{TenantId: "b-1", Shard: "a", OwnerShard: "b", OwnerAddress: "hellogrpc-b.example.com:443"},
+			ok:   true,
+		},
+		{
+			name:      "last redirect",
+			err:       rejection("b-1"),
+			redirects: 2,
+			want:      tenant.WrongShard{TenantId: "b-1", Shard: "a", OwnerShard: "b", OwnerAddress: "hellogrpc-b.example.com:443"},
+			ok:        true,
+		},
+		{
+			name:      "too many redirects",
+			err:       rejection("b-1"),
+			redirects: 3,
+		},
+		{
+			name: "owner without an address",
+			err:  rejection("c-1"),
+		},
+		{
+			name: "no known owner",
+			err:  rejection("x-1"),
+		},
+		{
+			name: "denied",
+			err:  rejection("a-blocked"),
+		},
+		{
+			name: "other errors",
+			err:  status.Error(codes.Unavailable, "connection refused"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := redirectTarget(test.err, test.redirects, 3)
+			if ok != test.ok || got != test.want {
+				t.Errorf("redirectTarget() = %+v, %v, want %+v, %v", got, ok, test.want, test.ok)
+			}
+		})
+	}
+}
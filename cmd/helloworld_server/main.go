@@ -23,6 +23,7 @@ package main
 
 import (
 	"context"
+	cryptotls "crypto/tls"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -30,19 +31,26 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
+	gcp "helloworld/pkg/gcp"
 	http_health "helloworld/pkg/healthcheck"
+	ratelimit "helloworld/pkg/ratelimit"
+	serverconfig "helloworld/pkg/serverconfig"
+	shutdown "helloworld/pkg/shutdown"
+	tlsconfig "helloworld/pkg/tlsconfig"
 	tenant "helloworld/pkg/tenant"
 	pb "helloworld/proto/helloworld"
 	helloServer "helloworld/pkg/helloServer"
 
 	"go.uber.org/zap"
-	"google.golang.org/grpc/codes"
+	"go.uber.org/zap/zapcore"
 	grpc_health "google.golang.org/grpc/health/grpc_health_v1"
-	"google.golang.org/grpc/status"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_zap "github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
@@ -54,146 +62,503 @@ import (
 )
 
 const (
-	port = ":50051"
+	greeterService = "helloworld.Greeter"
 )
 
 // server is used to implement helloworld.GreeterServer.
 type grpcServer struct {
 	helloServer.HelloServer
-	grpc_health.HealthServer
-}
-
-func (s *grpcServer) Check(context.Context, *grpc_health.HealthCheckRequest) (*grpc_health.HealthCheckResponse, error) {
-	return &grpc_health.HealthCheckResponse{Status: grpc_health.HealthCheckResponse_SERVING}, nil
-}
-
-func (s *grpcServer) Watch(*grpc_health.HealthCheckRequest, grpc_health.Health_WatchServer) error {
-	return status.Error(codes.Unimplemented, "unimplemented")
 }
 
 
 func main() {
 	opts := []grpc_zap.Option{}
 
-	zapLogger, err := zap.NewProduction()
+	configFile := flag.String("config", os.Getenv(serverconfig.EnvName("config")), "server config file (yaml), overridden by "+serverconfig.EnvPrefix+"* environment variables and flags (env "+serverconfig.EnvName("config")+")")
+	printConfig := flag.Bool("print-config", false, "print the effective config with secrets redacted and exit")
+	configFlags := serverconfig.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	cfg, err := configFlags.Load(*configFile)
+	if err != nil {
+		log.Fatalf("invalid server config: %v", err)
+	}
+
+	if *printConfig {
+		out, err := cfg.Redacted().YAML()
+		if err != nil {
+			log.Fatalf("can't print config: %v", err)
+		}
+		os.Stdout.Write(out)
+		return
+	}
+
+	logLevel, _ := cfg.LogLevel()
+	zapConfig := zap.NewProductionConfig()
+	zapConfig.Level = zap.NewAtomicLevelAt(logLevel)
+	zapConfig.Encoding = cfg.Logging.Format
+	if cfg.Logging.Format == serverconfig.LogFormatConsole {
+		zapConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	}
+	zapLogger, err := zapConfig.Build()
 	if err != nil {
 		log.Fatalf("can't initialize zap logger: %v", err)
 	}
 	grpc_zap.ReplaceGrpcLoggerV2(zapLogger)
 	defer zapLogger.Sync()
 
-	tlsCrt := flag.String("crt", "certs/tls.crt", "TLS certificate")
-	tlsKey := flag.String("key", "certs/tls.key", "TLS private key")
-	tlsB := flag.Bool("tls", true, "enable TLS")
-	configDir := flag.String("config-dir", "config/", "config directory")
-	flag.Parse()
+	if *configFile != "" {
+		zapLogger.Info("Loaded server config", zap.String("config", *configFile))
+	}
+
+	gcp.MetaDataURL = cfg.Metadata.URL
+	if cfg.Metrics.HandlingTimeHistogram {
+		grpc_prometheus.EnableHandlingTimeHistogram()
+	}
 
-	lis, err := net.Listen("tcp", port)
+	tenantIdentity, err := tenant.NewIdentityProvider(tenant.IdentityOptions{
+		Mode:        cfg.Tenancy.Identity.Mode,
+		JWKSSource:  cfg.Tenancy.Identity.JWKS,
+		TenantClaim: cfg.Tenancy.Identity.JWTClaim,
+		Issuer:      cfg.Tenancy.Identity.JWTIssuer,
+		Audience:    cfg.Tenancy.Identity.JWTAudience,
+		CertField:   cfg.Tenancy.Identity.CertField,
+	})
+	if err != nil {
+		zapLogger.Fatal("Invalid config", zap.Error(err))
+	}
+	zapLogger.Info("Tenant identity", zap.String("identity", tenantIdentity.String()))
+
+	if (cfg.Listeners.Admin.TLS && cfg.Listeners.Admin.Address == "") || (cfg.Listeners.Metrics.TLS && cfg.Listeners.Metrics.Address == "") {
+		zapLogger.Fatal("Admin and metrics TLS need their own listener",
+			zap.String("adminAddr", cfg.Listeners.Admin.Address),
+			zap.String("metricsAddr", cfg.Listeners.Metrics.Address),
+		)
+	}
+
+	lis, err := net.Listen("tcp", cfg.Listeners.GRPC.Address)
 	if err != nil {
 		zapLogger.Fatal("failed to listen", 
 			zap.String("error", err.Error()), 
-			zap.String("address", port),
+			zap.String("address", cfg.Listeners.GRPC.Address),
 		)
 	}
 
-	zapLogger.Info("Listening on address", zap.String("address", port))
+	zapLogger.Info("Listening on address", zap.String("address", cfg.Listeners.GRPC.Address), zap.String("listener", "grpc"))
 
 	/* check if grpc needs to listen on TLS */
-	tls := *tlsB
-	grpcOptions := make([]grpc.ServerOption, 0)
-	if tls {
-		if _, err := os.Stat(*tlsCrt); errors.Is(err, os.ErrNotExist) {
+	tls := cfg.TLS.Enabled
+	grpcOptions := []grpc.ServerOption{
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:                  cfg.Keepalive.Time.Duration,
+			Timeout:               cfg.Keepalive.Timeout.Duration,
+			MaxConnectionIdle:     cfg.Keepalive.MaxConnectionIdle.Duration,
+			MaxConnectionAge:      cfg.Keepalive.MaxConnectionAge.Duration,
+			MaxConnectionAgeGrace: cfg.Keepalive.MaxConnectionAgeGrace.Duration,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             cfg.Keepalive.MinTime.Duration,
+			PermitWithoutStream: cfg.Keepalive.PermitWithoutStream,
+		}),
+	}
+	// the serving certificate for the health service and the admin and metrics listeners
+	var certValid func() error
+	var serverCertificate func(*cryptotls.ClientHelloInfo) (*cryptotls.Certificate, error)
+	if cfg.TLS.DefaultCert != "" && cfg.TLS.CertDir == "" {
+		zapLogger.Fatal("Default cert needs a cert dir", zap.String("defaultCert", cfg.TLS.DefaultCert))
+	}
+
+	// with a default from --cert-dir, --crt and --key aren't used
+	if tls && cfg.TLS.DefaultCert == "" {
+		if _, err := os.Stat(cfg.TLS.Cert); errors.Is(err, os.ErrNotExist) {
 			tls = false
-			zapLogger.Info("Could not find cert", zap.String("cert", *tlsCrt))
+			zapLogger.Info("Could not find cert", zap.String("cert", cfg.TLS.Cert))
 		}
 
-		if _, err := os.Stat(*tlsKey); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(cfg.TLS.Key); errors.Is(err, os.ErrNotExist) {
 			tls = false
-			zapLogger.Info("Could not find key", zap.String("key",*tlsKey))
+			zapLogger.Info("Could not find key", zap.String("key",cfg.TLS.Key))
 		}
 	}
 
+	if !tls && cfg.TLS.ClientAuth != tlsconfig.ClientAuthNone {
+		zapLogger.Fatal("Client auth needs TLS", zap.String("clientAuth", cfg.TLS.ClientAuth))
+	}
+
 	if tls {
 		zapLogger.Info("TLS enabled", 
-			zap.String("cert", *tlsCrt), 
-			zap.String("key", *tlsKey),
+			zap.String("cert", cfg.TLS.Cert), 
+			zap.String("key", cfg.TLS.Key),
 		)
-		creds, err := credentials.NewServerTLSFromFile(*tlsCrt, *tlsKey)
+		// renewed certificates are picked up without a restart
+		var certReloader *tlsconfig.CertReloader
+		var getCertificate func(*cryptotls.ClientHelloInfo) (*cryptotls.Certificate, error)
+		if cfg.TLS.DefaultCert == "" {
+			certReloader, err = tlsconfig.NewCertReloader(cfg.TLS.Cert, cfg.TLS.Key, zapLogger)
+			if err != nil {
+				zapLogger.Fatal("Failed to load TLS certificate",
+					zap.String("cert", cfg.TLS.Cert), 
+					zap.String("key", cfg.TLS.Key),
+					zap.Error(err))
+			}
+			go certReloader.Watch(context.Background(), cfg.TLS.ReloadInterval.Duration)
+			getCertificate = certReloader.GetCertificate
+			certValid = certReloader.Valid
+		}
+
+		/* more certificates chosen by SNI */
+		if cfg.TLS.CertDir != "" {
+			certDirectory, err := tlsconfig.NewCertDirectory(cfg.TLS.CertDir, cfg.TLS.DefaultCert, certReloader, zapLogger)
+			if err != nil {
+				zapLogger.Fatal("Failed to load TLS certificate directory",
+					zap.String("certDir", cfg.TLS.CertDir),
+					zap.String("defaultCert", cfg.TLS.DefaultCert),
+					zap.Error(err))
+			}
+			go certDirectory.Watch(context.Background(), cfg.TLS.ReloadInterval.Duration)
+			getCertificate = certDirectory.GetCertificate
+			certValid = certDirectory.Valid
+
+			zapLogger.Info("SNI certificates enabled",
+				zap.String("certDir", cfg.TLS.CertDir),
+				zap.String("defaultCert", cfg.TLS.DefaultCert),
+			)
+		}
+
+		serverCertificate = getCertificate
+
+		tlsConfig, err := tlsconfig.ServerConfig(tlsconfig.ServerOptions{
+			GetCertificate: getCertificate,
+			ClientCAFile:   cfg.TLS.ClientCA,
+			ClientAuth:     cfg.TLS.ClientAuth,
+		})
 		if err != nil {
 			zapLogger.Fatal("Failed to setup TLS",
-				zap.String("cert", *tlsCrt), 
-				zap.String("key", *tlsKey),
+				zap.String("cert", cfg.TLS.Cert), 
+				zap.String("key", cfg.TLS.Key),
+				zap.String("clientCA", cfg.TLS.ClientCA),
+				zap.String("clientAuth", cfg.TLS.ClientAuth),
 				zap.Error(err))
 		}
 
+		if cfg.TLS.ClientAuth != tlsconfig.ClientAuthNone {
+			zapLogger.Info("Mutual TLS enabled",
+				zap.String("clientCA", cfg.TLS.ClientCA),
+				zap.String("clientAuth", cfg.TLS.ClientAuth),
+			)
+		}
+
+		creds := credentials.NewTLS(tlsConfig)
 		grpcOptions = append(grpcOptions, grpc.Creds(creds))
 	}
 
-	// initialize tenant metrics
-	tenantMetrics := tenant.NewTenantMetrics()
-
-	// add interceptors
-	grpcOptions = append (grpcOptions, 
-		grpc_middleware.WithUnaryServerChain(
-			tenantMetrics.TenantMetricsUnaryInterceptor,
-			grpc_prometheus.UnaryServerInterceptor,
-			grpc_ctxtags.UnaryServerInterceptor(grpc_ctxtags.WithFieldExtractor(grpc_ctxtags.CodeGenRequestFieldExtractor)),
-			grpc_zap.UnaryServerInterceptor(zapLogger, opts...),
-			grpc_recovery.UnaryServerInterceptor(),
-		),
-		grpc_middleware.WithStreamServerChain(
-			tenantMetrics.TenantMetricsStreamInterceptor,
-			grpc_prometheus.StreamServerInterceptor,
-			grpc_ctxtags.StreamServerInterceptor(grpc_ctxtags.WithFieldExtractor(grpc_ctxtags.CodeGenRequestFieldExtractor)),
-			grpc_zap.StreamServerInterceptor(zapLogger, opts...),
-			grpc_recovery.StreamServerInterceptor(),
-		),
-	)
+	/* get the tenant config */
+	if cfg.Tenancy.Source == "" {
+		cfg.Tenancy.Source = tenant.TenantConfigPath(cfg.Tenancy.ConfigDir)
+	}
 
-	s := grpc.NewServer(grpcOptions...)
+	tenantConfigSource, err := tenant.NewTenantConfigSource(cfg.Tenancy.Source)
+	if err != nil {
+		zapLogger.Fatal("Invalid tenant config source", zap.Error(err))
+	}
 
-	/* get the tenant config */
-	t, err := tenant.LoadTenantConfig(*configDir)
+	t, err := tenant.LoadTenantConfigFromSource(context.Background(), tenantConfigSource, cfg.Tenancy.FailurePolicy)
 	if err != nil {
-		zapLogger.Warn("Error loading tenant config", zap.Error(err))
+		if cfg.Tenancy.FailurePolicy == tenant.FailurePolicyRefuseToStart {
+			zapLogger.Fatal("Error loading tenant config", 
+				zap.String("source", tenantConfigSource.String()),
+				zap.String("failurePolicy", cfg.Tenancy.FailurePolicy),
+				zap.Error(err),
+			)
+		}
+
+		zapLogger.Warn("Error loading tenant config", 
+			zap.String("source", tenantConfigSource.String()),
+			zap.String("failurePolicy", cfg.Tenancy.FailurePolicy),
+			zap.Error(err),
+		)
 	}
 	tenantConfigJSON, _ := json.Marshal(t)
 	zapLogger.Info("Loaded Tenant Config", 
+		zap.String("source", tenantConfigSource.String()),
 		zap.String("tenantConfigJson", string(tenantConfigJSON)),
+		zap.String("hash", t.Hash()),
+		zap.String("fallback", t.Fallback()),
 	)
+	for _, w := range t.Deprecations() {
+		zapLogger.Warn("Deprecated tenant config rule", zap.String("rule", w.Rule), zap.String("warning", w.Message))
+	}
+
+	/* watch the tenant config source for changes */
+	tenantConfigStore := tenant.NewTenantConfigStore(t)
+	tenantConfigWatcher := tenant.NewTenantConfigWatcher(tenantConfigSource, tenantConfigStore, zapLogger)
+	go tenantConfigWatcher.Watch(context.Background(), cfg.Tenancy.ReloadInterval.Duration)
+
+	// initialize tenant metrics
+	tenantMetrics := tenant.NewTenantMetrics()
+
+	// tenant enforcement for every registered service, runs after the logging interceptors so rejections are logged
+	tenantAuthorization := tenant.NewAuthorizationInterceptor(tenantConfigStore, tenantIdentity)
+
+	// per-tenant rate limits from the tenant config
+	tenantRateLimit, err := ratelimit.NewRateLimitInterceptor(tenantConfigStore)
+	if err != nil {
+		zapLogger.Fatal("Failed to initialize rate limits", zap.Error(err))
+	}
+
+	// closes the streams left at the drain deadline on shutdown
+	drainer := shutdown.NewDrainer()
+
+	/* what the server needs to serve, drives the health service and /readyz */
+	healthConditions := []http_health.Condition{{
+		Name: "tenant-config",
+		Check: func(context.Context) error {
+			if !tenantConfigStore.Serving() {
+				return errors.New("tenant config could not be loaded, rejecting all tenants")
+			}
+			return nil
+		},
+	}}
+	if certValid != nil {
+		healthConditions = append(healthConditions, http_health.Condition{
+			Name:  "tls-certificate",
+			Check: func(context.Context) error { return certValid() },
+		})
+	}
+	if cfg.Health.Metadata {
+		// replies still go out without the metadata, just without zone, cluster and project
+		healthConditions = append(healthConditions, http_health.Condition{
+			Name:     "metadata",
+			Check:    gcp.CheckMetaData,
+			Services: []string{greeterService},
+		})
+	}
+
+	/* the health service, NOT_SERVING while a condition fails and from the start of shutdown */
+	healthRegistry := http_health.NewRegistry(zapLogger, greeterService)
+	for _, c := range healthConditions {
+		healthRegistry.Register(c)
+	}
+	healthRegistry.Check(context.Background())
+	go healthRegistry.Watch(context.Background(), cfg.Health.CheckInterval.Duration)
+	drainer.OnDrain(healthRegistry.Shutdown)
+
+	/* kubelet probes: alive while it answers, started once serving, ready while the conditions hold and not draining */
+	started := make(chan struct{})
+	startedCheck := func(context.Context) error {
+		select {
+		case <-started:
+			return nil
+		default:
+			return errors.New("server is still starting")
+		}
+	}
+
+	livez := http_health.NewProbe("livez")
+	livez.Register("ping", func(context.Context) error { return nil })
+
+	startupz := http_health.NewProbe("startupz")
+	startupz.Register("started", startedCheck)
+
+	readyz := http_health.NewProbe("readyz")
+	readyz.Register("started", startedCheck)
+	// not the metadata condition, the metadata server being unreachable shouldn't take every pod out of the Service
+	readyz.RegisterConditions(healthConditions)
+	readyz.Register("not-draining", func(context.Context) error {
+		if drainer.Draining() {
+			return errors.New("server is shutting down")
+		}
+		return nil
+	})
+
+	/* the interceptors the chain is built from, in the order of --interceptors */
+	tags := grpc_ctxtags.WithFieldExtractor(grpc_ctxtags.CodeGenRequestFieldExtractor)
+	unaryInterceptors := map[string]grpc.UnaryServerInterceptor{
+		serverconfig.InterceptorPrometheus:    grpc_prometheus.UnaryServerInterceptor,
+		serverconfig.InterceptorTags:          grpc_ctxtags.UnaryServerInterceptor(tags),
+		serverconfig.InterceptorLogging:       grpc_zap.UnaryServerInterceptor(zapLogger, opts...),
+		serverconfig.InterceptorPeerIdentity:  tlsconfig.PeerIdentityUnaryInterceptor,
+		serverconfig.InterceptorAuthorization: tenantAuthorization.AuthorizationUnaryInterceptor,
+		serverconfig.InterceptorTenantMetrics: tenantMetrics.TenantMetricsUnaryInterceptor,
+		serverconfig.InterceptorRateLimit:     tenantRateLimit.RateLimitUnaryInterceptor,
+		serverconfig.InterceptorRecovery:      grpc_recovery.UnaryServerInterceptor(),
+	}
+	streamInterceptors := map[string]grpc.StreamServerInterceptor{
+		serverconfig.InterceptorPrometheus:    grpc_prometheus.StreamServerInterceptor,
+		serverconfig.InterceptorTags:          grpc_ctxtags.StreamServerInterceptor(tags),
+		serverconfig.InterceptorLogging:       grpc_zap.StreamServerInterceptor(zapLogger, opts...),
+		serverconfig.InterceptorPeerIdentity:  tlsconfig.PeerIdentityStreamInterceptor,
+		serverconfig.InterceptorAuthorization: tenantAuthorization.AuthorizationStreamInterceptor,
+		serverconfig.InterceptorTenantMetrics: tenantMetrics.TenantMetricsStreamInterceptor,
+		serverconfig.InterceptorRateLimit:     tenantRateLimit.RateLimitStreamInterceptor,
+		serverconfig.InterceptorDrain:         drainer.StreamServerInterceptor,
+		serverconfig.InterceptorRecovery:      grpc_recovery.StreamServerInterceptor(),
+	}
+
+	var unaryChain []grpc.UnaryServerInterceptor
+	var streamChain []grpc.StreamServerInterceptor
+	for _, name := range cfg.Interceptors.Chain {
+		if i, ok := unaryInterceptors[name]; ok {
+			unaryChain = append(unaryChain, i)
+		}
+		if i, ok := streamInterceptors[name]; ok {
+			streamChain = append(streamChain, i)
+		}
+	}
+
+	if !contains(cfg.Interceptors.Chain, serverconfig.InterceptorAuthorization) {
+		zapLogger.Warn("Tenant authorization interceptor is not in the chain, calls have no tenant and are rejected",
+			zap.Strings("interceptors", cfg.Interceptors.Chain))
+	}
+
+	// add interceptors
+	grpcOptions = append (grpcOptions,
+		grpc_middleware.WithUnaryServerChain(unaryChain...),
+		grpc_middleware.WithStreamServerChain(streamChain...),
+	)
+
+	s := grpc.NewServer(grpcOptions...)
 
 	/* register grpc services */
 	g := &grpcServer{
-		HelloServer: *helloServer.NewHelloServer(*t),
+		HelloServer: *helloServer.NewHelloServer(tenantConfigStore),
 	}
 
 	pb.RegisterGreeterServer(s, g)
-	grpc_health.RegisterHealthServer(s, g)
+	grpc_health.RegisterHealthServer(s, healthRegistry.HealthServer())
 
 	/* reset all prometheus to zero */
 	grpc_prometheus.Register(s)
 
 	/* register http services */
-	h := &http.Server{}
-	http.DefaultServeMux.Handle("/healthz", &http_health.HttpHealthCheckHandler{})
+	adminMux := http.NewServeMux()
+	adminMux.Handle("/healthz", &http_health.HttpHealthCheckHandler{
+		TenantConfig: tenantConfigStore,
+		TenantConfigFailurePolicy: cfg.Tenancy.FailurePolicy,
+		Health: healthRegistry,
+	})
+
+	adminMux.Handle("/livez", livez)
+	adminMux.Handle("/readyz", readyz)
+	adminMux.Handle("/startupz", startupz)
+
+	// Register Prometheus metrics handler
+	metricsMux := newMetricsMux(cfg.Listeners, adminMux)
+	metricsMux.Handle("/metrics", promhttp.Handler())
+
+	httpServers := make([]*http.Server, 0)
+	if cfg.Listeners.Admin.Address == "" {
+		/* single port: HTTP/1.1 goes to the admin endpoints, everything else to gRPC, e.g. for the NEG health check */
+		m := cmux.New(lis)
+
+		// if http1.1 match, send to the http handler 
+		httpL := m.Match(cmux.HTTP1Fast())
+
+		// otherwise assume grpc
+		grpcL := m.Match(cmux.Any())
+
+		h := &http.Server{Handler: adminMux}
+		httpServers = append(httpServers, h)
+
+		go s.Serve(grpcL)
+		go h.Serve(httpL)
+
+		go func() {
+			if err := m.Serve(); err != nil && !drainer.Draining() {
+				zapLogger.Fatal("failed to serve", 
+					zap.Error(err),
+				)
+			}
+		}()
+	} else {
+		go func() {
+			if err := s.Serve(lis); err != nil && !drainer.Draining() {
+				zapLogger.Fatal("failed to serve", 
+					zap.Error(err),
+				)
+			}
+		}()
+
+		httpServers = append(httpServers, serveHTTP("admin", cfg.Listeners.Admin.Address, adminMux, cfg.Listeners.Admin.TLS, serverCertificate, zapLogger))
+	}
 
-	// Register Prometheus metrics handler.    
-	http.Handle("/metrics", promhttp.Handler())
+	if cfg.Listeners.Metrics.Address != "" {
+		httpServers = append(httpServers, serveHTTP("metrics", cfg.Listeners.Metrics.Address, metricsMux, cfg.Listeners.Metrics.TLS, serverCertificate, zapLogger))
+	}
+	close(started)
+
+	/* drain on SIGTERM, e.g. the pod being removed from the NEG */
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	zapLogger.Info("Received signal", zap.String("signal", (<-sig).String()))
+
+	drainer.Shutdown(s, cfg.Shutdown.Delay.Duration, cfg.Shutdown.DrainTimeout.Duration, func(ctx context.Context) {
+		// stop accepting connections, then wait for the http requests
+		lis.Close()
+		for _, h := range httpServers {
+			h.Shutdown(ctx)
+		}
+	}, zapLogger)
+}
 
-	m := cmux.New(lis)
+/* where /metrics is served: with the admin endpoints only if it was asked not to have a listener of its own */
+func newMetricsMux(listeners serverconfig.ListenersConfig, adminMux *http.ServeMux) *http.ServeMux {
+	if listeners.Metrics.Address == "" {
+		return adminMux
+	}
 
-	// if http1.1 match, send to the http handler 
-	httpL := m.Match(cmux.HTTP1Fast())
+	return http.NewServeMux()
+}
 
-	// otherwise assume grpc
-	grpcL := m.Match(cmux.Any())
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
 
-	go s.Serve(grpcL)
-	go h.Serve(httpL)
+	return false
+}
 
-	if err := m.Serve(); err != nil {
-		zapLogger.Fatal("failed to serve", 
-			zap.Error(err),
+/* listen on addr and serve handler, over TLS with the server certificate if useTLS */
+func serveHTTP(name string, addr string, handler http.Handler, useTLS bool,
+	getCertificate func(*cryptotls.ClientHelloInfo) (*cryptotls.Certificate, error), zapLogger *zap.Logger) *http.Server {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		zapLogger.Fatal("failed to listen",
+			zap.String("error", err.Error()),
+			zap.String("address", addr),
+			zap.String("listener", name),
 		)
 	}
+
+	if useTLS {
+		if getCertificate == nil {
+			zapLogger.Fatal("TLS needs the server certificate, see --crt, --key and --tls", zap.String("listener", name))
+		}
+
+		tlsConfig, err := tlsconfig.ServerConfig(tlsconfig.ServerOptions{
+			GetCertificate: getCertificate,
+			NextProtos:     []string{"h2", "http/1.1"},
+		})
+		if err != nil {
+			zapLogger.Fatal("Failed to setup TLS", zap.String("listener", name), zap.Error(err))
+		}
+		lis = cryptotls.NewListener(lis, tlsConfig)
+	}
+
+	zapLogger.Info("Listening on address",
+		zap.String("address", addr),
+		zap.String("listener", name),
+		zap.Bool("tls", useTLS),
+	)
+
+	h := &http.Server{Handler: handler}
+	go func() {
+		if err := h.Serve(lis); err != nil && err != http.ErrServerClosed {
+			zapLogger.Fatal("failed to serve", zap.String("listener", name), zap.Error(err))
+		}
+	}()
+
+	return h
 }
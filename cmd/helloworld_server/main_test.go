@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	serverconfig "helloworld/pkg/serverconfig"
+)
+
+func TestMetricsNotOnGRPCPortByDefault(t *testing.T) {
+	metrics := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name      string
+		listeners func(l *serverconfig.ListenersConfig)
+		withAdmin bool
+	}{
+		{"default", func(l *serverconfig.ListenersConfig) {}, false},
+		{"own metrics listener", func(l *serverconfig.ListenersConfig) { l.Metrics.Address = ":9090" }, false},
+		{"served with the admin endpoints", func(l *serverconfig.ListenersConfig) { l.Metrics.Address = "" }, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listeners := serverconfig.Default().Listeners
+			test.listeners(&listeners)
+
+			adminMux := http.NewServeMux()
+			newMetricsMux(listeners, adminMux).Handle("/metrics", metrics)
+
+			w := httptest.NewRecorder()
+			adminMux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+			if served := w.Code == http.StatusOK; served != test.withAdmin {
+				t.Errorf("expected /metrics with the admin endpoints %v, got status %v", test.withAdmin, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,366 @@
+// Package main generates an Istio VirtualService that routes each tenant to the shard whose tenant config accepts
+// it, and the DestinationRules that go with it.
+//
+// By default each shard is routed to its own service with its own DestinationRule, rather than to a subset of one
+// service: the standalone_negs_* overlays run every shard in its own namespace, and a service and its subsets only
+// select pods in their own namespace.  --subsets routes to per-shard subsets of one service instead, for shards
+// that share a namespace and are told apart by a pod label.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	tenantroute "helloworld/pkg/tenantroute"
+
+	"gopkg.in/yaml.v3"
+)
+
+type objectMeta struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+type virtualService struct {
+	APIVersion string             `yaml:"apiVersion"`
+	Kind       string             `yaml:"kind"`
+	Metadata   objectMeta         `yaml:"metadata"`
+	Spec       virtualServiceSpec `yaml:"spec"`
+}
+
+type virtualServiceSpec struct {
+	Gateways []string    `yaml:"gateways,omitempty"`
+	Hosts    []string    `yaml:"hosts"`
+	HTTP     []httpRoute `yaml:"http"`
+}
+
+type httpRoute struct {
+	Name  string             `yaml:"name"`
+	Match []httpMatchRequest `yaml:"match,omitempty"`
+	Route []routeDestination `yaml:"route"`
+}
+
+type httpMatchRequest struct {
+	Headers        map[string]stringMatch `yaml:"headers,omitempty"`
+	WithoutHeaders map[string]stringMatch `yaml:"withoutHeaders,omitempty"`
+}
+
+type stringMatch struct {
+	Exact  string `yaml:"exact,omitempty"`
+	Prefix string `yaml:"prefix,omitempty"`
+	Regex  string `yaml:"regex,omitempty"`
+}
+
+type routeDestination struct {
+	Destination destination `yaml:"destination"`
+}
+
+type destination struct {
+	Host   string        `yaml:"host"`
+	Subset string        `yaml:"subset,omitempty"`
+	Port   *portSelector `yaml:"port,omitempty"`
+}
+
+type portSelector struct {
+	Number int `yaml:"number"`
+}
+
+type destinationRule struct {
+	APIVersion string              `yaml:"apiVersion"`
+	Kind       string              `yaml:"kind"`
+	Metadata   objectMeta          `yaml:"metadata"`
+	Spec       destinationRuleSpec `yaml:"spec"`
+}
+
+type destinationRuleSpec struct {
+	Host          string                 `yaml:"host"`
+	TrafficPolicy map[string]interface{} `yaml:"trafficPolicy,omitempty"`
+	Subsets       []subset               `yaml:"subsets,omitempty"`
+}
+
+type subset struct {
+	Name   string            `yaml:"name"`
+	Labels map[string]string `yaml:"labels"`
+}
+
+/* where the routes of each shard go */
+type shardTargets struct {
+	// format strings taking the shard name
+	service        string
+	shardNamespace string
+	drName         string
+
+	// route to a subset of this host per shard instead, selected by the label
+	subsets     bool
+	host        string
+	subsetLabel string
+	namespace   string
+
+	port *portSelector
+}
+
+func (s shardTargets) shardHost(shard string) string {
+	return fmt.Sprintf("%v.%v.svc.cluster.local", fmt.Sprintf(s.service, shard), fmt.Sprintf(s.shardNamespace, shard))
+}
+
+func (s shardTargets) destination(shard string) destination {
+	if s.subsets {
+		return destination{Host: s.host, Subset: shard, Port: s.port}
+	}
+
+	return destination{Host: s.shardHost(shard), Port: s.port}
+}
+
+/* one DestinationRule with a subset per shard, or one per shard in the shard's namespace */
+func (s shardTargets) destinationRules(shards []string) []destinationRule {
+	trafficPolicy := map[string]interface{}{
+		"loadBalancer": map[string]string{"simple": "ROUND_ROBIN"},
+		"tls":          map[string]string{"mode": "ISTIO_MUTUAL"},
+	}
+
+	if s.subsets {
+		subsets := make([]subset, 0, len(shards))
+		for _, shard := range shards {
+			subsets = append(subsets, subset{Name: shard, Labels: map[string]string{s.subsetLabel: shard}})
+		}
+
+		return []destinationRule{{
+			APIVersion: "networking.istio.io/v1alpha3",
+			Kind:       "DestinationRule",
+			Metadata:   objectMeta{Name: s.drName, Namespace: s.namespace},
+			Spec:       destinationRuleSpec{Host: s.host, TrafficPolicy: trafficPolicy, Subsets: subsets},
+		}}
+	}
+
+	drs := make([]destinationRule, 0, len(shards))
+	for _, shard := range shards {
+		drs = append(drs, destinationRule{
+			APIVersion: "networking.istio.io/v1alpha3",
+			Kind:       "DestinationRule",
+			Metadata:   objectMeta{Name: fmt.Sprintf(s.drName, shard), Namespace: fmt.Sprintf(s.shardNamespace, shard)},
+			Spec:       destinationRuleSpec{Host: s.shardHost(shard), TrafficPolicy: trafficPolicy},
+		})
+	}
+
+	return drs
+}
+
+func main() {
+	var shards tenantroute.ShardFlags
+	flag.Var(&shards, "shard", "SHARD=CONFIG, a shard and its tenant-config.yaml or ConfigMap manifest, repeat for each shard")
+	name := flag.String("name", "hellogrpc-route-external", "VirtualService name")
+	drName := flag.String("destination-rule", "", "format string for the name of each shard's DestinationRule, hellogrpc-%s by default, "+
+		"or the name of the one DestinationRule with --subsets, hellogrpc by default")
+	namespace := flag.String("namespace", "hellogrpc", "namespace of the VirtualService")
+	gateways := flag.String("gateways", "hellogrpc-gateway", "comma separated gateways the VirtualService applies to, empty for the mesh")
+	hosts := flag.String("hosts", "*", "comma separated hosts the VirtualService applies to")
+	service := flag.String("service", "helloworld-grpc-%s", "format string for the name of each shard's service, like the standalone_negs_* overlays' nameSuffix")
+	shardNamespace := flag.String("shard-namespace", "hellogrpc-%s", "format string for the namespace of each shard's service and DestinationRule")
+	subsets := flag.Bool("subsets", false, "route each shard to a subset of --host, for shards that share a namespace, "+
+		"instead of to its own service")
+	host := flag.String("host", "helloworld-grpc.hellogrpc.svc.cluster.local", "service host the subsets are of with --subsets")
+	subsetLabel := flag.String("subset-label", "deployment", "pod label holding the shard name with --subsets, like the standalone_negs_* overlays' commonLabels")
+	port := flag.Int("port", 50051, "destination service port, 0 to omit")
+	header := flag.String("header", tenantroute.DefaultTenantHeader, "tenant id header to match on")
+	uppercase := flag.Bool("uppercase-uuids", false, "also match upper case UUID prefixes")
+	defaultShard := flag.String("default-shard", "", "shard for tenants no shard accepts, by default they are not routed")
+	allowWiden := flag.Bool("allow-widen", false, "route on the first of two conditions on the header that Istio can't combine, "+
+		"instead of failing; the route is then wider than the config and the shard rejects the extra tenants")
+	flag.Parse()
+
+	if len(shards) == 0 {
+		log.Fatalf("at least one --shard is required")
+	}
+
+	routes, warnings, err := tenantroute.LoadShardRoutes(shards, tenantroute.Options{UppercaseUUIDs: *uppercase})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	for _, w := range warnings {
+		log.Printf("WARNING %v", w)
+	}
+
+	if *drName == "" {
+		*drName = "hellogrpc-%s"
+		if *subsets {
+			*drName = "hellogrpc"
+		}
+	}
+
+	targets := shardTargets{
+		service:        *service,
+		shardNamespace: *shardNamespace,
+		drName:         *drName,
+		subsets:        *subsets,
+		host:           *host,
+		subsetLabel:    *subsetLabel,
+		namespace:      *namespace,
+	}
+	if *port != 0 {
+		targets.port = &portSelector{Number: *port}
+	}
+
+	headerName := strings.ToLower(*header)
+
+	vs := virtualService{
+		APIVersion: "networking.istio.io/v1beta1",
+		Kind:       "VirtualService",
+		Metadata:   objectMeta{Name: *name, Namespace: *namespace},
+		Spec: virtualServiceSpec{
+			Gateways: splitList(*gateways),
+			Hosts:    splitList(*hosts),
+			HTTP:     make([]httpRoute, 0, len(routes)+1),
+		},
+	}
+
+	names := make([]string, 0, len(routes))
+
+	for _, route := range routes {
+		matches := make([]httpMatchRequest, 0, len(route.Matches))
+		for _, conjunction := range route.Matches {
+			match, err := matchRequest(headerName, conjunction)
+			if err != nil && !*allowWiden {
+				log.Fatalf("shard %v (%v): %v, rerun with --allow-widen to route on the first condition only", route.Name, route.Config, err)
+			}
+			if err != nil {
+				log.Printf("WARNING shard %v (%v): %v, routing on the first condition only", route.Name, route.Config, err)
+			}
+
+			matches = append(matches, match)
+		}
+
+		if len(matches) == 0 {
+			log.Printf("WARNING shard %v (%v) accepts no tenants, skipping its route", route.Name, route.Config)
+		} else {
+			vs.Spec.HTTP = append(vs.Spec.HTTP, httpRoute{
+				Name:  route.Name,
+				Match: matches,
+				Route: []routeDestination{{Destination: targets.destination(route.Name)}},
+			})
+		}
+
+		names = append(names, route.Name)
+	}
+
+	if *defaultShard != "" {
+		vs.Spec.HTTP = append(vs.Spec.HTTP, httpRoute{
+			Name:  "default",
+			Route: []routeDestination{{Destination: targets.destination(*defaultShard)}},
+		})
+	}
+
+	fmt.Printf("# generated by tenant_istio_gen from:\n")
+	for _, route := range routes {
+		fmt.Printf("#   %v: %v\n", route.Name, route.Config)
+	}
+
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	docs := []interface{}{vs}
+	for _, dr := range targets.destinationRules(names) {
+		docs = append(docs, dr)
+	}
+	for _, doc := range docs {
+		if err := enc.Encode(doc); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	enc.Close()
+}
+
+/*
+all header matches must hold, inverted ones go in withoutHeaders.  Returns an error along with a wider match if two
+conditions on the header can't be combined.
+*/
+func matchRequest(header string, conjunction tenantroute.Conjunction) (httpMatchRequest, error) {
+	req := httpMatchRequest{}
+	var widened error
+
+	for _, m := range conjunction {
+		target := &req.Headers
+		if m.Invert {
+			target = &req.WithoutHeaders
+		}
+
+		if *target == nil {
+			*target = make(map[string]stringMatch)
+		}
+
+		match := stringMatch{}
+		switch {
+		case m.Present:
+			match.Regex = ".*"
+		case m.Exact != "":
+			match.Exact = m.Exact
+		case m.Prefix != "":
+			match.Prefix = m.Prefix
+		case m.Suffix != "":
+			match.Regex = ".*" + regexp.QuoteMeta(m.Suffix)
+		case m.Regex != "":
+			match.Regex = m.Regex
+		}
+
+		if existing, ok := (*target)[header]; ok && m.Invert {
+			// a header can only appear once per match, not a and not b is not (a or b)
+			match = stringMatch{Regex: "(?:" + stringMatchRegex(existing) + ")|(?:" + stringMatchRegex(match) + ")"}
+		} else if ok {
+			combined, err := combineMatches(existing, match)
+			if err != nil && widened == nil {
+				widened = err
+			}
+			match = combined
+		}
+
+		(*target)[header] = match
+	}
+
+	return req, widened
+}
+
+/*
+RE2 has no lookahead, so two conditions on the same header can only be combined exactly when one of them is an
+exact match.  Otherwise the first one is returned with an error, routing on it alone is wider than the config.
+*/
+func combineMatches(a stringMatch, b stringMatch) (stringMatch, error) {
+	if a.Exact == "" && b.Exact != "" {
+		a, b = b, a
+	}
+
+	if a.Exact != "" {
+		if regexp.MustCompile("^(?:" + stringMatchRegex(b) + ")$").MatchString(a.Exact) {
+			return a, nil
+		}
+
+		// nothing can match both
+		return stringMatch{Regex: "$.^"}, nil
+	}
+
+	return a, fmt.Errorf("unable to combine %q and %q on one header", stringMatchRegex(a), stringMatchRegex(b))
+}
+
+func stringMatchRegex(m stringMatch) string {
+	switch {
+	case m.Exact != "":
+		return regexp.QuoteMeta(m.Exact)
+	case m.Prefix != "":
+		return regexp.QuoteMeta(m.Prefix) + ".*"
+	}
+
+	return m.Regex
+}
+
+func splitList(value string) []string {
+	list := make([]string, 0)
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+
+	return list
+}
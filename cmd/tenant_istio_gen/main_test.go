@@ -0,0 +1,129 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	tenantroute "helloworld/pkg/tenantroute"
+)
+
+func TestMatchRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		conjunction tenantroute.Conjunction
+		want        httpMatchRequest
+		widened     bool
+	}{
+		{
+			name:        "prefix",
+			conjunction: tenantroute.Conjunction{{Prefix: "3f"}},
+			want:        httpMatchRequest{Headers: map[string]stringMatch{"x-tenant-id": {Prefix: "3f"}}},
+		},
+		{
+			name:        "suffix becomes a regex",
+			conjunction: tenantroute.Conjunction{{Suffix: ".eu"}},
+			want:        httpMatchRequest{Headers: map[string]stringMatch{"x-tenant-id": {Regex: `.*\.eu`}}},
+		},
+		{
+			name:        "inverted matches are combined with an alternation",
+			conjunction: tenantroute.Conjunction{{Present: true}, {Suffix: "-test", Invert: true}, {Exact: "x", Invert: true}},
+			want: httpMatchRequest{
+				Headers:        map[string]stringMatch{"x-tenant-id": {Regex: ".*"}},
+				WithoutHeaders: map[string]stringMatch{"x-tenant-id": {Regex: `(?:.*-test)|(?:x)`}},
+			},
+		},
+		{
+			name:        "exact and a matching prefix",
+			conjunction: tenantroute.Conjunction{{Prefix: "acme-"}, {Exact: "acme-1"}},
+			want:        httpMatchRequest{Headers: map[string]stringMatch{"x-tenant-id": {Exact: "acme-1"}}},
+		},
+		{
+			name:        "exact and a prefix that can't both match",
+			conjunction: tenantroute.Conjunction{{Exact: "other"}, {Prefix: "acme-"}},
+			want:        httpMatchRequest{Headers: map[string]stringMatch{"x-tenant-id": {Regex: "$.^"}}},
+		},
+		{
+			name:        "prefix and suffix widen",
+			conjunction: tenantroute.Conjunction{{Prefix: "acme-"}, {Suffix: "-eu"}},
+			want:        httpMatchRequest{Headers: map[string]stringMatch{"x-tenant-id": {Prefix: "acme-"}}},
+			widened:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := matchRequest("x-tenant-id", test.conjunction)
+			if (err != nil) != test.widened {
+				t.Errorf("matchRequest() err = %v, want widened %v", err, test.widened)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("matchRequest() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestShardTargets(t *testing.T) {
+	port := &portSelector{Number: 50051}
+	trafficPolicy := map[string]interface{}{
+		"loadBalancer": map[string]string{"simple": "ROUND_ROBIN"},
+		"tls":          map[string]string{"mode": "ISTIO_MUTUAL"},
+	}
+
+	t.Run("per shard services", func(t *testing.T) {
+		targets := shardTargets{service: "helloworld-grpc-%s", shardNamespace: "hellogrpc-%s", drName: "hellogrpc-%s", port: port}
+
+		want := destination{Host: "helloworld-grpc-a.hellogrpc-a.svc.cluster.local", Port: port}
+		if got := targets.destination("a"); !reflect.DeepEqual(got, want) {
+			t.Errorf("destination() = %+v, want %+v", got, want)
+		}
+
+		drs := targets.destinationRules([]string{"a", "b"})
+		if len(drs) != 2 {
+			t.Fatalf("destinationRules() = %d rules, want one per shard", len(drs))
+		}
+
+		wantMeta := objectMeta{Name: "hellogrpc-b", Namespace: "hellogrpc-b"}
+		wantSpec := destinationRuleSpec{Host: "helloworld-grpc-b.hellogrpc-b.svc.cluster.local", TrafficPolicy: trafficPolicy}
+		if !reflect.DeepEqual(drs[1].Metadata, wantMeta) || !reflect.DeepEqual(drs[1].Spec, wantSpec) {
+			t.Errorf("destinationRules()[1] = %+v, want %+v %+v", drs[1], wantMeta, wantSpec)
+		}
+	})
+
+	t.Run("subsets", func(t *testing.T) {
+		targets := shardTargets{
+			service:        "helloworld-grpc-%s",
+			shardNamespace: "hellogrpc-%s",
+			drName:         "hellogrpc",
+			subsets:        true,
+			host:           "helloworld-grpc.hellogrpc.svc.cluster.local",
+			subsetLabel:    "deployment",
+			namespace:      "hellogrpc",
+			port:           port,
+		}
+
+		want := destination{Host: "helloworld-grpc.hellogrpc.svc.cluster.local", Subset: "a", Port: port}
+		if got := targets.destination("a"); !reflect.DeepEqual(got, want) {
+			t.Errorf("destination() = %+v, want %+v", got, want)
+		}
+
+		drs := targets.destinationRules([]string{"a", "b"})
+		if len(drs) != 1 {
+			t.Fatalf("destinationRules() = %d rules, want one with a subset per shard", len(drs))
+		}
+
+		wantMeta := objectMeta{Name: "hellogrpc", Namespace: "hellogrpc"}
+		wantSpec := destinationRuleSpec{
+			Host:          "helloworld-grpc.hellogrpc.svc.cluster.local",
+			TrafficPolicy: trafficPolicy,
+			Subsets: []subset{
+				{Name: "a", Labels: map[string]string{"deployment": "a"}},
+				{Name: "b", Labels: map[string]string{"deployment": "b"}},
+			},
+		}
+		if !reflect.DeepEqual(drs[0].Metadata, wantMeta) || !reflect.DeepEqual(drs[0].Spec, wantSpec) {
+			t.Errorf("destinationRules()[0] = %+v, want %+v %+v", drs[0], wantMeta, wantSpec)
+		}
+	})
+}
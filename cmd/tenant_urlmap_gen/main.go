@@ -0,0 +1,192 @@
+// Package main generates GCP URL map route rules that send each tenant to the shard whose tenant config accepts it.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	tenantroute "helloworld/pkg/tenantroute"
+)
+
+const (
+	formatHCL  = "hcl"
+	formatJSON = "json"
+
+	defaultHCLService  = "google_compute_backend_service.%s.id"
+	defaultJSONService = "global/backendServices/%s"
+
+	// url maps accept at most this many route rules per path matcher
+	maxRouteRules = 200
+)
+
+/* the compute API representation of a url map route rule */
+type routeRule struct {
+	Priority   int         `json:"priority"`
+	Service    string      `json:"service"`
+	MatchRules []matchRule `json:"matchRules"`
+}
+
+type matchRule struct {
+	PrefixMatch   string        `json:"prefixMatch"`
+	IgnoreCase    bool          `json:"ignoreCase"`
+	HeaderMatches []headerMatch `json:"headerMatches"`
+}
+
+type headerMatch struct {
+	HeaderName   string `json:"headerName"`
+	ExactMatch   string `json:"exactMatch,omitempty"`
+	PrefixMatch  string `json:"prefixMatch,omitempty"`
+	SuffixMatch  string `json:"suffixMatch,omitempty"`
+	RegexMatch   string `json:"regexMatch,omitempty"`
+	PresentMatch bool   `json:"presentMatch,omitempty"`
+	InvertMatch  bool   `json:"invertMatch,omitempty"`
+}
+
+func main() {
+	var shards tenantroute.ShardFlags
+	flag.Var(&shards, "shard", "BACKEND_SERVICE=CONFIG, the backend service for a shard and its tenant-config.yaml or ConfigMap manifest, repeat for each shard")
+	format := flag.String("format", formatHCL, "output format: hcl (terraform route_rules blocks) or json (compute API pathMatchers[].routeRules)")
+	service := flag.String("service", "", fmt.Sprintf("format string for the backend service reference, defaults to %q for hcl and %q for json", defaultHCLService, defaultJSONService))
+	header := flag.String("header", tenantroute.DefaultTenantHeader, "tenant id header to match on")
+	priority := flag.Int("priority", 1000, "priority of the first route rule, each following rule is one higher")
+	uppercase := flag.Bool("uppercase-uuids", false, "also match upper case UUID prefixes")
+	flag.Parse()
+
+	if len(shards) == 0 {
+		log.Fatalf("at least one --shard is required")
+	}
+
+	if *format != formatHCL && *format != formatJSON {
+		log.Fatalf("unknown format %q, must be %v or %v", *format, formatHCL, formatJSON)
+	}
+
+	if *service == "" {
+		*service = defaultHCLService
+		if *format == formatJSON {
+			*service = defaultJSONService
+		}
+	}
+
+	routes, warnings, err := tenantroute.LoadShardRoutes(shards, tenantroute.Options{UppercaseUUIDs: *uppercase})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	for _, w := range warnings {
+		log.Printf("WARNING %v", w)
+	}
+
+	rules := routeRules(routes, *service, *header, *priority)
+	if len(rules) > maxRouteRules {
+		log.Printf("WARNING generated %d route rules, a path matcher allows at most %d", len(rules), maxRouteRules)
+	}
+
+	if *format == formatJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(map[string]interface{}{"routeRules": rules}); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
+	writeHCL(os.Stdout, routes, rules)
+}
+
+/* one route rule per alternative, like the hand written rules in terraform/lb.tf */
+func routeRules(routes []tenantroute.ShardRoute, service string, header string, priority int) []routeRule {
+	rules := make([]routeRule, 0)
+
+	for _, route := range routes {
+		for _, conjunction := range route.Matches {
+			headerMatches := make([]headerMatch, 0, len(conjunction))
+			for _, m := range conjunction {
+				headerMatches = append(headerMatches, headerMatch{
+					HeaderName:   header,
+					ExactMatch:   m.Exact,
+					PrefixMatch:  m.Prefix,
+					SuffixMatch:  m.Suffix,
+					RegexMatch:   m.Regex,
+					PresentMatch: m.Present,
+					InvertMatch:  m.Invert,
+				})
+			}
+
+			rules = append(rules, routeRule{
+				Priority: priority,
+				Service:  fmt.Sprintf(service, route.Name),
+				MatchRules: []matchRule{{
+					PrefixMatch:   "/",
+					IgnoreCase:    true,
+					HeaderMatches: headerMatches,
+				}},
+			})
+			priority++
+		}
+	}
+
+	return rules
+}
+
+func writeHCL(w io.Writer, routes []tenantroute.ShardRoute, rules []routeRule) {
+	fmt.Fprintf(w, "    # generated by tenant_urlmap_gen from:\n")
+	for _, route := range routes {
+		fmt.Fprintf(w, "    #   %v: %v\n", route.Name, route.Config)
+	}
+
+	for _, rule := range rules {
+		fmt.Fprintf(w, "\n    route_rules {\n")
+		fmt.Fprintf(w, "      priority = %d\n", rule.Priority)
+		fmt.Fprintf(w, "      service = %v\n", rule.Service)
+
+		for _, m := range rule.MatchRules {
+			fmt.Fprintf(w, "      match_rules {\n")
+			fmt.Fprintf(w, "        prefix_match = %q\n", m.PrefixMatch)
+			fmt.Fprintf(w, "        ignore_case = %v\n", m.IgnoreCase)
+
+			for _, h := range m.HeaderMatches {
+				fields := []string{fmt.Sprintf("header_name = %q", h.HeaderName)}
+
+				switch {
+				case h.PresentMatch:
+					fields = append(fields, "present_match = true")
+				case h.ExactMatch != "":
+					fields = append(fields, fmt.Sprintf("exact_match = %v", hclString(h.ExactMatch)))
+				case h.PrefixMatch != "":
+					fields = append(fields, fmt.Sprintf("prefix_match = %v", hclString(h.PrefixMatch)))
+				case h.SuffixMatch != "":
+					fields = append(fields, fmt.Sprintf("suffix_match = %v", hclString(h.SuffixMatch)))
+				case h.RegexMatch != "":
+					fields = append(fields, fmt.Sprintf("regex_match = %v", hclString(h.RegexMatch)))
+				}
+
+				if h.InvertMatch {
+					fields = append(fields, "invert_match = true")
+				}
+
+				fmt.Fprintf(w, "        header_matches {\n")
+				for _, field := range fields {
+					fmt.Fprintf(w, "          %v\n", field)
+				}
+				fmt.Fprintf(w, "        }\n")
+			}
+
+			fmt.Fprintf(w, "      }\n")
+		}
+
+		fmt.Fprintf(w, "    }\n")
+	}
+}
+
+/* quote a string for HCL, which also treats ${ and %{ as template sequences */
+func hclString(s string) string {
+	quoted, _ := json.Marshal(s)
+
+	r := strings.NewReplacer("${", "$${", "%{", "%%{")
+	return r.Replace(string(quoted))
+}
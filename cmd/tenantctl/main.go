@@ -0,0 +1,262 @@
+// Package main implements tenantctl, a tool for validating and testing tenant configs.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	tenant "helloworld/pkg/tenant"
+)
+
+const usage = `Usage: tenantctl <command> [flags] <config>...
+
+Each config is a tenant-config.yaml or a ConfigMap manifest with a tenant-config.yaml key.
+
+Commands:
+  validate <config>...            check that each config loads
+  lint <config>...                validate, then warn about empty matchers, overlapping ranges and unreachable rules
+  check [--method /pkg.Service/Method] <tenantId> <config>...
+                                  show which configs accept the tenant and the rule that decided it, using the
+                                  method's policy if given
+  coverage <config>...            report gaps and overlaps in the UUID keyspace across a set of shard configs
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	args := flag.Args()[1:]
+
+	var ok bool
+	switch flag.Arg(0) {
+	case "validate":
+		ok = validate(args)
+	case "lint":
+		ok = lint(args)
+	case "check":
+		ok = check(args)
+	case "coverage":
+		ok = coverage(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+func requireConfigs(command string, files []string) {
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "%v: at least one config is required\n\n", command)
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+/* load every config, printing errors for the ones that fail.  Returns the loaded configs in order, nil for failures */
+func loadConfigs(files []string) ([]*tenant.TenantConfig, bool) {
+	configs := make([]*tenant.TenantConfig, len(files))
+	ok := true
+
+	for i, file := range files {
+		t, err := tenant.LoadTenantConfigFile(file)
+		if err != nil {
+			fmt.Printf("%v: ERROR %v\n", file, err)
+			ok = false
+			continue
+		}
+
+		configs[i] = t
+	}
+
+	return configs, ok
+}
+
+func validate(files []string) bool {
+	requireConfigs("validate", files)
+
+	configs, ok := loadConfigs(files)
+	for i, t := range configs {
+		if t != nil {
+			fmt.Printf("%v: OK (%v)\n", files[i], t.Precedence)
+		}
+	}
+
+	return ok
+}
+
+func lint(files []string) bool {
+	requireConfigs("lint", files)
+
+	configs, ok := loadConfigs(files)
+	for i, t := range configs {
+		if t == nil {
+			continue
+		}
+
+		warnings := t.Lint()
+		if len(warnings) == 0 {
+			fmt.Printf("%v: OK\n", files[i])
+			continue
+		}
+
+		ok = false
+		for _, w := range warnings {
+			fmt.Printf("%v: WARNING %v\n", files[i], w)
+		}
+	}
+
+	return ok
+}
+
+func check(args []string) bool {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	flags.Usage = flag.Usage
+	method := flags.String("method", "", "full gRPC method name, e.g. /helloworld.Greeter/StreamingHello")
+	flags.Parse(args)
+	args = flags.Args()
+
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "check: a tenant id is required\n\n")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	tenantId := args[0]
+	files := args[1:]
+	requireConfigs("check", files)
+
+	configs, ok := loadConfigs(files)
+
+	accepted := 0
+	for i, t := range configs {
+		if t == nil {
+			continue
+		}
+
+		decision := t.EvaluateMethod(*method, tenantId)
+		result := "DENY "
+		if decision.Allowed {
+			result = "ALLOW"
+			accepted++
+		}
+
+		fmt.Printf("%v: %v %v\n", files[i], result, decision.Reason())
+	}
+
+	switch {
+	case accepted == 0:
+		fmt.Printf("tenant %v is not accepted by any config\n", tenantId)
+		ok = false
+	case accepted > 1:
+		fmt.Printf("tenant %v is accepted by %d configs\n", tenantId, accepted)
+	}
+
+	return ok
+}
+
+type coverageSegment struct {
+	interval tenant.KeyInterval
+	configs  []string
+}
+
+func coverage(files []string) bool {
+	requireConfigs("coverage", files)
+
+	configs, ok := loadConfigs(files)
+
+	accepted := make([][]tenant.KeyInterval, len(files))
+	for i, t := range configs {
+		if t == nil {
+			continue
+		}
+
+		intervals, ignored := t.AcceptedUUIDKeyspace()
+		accepted[i] = intervals
+
+		if len(ignored) > 0 {
+			fmt.Printf("%v: NOTE rules not on the UUID keyspace are ignored: %v\n", files[i], strings.Join(ignored, ", "))
+		}
+	}
+
+	segments := sweep(files, accepted)
+	for _, seg := range segments {
+		switch {
+		case len(seg.configs) == 0:
+			fmt.Printf("GAP      %v\n", seg.interval)
+			ok = false
+		case len(seg.configs) > 1:
+			fmt.Printf("OVERLAP  %v  %v\n", seg.interval, strings.Join(seg.configs, ", "))
+			ok = false
+		default:
+			fmt.Printf("OK       %v  %v\n", seg.interval, seg.configs[0])
+		}
+	}
+
+	return ok
+}
+
+/* split the keyspace at every interval boundary and record which configs accept each segment */
+func sweep(files []string, accepted [][]tenant.KeyInterval) []coverageSegment {
+	keyspace := tenant.UUIDKeyspace()
+	one := big.NewInt(1)
+
+	boundaries := map[string]*big.Int{keyspace.Start.String(): keyspace.Start}
+	for _, intervals := range accepted {
+		for _, interval := range intervals {
+			boundaries[interval.Start.String()] = interval.Start
+			if interval.End.Cmp(keyspace.End) < 0 {
+				next := new(big.Int).Add(interval.End, one)
+				boundaries[next.String()] = next
+			}
+		}
+	}
+
+	starts := make([]*big.Int, 0, len(boundaries))
+	for _, b := range boundaries {
+		starts = append(starts, b)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Cmp(starts[j]) < 0 })
+
+	segments := make([]coverageSegment, 0, len(starts))
+	for i, start := range starts {
+		end := keyspace.End
+		if i+1 < len(starts) {
+			end = new(big.Int).Sub(starts[i+1], one)
+		}
+
+		covering := make([]string, 0)
+		for j, intervals := range accepted {
+			for _, interval := range intervals {
+				if interval.Start.Cmp(start) <= 0 && interval.End.Cmp(end) >= 0 {
+					covering = append(covering, files[j])
+					break
+				}
+			}
+		}
+
+		// merge with the previous segment if the same configs accept it
+		if n := len(segments); n > 0 && strings.Join(segments[n-1].configs, ",") == strings.Join(covering, ",") {
+			segments[n-1].interval.End = end
+			continue
+		}
+
+		segments = append(segments, coverageSegment{tenant.KeyInterval{Start: start, End: end}, covering})
+	}
+
+	return segments
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	tenant "helloworld/pkg/tenant"
+)
+
+func keyInterval(t *testing.T, start, end string) tenant.KeyInterval {
+	t.Helper()
+
+	s, err := tenant.ParseUUIDKey(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := tenant.ParseUUIDKey(end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tenant.KeyInterval{Start: s, End: e}
+}
+
+const (
+	minKey = "00000000-0000-0000-0000-000000000000"
+	maxKey = "ffffffff-ffff-ffff-ffff-ffffffffffff"
+)
+
+func TestSweep(t *testing.T) {
+	type segment struct {
+		interval string
+		configs  []string
+	}
+
+	tests := []struct {
+		name     string
+		accepted map[string][][2]string
+		want     []segment
+	}{
+		{
+			name: "full coverage",
+			accepted: map[string][][2]string{
+				"a": {{minKey, "7fffffff-ffff-ffff-ffff-ffffffffffff"}},
+				"b": {{"80000000-0000-0000-0000-000000000000", maxKey}},
+			},
+			want: []segment{
+				{minKey + " - 7fffffff-ffff-ffff-ffff-ffffffffffff", []string{"a"}},
+				{"80000000-0000-0000-0000-000000000000 - " + maxKey, []string{"b"}},
+			},
+		},
+		{
+			name: "gap",
+			accepted: map[string][][2]string{
+				"a": {{minKey, "3fffffff-ffff-ffff-ffff-ffffffffffff"}},
+				"b": {{"80000000-0000-0000-0000-000000000000", maxKey}},
+			},
+			want: []segment{
+				{minKey + " - 3fffffff-ffff-ffff-ffff-ffffffffffff", []string{"a"}},
+				{"40000000-0000-0000-0000-000000000000 - 7fffffff-ffff-ffff-ffff-ffffffffffff", []string{}},
+				{"80000000-0000-0000-0000-000000000000 - " + maxKey, []string{"b"}},
+			},
+		},
+		{
+			name: "overlap",
+			accepted: map[string][][2]string{
+				"a": {{minKey, "8fffffff-ffff-ffff-ffff-ffffffffffff"}},
+				"b": {{"80000000-0000-0000-0000-000000000000", maxKey}},
+			},
+			want: []segment{
+				{minKey + " - 7fffffff-ffff-ffff-ffff-ffffffffffff", []string{"a"}},
+				{"80000000-0000-0000-0000-000000000000 - 8fffffff-ffff-ffff-ffff-ffffffffffff", []string{"a", "b"}},
+				{"90000000-0000-0000-0000-000000000000 - " + maxKey, []string{"b"}},
+			},
+		},
+		{
+			name: "adjacent intervals of one config merge",
+			accepted: map[string][][2]string{
+				"a": {
+					{minKey, "3fffffff-ffff-ffff-ffff-ffffffffffff"},
+					{"40000000-0000-0000-0000-000000000000", maxKey},
+				},
+			},
+			want: []segment{
+				{minKey + " - " + maxKey, []string{"a"}},
+			},
+		},
+		{
+			name: "a config nested in another",
+			accepted: map[string][][2]string{
+				"a": {{minKey, maxKey}},
+				"b": {{"40000000-0000-0000-0000-000000000000", "4fffffff-ffff-ffff-ffff-ffffffffffff"}},
+			},
+			want: []segment{
+				{minKey + " - 3fffffff-ffff-ffff-ffff-ffffffffffff", []string{"a"}},
+				{"40000000-0000-0000-0000-000000000000 - 4fffffff-ffff-ffff-ffff-ffffffffffff", []string{"a", "b"}},
+				{"50000000-0000-0000-0000-000000000000 - " + maxKey, []string{"a"}},
+			},
+		},
+		{
+			name: "a single key",
+			accepted: map[string][][2]string{
+				"a": {{"00000000-0000-0000-0000-000000000001", "00000000-0000-0000-0000-000000000001"}},
+			},
+			want: []segment{
+				{minKey + " - " + minKey, []string{}},
+				{"00000000-0000-0000-0000-000000000001 - 00000000-0000-0000-0000-000000000001", []string{"a"}},
+				{"00000000-0000-0000-0000-000000000002 - " + maxKey, []string{}},
+			},
+		},
+		{
+			name:     "nothing accepted",
+			accepted: map[string][][2]string{"a": nil},
+			want: []segment{
+				{minKey + " - " + maxKey, []string{}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files := []string{"a", "b"}[:len(test.accepted)]
+			accepted := make([][]tenant.KeyInterval, len(files))
+			for i, file := range files {
+				for _, bounds := range test.accepted[file] {
+					accepted[i] = append(accepted[i], keyInterval(t, bounds[0], bounds[1]))
+				}
+			}
+
+			got := make([]segment, 0)
+			for _, seg := range sweep(files, accepted) {
+				got = append(got, segment{seg.interval.String(), seg.configs})
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("sweep() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSweepAcceptedKeyspace(t *testing.T) {
+	configs := []string{
+		`
+allowed_tenants:
+- range: [{type: uuid, start: "00000000-0000-0000-0000-000000000000", end: "7fffffff-ffff-ffff-ffff-ffffffffffff"}]
+denied_tenants:
+- range: [{type: hex-prefix, start: "10", end: "1f"}]
+`,
+		`
+allowed_tenants:
+- range: [{type: hex-prefix, start: "8", end: "f"}]
+- range: [{type: hex-prefix, start: "1", end: "1"}]
+`,
+	}
+
+	files := []string{"a", "b"}
+	accepted := make([][]tenant.KeyInterval, len(configs))
+	for i, yaml := range configs {
+		config, err := tenant.ParseTenantConfig([]byte(yaml))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		accepted[i], _ = config.AcceptedUUIDKeyspace()
+	}
+
+	for _, seg := range sweep(files, accepted) {
+		if len(seg.configs) != 1 {
+			t.Errorf("segment %v accepted by %v, want exactly one config", seg.interval, seg.configs)
+		}
+	}
+}
@@ -10,7 +10,7 @@ require (
 	github.com/soheilhy/cmux v0.1.5
 	go.uber.org/zap v1.10.0
 	golang.org/x/net v0.0.0-20220526153639-5463443f8c37 // indirect
-	google.golang.org/genproto v0.0.0-20220526192754-51939a95c655 // indirect
+	google.golang.org/genproto v0.0.0-20220526192754-51939a95c655
 	google.golang.org/grpc v1.46.2
 	google.golang.org/protobuf v1.28.0
 	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
@@ -2,16 +2,19 @@ package gcp
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 )
 
+/* the metadata server, overridden by the server config */
+var MetaDataURL = "http://metadata/computeMetadata/v1/"
+
 func GetMetaData(ctx context.Context, path string) *string {
-	metaDataURL := "http://metadata/computeMetadata/v1/"
 	req, _ := http.NewRequest(
 		"GET",
-		metaDataURL+path,
+		MetaDataURL+path,
 		nil,
 	)
 	req.Header.Add("Metadata-Flavor", "Google")
@@ -26,6 +29,15 @@ func GetMetaData(ctx context.Context, path string) *string {
 	return nil
 }
 
+/* CheckMetaData returns an error if the metadata server can't be reached, e.g. when not running on GCP */
+func CheckMetaData(ctx context.Context) error {
+	if GetMetaData(ctx, "project/project-id") == nil {
+		return fmt.Errorf("metadata server is not reachable")
+	}
+
+	return nil
+}
+
 func makeRequest(r *http.Request) (int, []byte) {
 	//transport := http.Transport{DisableKeepAlives: true}
 	//octr := &ochttp.Transport{}
@@ -35,7 +47,9 @@ func makeRequest(r *http.Request) (int, []byte) {
 	if err != nil {
 		message := "Unable to call backend: " + err.Error()
 		log.Printf(message)
+		return 0, nil
 	}
+	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		message := "Unable to read response body: " + err.Error()
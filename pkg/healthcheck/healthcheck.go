@@ -4,20 +4,69 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+
+	grpc_health "google.golang.org/grpc/health/grpc_health_v1"
+
+	tenant "helloworld/pkg/tenant"
 )
 
-type HttpHealthCheckHandler struct {}
+type HttpHealthCheckHandler struct {
+	TenantConfig *tenant.TenantConfigStore
+	TenantConfigFailurePolicy string
+	// the gRPC health status, so the GLB and kubelet probes agree
+	Health *Registry
+}
 
 func (h *HttpHealthCheckHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
+	statusCode := http.StatusOK
+	resp := make(map[string]interface{})
+	resp["message"] = "Status OK"
+
+	if h.TenantConfig != nil {
+		resp["tenantConfigFailurePolicy"] = h.TenantConfigFailurePolicy
+		resp["tenantConfig"] = "loaded"
+
+		if fallback := h.TenantConfig.Get().Fallback(); fallback != "" {
+			resp["tenantConfig"] = fallback
+		}
+
+		if !h.TenantConfig.Serving() {
+			statusCode = http.StatusServiceUnavailable
+			resp["message"] = "Tenant config could not be loaded, rejecting all tenants"
+		}
+	}
+
+	if h.Health != nil {
+		status, failures := h.Health.Status("")
+		resp["status"] = status.String()
+
+		if len(failures) > 0 {
+			resp["failing"] = failures
+		}
+
+		services := make(map[string]string)
+		for _, service := range h.Health.Services() {
+			if service != "" {
+				serviceStatus, _ := h.Health.Status(service)
+				services[service] = serviceStatus.String()
+			}
+		}
+		resp["services"] = services
+
+		if status != grpc_health.HealthCheckResponse_SERVING {
+			if statusCode == http.StatusOK {
+				resp["message"] = "Not serving"
+			}
+			statusCode = http.StatusServiceUnavailable
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	resp := make(map[string]string)
-	resp["message"] = "Status OK"
+	w.WriteHeader(statusCode)
+
 	jsonResp, err := json.Marshal(resp)
 	if err != nil {
 		log.Fatalf("Error happened in JSON marshal. Err: %s", err)
 	}
 	w.Write(jsonResp)
-}
\ No newline at end of file
+}
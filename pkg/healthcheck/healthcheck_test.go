@@ -0,0 +1,104 @@
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	tenant "helloworld/pkg/tenant"
+)
+
+/* a tenant config store holding what LoadTenantConfigFromSource falls back to when the source fails */
+func fallbackStore(t *testing.T, failurePolicy string) *tenant.TenantConfigStore {
+	t.Helper()
+
+	source, err := tenant.NewTenantConfigSource("file:///nonexistent/tenant-config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := tenant.LoadTenantConfigFromSource(context.Background(), source, failurePolicy)
+	if err == nil {
+		t.Fatalf("LoadTenantConfigFromSource() error = nil, want the source to fail")
+	}
+
+	return tenant.NewTenantConfigStore(config)
+}
+
+func getHealthz(t *testing.T, h http.Handler) (int, map[string]interface{}) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	resp := make(map[string]interface{})
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unable to parse /healthz response %q: %v", w.Body.String(), err)
+	}
+
+	return w.Code, resp
+}
+
+func TestHealthzFailurePolicy(t *testing.T) {
+	loaded, err := tenant.ParseTenantConfig([]byte("allowed_tenants:\n- exactMatch: [tenant-a]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		store         *tenant.TenantConfigStore
+		policy        string
+		code          int
+		tenantConfig  string
+		messageChange bool
+	}{
+		{"loaded", tenant.NewTenantConfigStore(loaded), tenant.FailurePolicyFailClosed, http.StatusOK, "loaded", false},
+		{"fail-open", fallbackStore(t, tenant.FailurePolicyFailOpen), tenant.FailurePolicyFailOpen, http.StatusOK, tenant.FailurePolicyFailOpen, false},
+		{"fail-closed", fallbackStore(t, tenant.FailurePolicyFailClosed), tenant.FailurePolicyFailClosed, http.StatusServiceUnavailable, tenant.FailurePolicyFailClosed, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, resp := getHealthz(t, &HttpHealthCheckHandler{TenantConfig: test.store, TenantConfigFailurePolicy: test.policy})
+
+			if code != test.code {
+				t.Errorf("status code = %v, want %v", code, test.code)
+			}
+
+			if resp["tenantConfig"] != test.tenantConfig {
+				t.Errorf("tenantConfig = %v, want %v", resp["tenantConfig"], test.tenantConfig)
+			}
+
+			if resp["tenantConfigFailurePolicy"] != test.policy {
+				t.Errorf("tenantConfigFailurePolicy = %v, want %v", resp["tenantConfigFailurePolicy"], test.policy)
+			}
+
+			if changed := resp["message"] != "Status OK"; changed != test.messageChange {
+				t.Errorf("message = %v", resp["message"])
+			}
+		})
+	}
+}
+
+func TestHealthzFailClosedRecovers(t *testing.T) {
+	store := fallbackStore(t, tenant.FailurePolicyFailClosed)
+	h := &HttpHealthCheckHandler{TenantConfig: store, TenantConfigFailurePolicy: tenant.FailurePolicyFailClosed}
+
+	if code, _ := getHealthz(t, h); code != http.StatusServiceUnavailable {
+		t.Fatalf("status code = %v while fail-closed, want %v", code, http.StatusServiceUnavailable)
+	}
+
+	// the config watcher swaps in a config once the source is back
+	loaded, err := tenant.ParseTenantConfig([]byte("allowed_tenants:\n- exactMatch: [tenant-a]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Set(loaded)
+
+	if code, resp := getHealthz(t, h); code != http.StatusOK || resp["tenantConfig"] != "loaded" {
+		t.Errorf("status code = %v, tenantConfig = %v after the config loaded, want %v and loaded", code, resp["tenantConfig"], http.StatusOK)
+	}
+}
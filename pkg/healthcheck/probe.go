@@ -0,0 +1,108 @@
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/* a named check behind a probe endpoint */
+type probeCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+/*
+an HTTP probe like /livez, /readyz or /startupz, 200 if all of its checks pass and 503 otherwise.  ?verbose lists
+the result and latency of every check.
+*/
+type Probe struct {
+	name string
+
+	mu     sync.RWMutex
+	checks []probeCheck
+}
+
+type probeResponse struct {
+	Status string        `json:"status"`
+	Probe  string        `json:"probe"`
+	Failed []string      `json:"failed,omitempty"`
+	Checks []checkResult `json:"checks,omitempty"`
+}
+
+type checkResult struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Latency string `json:"latency"`
+}
+
+func NewProbe(name string) *Probe {
+	return &Probe{name: name}
+}
+
+func (p *Probe) Register(name string, check func(ctx context.Context) error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.checks = append(p.checks, probeCheck{name, check})
+}
+
+/*
+RegisterConditions adds the conditions every service needs, like the overall status, so e.g. a condition only one
+service needs doesn't take every pod out of the Service
+*/
+func (p *Probe) RegisterConditions(conditions []Condition) {
+	for _, c := range conditions {
+		if len(c.Services) == 0 {
+			p.Register(c.Name, c.Check)
+		}
+	}
+}
+
+func (p *Probe) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.RLock()
+	checks := p.checks
+	p.mu.RUnlock()
+
+	resp := probeResponse{Status: "ok", Probe: p.name}
+	results := make([]checkResult, 0, len(checks))
+
+	for _, c := range checks {
+		ctx, cancel := context.WithTimeout(r.Context(), conditionTimeout)
+		start := time.Now()
+		err := c.check(ctx)
+		latency := time.Since(start)
+		cancel()
+
+		result := checkResult{Name: c.name, Status: "ok", Latency: latency.String()}
+		if err != nil {
+			result.Status = "failed"
+			result.Error = err.Error()
+			resp.Status = "failed"
+			resp.Failed = append(resp.Failed, c.name)
+		}
+		results = append(results, result)
+	}
+
+	if _, verbose := r.URL.Query()["verbose"]; verbose {
+		resp.Checks = results
+	}
+
+	statusCode := http.StatusOK
+	if resp.Status != "ok" {
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	jsonResp, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(jsonResp)
+}
@@ -0,0 +1,207 @@
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func getProbe(t *testing.T, server *httptest.Server, path string) (int, probeResponse) {
+	t.Helper()
+
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%v: expected application/json, got %q", path, ct)
+	}
+
+	var body probeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("%v: unable to parse the response: %v", path, err)
+	}
+
+	return resp.StatusCode, body
+}
+
+func checkNames(results []checkResult) []string {
+	names := make([]string, 0, len(results))
+	for _, r := range results {
+		names = append(names, r.Name)
+	}
+
+	return names
+}
+
+func TestProbes(t *testing.T) {
+	started := &testCondition{err: errors.New("server is still starting")}
+	tenantConfig := &testCondition{}
+	metadata := &testCondition{}
+	draining := &testCondition{}
+
+	conditions := []Condition{
+		{Name: "tenant-config", Check: tenantConfig.check},
+		{Name: "metadata", Check: metadata.check, Services: []string{"helloworld.Greeter"}},
+	}
+
+	livez := NewProbe("livez")
+	livez.Register("ping", func(context.Context) error { return nil })
+
+	startupz := NewProbe("startupz")
+	startupz.Register("started", started.check)
+
+	readyz := NewProbe("readyz")
+	readyz.Register("started", started.check)
+	readyz.RegisterConditions(conditions)
+	readyz.Register("not-draining", draining.check)
+
+	mux := http.NewServeMux()
+	mux.Handle("/livez", livez)
+	mux.Handle("/startupz", startupz)
+	mux.Handle("/readyz", readyz)
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	type probes map[string]int
+
+	steps := []struct {
+		name   string
+		setup  func()
+		codes  probes
+		failed map[string][]string
+	}{
+		{
+			name:   "starting",
+			setup:  func() {},
+			codes:  probes{"/livez": http.StatusOK, "/startupz": http.StatusServiceUnavailable, "/readyz": http.StatusServiceUnavailable},
+			failed: map[string][]string{"/startupz": {"started"}, "/readyz": {"started"}},
+		},
+		{
+			name:  "serving",
+			setup: func() { started.set(nil) },
+			codes: probes{"/livez": http.StatusOK, "/startupz": http.StatusOK, "/readyz": http.StatusOK},
+		},
+		{
+			name:  "a condition one service needs fails",
+			setup: func() { metadata.set(errors.New("metadata server unreachable")) },
+			codes: probes{"/livez": http.StatusOK, "/startupz": http.StatusOK, "/readyz": http.StatusOK},
+		},
+		{
+			name:   "a condition every service needs fails",
+			setup:  func() { tenantConfig.set(errors.New("not loaded")) },
+			codes:  probes{"/livez": http.StatusOK, "/startupz": http.StatusOK, "/readyz": http.StatusServiceUnavailable},
+			failed: map[string][]string{"/readyz": {"tenant-config"}},
+		},
+		{
+			name: "draining",
+			setup: func() {
+				tenantConfig.set(nil)
+				draining.set(errors.New("server is shutting down"))
+			},
+			codes:  probes{"/livez": http.StatusOK, "/startupz": http.StatusOK, "/readyz": http.StatusServiceUnavailable},
+			failed: map[string][]string{"/readyz": {"not-draining"}},
+		},
+	}
+
+	for _, step := range steps {
+		step.setup()
+
+		for path, code := range step.codes {
+			gotCode, body := getProbe(t, server, path)
+			if gotCode != code {
+				t.Errorf("%v: expected %v to be %v, got %v", step.name, path, code, gotCode)
+			}
+
+			wantStatus := "ok"
+			if code != http.StatusOK {
+				wantStatus = "failed"
+			}
+			if body.Status != wantStatus || body.Probe != path[1:] {
+				t.Errorf("%v: expected %v status %q, got %+v", step.name, path, wantStatus, body)
+			}
+
+			if !reflect.DeepEqual(body.Failed, step.failed[path]) {
+				t.Errorf("%v: expected %v to fail %v, got %v", step.name, path, step.failed[path], body.Failed)
+			}
+
+			if body.Checks != nil {
+				t.Errorf("%v: expected %v to only list the checks with ?verbose, got %v", step.name, path, body.Checks)
+			}
+		}
+	}
+
+	// the service-scoped metadata condition isn't part of /readyz at all
+	_, body := getProbe(t, server, "/readyz?verbose")
+	if names := checkNames(body.Checks); !reflect.DeepEqual(names, []string{"started", "tenant-config", "not-draining"}) {
+		t.Errorf("expected the readyz checks without metadata, got %v", names)
+	}
+}
+
+func TestProbeVerbose(t *testing.T) {
+	p := NewProbe("readyz")
+	p.Register("ok", func(context.Context) error { return nil })
+	p.Register("broken", func(context.Context) error { return errors.New("broken") })
+	p.Register("slow", func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); !ok {
+			return errors.New("expected a deadline")
+		}
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	code, body := getProbe(t, server, "/readyz?verbose")
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("expected %v, got %v", http.StatusServiceUnavailable, code)
+	}
+
+	want := []checkResult{
+		{Name: "ok", Status: "ok"},
+		{Name: "broken", Status: "failed", Error: "broken"},
+		{Name: "slow", Status: "ok"},
+	}
+
+	if len(body.Checks) != len(want) {
+		t.Fatalf("expected %d checks, got %+v", len(want), body.Checks)
+	}
+
+	for i, check := range body.Checks {
+		latency, err := time.ParseDuration(check.Latency)
+		if err != nil {
+			t.Errorf("%v: expected a duration latency, got %q", check.Name, check.Latency)
+		}
+
+		if check.Name == "slow" && latency < time.Millisecond {
+			t.Errorf("expected the slow check's latency to be measured, got %v", latency)
+		}
+
+		check.Latency = ""
+		if check != want[i] {
+			t.Errorf("expected %+v, got %+v", want[i], check)
+		}
+	}
+
+	if !reflect.DeepEqual(body.Failed, []string{"broken"}) {
+		t.Errorf("expected broken to fail, got %v", body.Failed)
+	}
+}
+
+func TestProbeWithoutChecks(t *testing.T) {
+	server := httptest.NewServer(NewProbe("livez"))
+	defer server.Close()
+
+	if code, body := getProbe(t, server, "/?verbose"); code != http.StatusOK || body.Status != "ok" || len(body.Checks) != 0 {
+		t.Errorf("expected an empty probe to pass, got %v %+v", code, body)
+	}
+}
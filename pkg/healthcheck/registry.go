@@ -0,0 +1,194 @@
+package healthcheck
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/health"
+	grpc_health "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	DefaultCheckInterval = 5 * time.Second
+
+	// how long a single condition gets to answer
+	conditionTimeout = 2 * time.Second
+)
+
+/* something the server needs to serve, e.g. a loaded tenant config */
+type Condition struct {
+	Name string
+	// Check returns why the condition doesn't hold, nil if it does
+	Check func(ctx context.Context) error
+	// the services that can't serve without it, all of them and the overall status if empty
+	Services []string
+}
+
+/*
+keeps the serving status of each service up to date from the conditions, and serves it through the standard gRPC
+health service so Check and Watch agree with /healthz.  The overall status ("") only depends on the conditions every
+service needs, so e.g. the GLB health check isn't failed by something only one service uses.
+*/
+type Registry struct {
+	server     *health.Server
+	services   []string
+	conditions []Condition
+	logger     *zap.Logger
+
+	mu sync.RWMutex
+	// why each failing condition fails, and which conditions fail each service
+	failures map[string]string
+	failing  map[string][]string
+	statuses map[string]grpc_health.HealthCheckResponse_ServingStatus
+	shutdown bool
+}
+
+/* NewRegistry reports the services as NOT_SERVING until the conditions were checked */
+func NewRegistry(logger *zap.Logger, services ...string) *Registry {
+	r := &Registry{
+		server:   health.NewServer(),
+		services: append([]string{""}, services...),
+		logger:   logger,
+		failures: make(map[string]string),
+		failing:  make(map[string][]string),
+		statuses: make(map[string]grpc_health.HealthCheckResponse_ServingStatus),
+	}
+
+	for _, service := range r.services {
+		r.setStatus(service, grpc_health.HealthCheckResponse_NOT_SERVING)
+	}
+
+	return r
+}
+
+func (r *Registry) Register(c Condition) {
+	r.conditions = append(r.conditions, c)
+}
+
+/* HealthServer implements grpc.health.v1.Health */
+func (r *Registry) HealthServer() grpc_health.HealthServer {
+	return r.server
+}
+
+/* Watch checks the conditions every interval until the context is done */
+func (r *Registry) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Check(ctx)
+		}
+	}
+}
+
+/* Check evaluates the conditions and updates the status of every service */
+func (r *Registry) Check(ctx context.Context) {
+	failures := make(map[string]string)
+	failing := make(map[string][]string)
+
+	for _, c := range r.conditions {
+		checkCtx, cancel := context.WithTimeout(ctx, conditionTimeout)
+		err := c.Check(checkCtx)
+		cancel()
+
+		if err == nil {
+			continue
+		}
+
+		failures[c.Name] = err.Error()
+
+		services := c.Services
+		if len(services) == 0 {
+			services = r.services
+		}
+
+		for _, service := range services {
+			failing[service] = append(failing[service], c.Name)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.failures = failures
+	r.failing = failing
+	if r.shutdown {
+		return
+	}
+
+	for _, service := range r.services {
+		status := grpc_health.HealthCheckResponse_SERVING
+		if len(failing[service]) > 0 {
+			status = grpc_health.HealthCheckResponse_NOT_SERVING
+		}
+
+		if r.statuses[service] == status {
+			continue
+		}
+
+		r.logger.Info("Health status changed",
+			zap.String("service", service),
+			zap.String("status", status.String()),
+			zap.Strings("failing", failing[service]),
+		)
+		r.setStatus(service, status)
+	}
+}
+
+func (r *Registry) setStatus(service string, status grpc_health.HealthCheckResponse_ServingStatus) {
+	r.statuses[service] = status
+	r.server.SetServingStatus(service, status)
+}
+
+/* Shutdown reports every service as NOT_SERVING from now on */
+func (r *Registry) Shutdown() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.shutdown = true
+	for _, service := range r.services {
+		r.statuses[service] = grpc_health.HealthCheckResponse_NOT_SERVING
+	}
+	r.server.Shutdown()
+
+	r.logger.Info("Health status changed, shutting down",
+		zap.Strings("services", r.services),
+		zap.String("status", grpc_health.HealthCheckResponse_NOT_SERVING.String()),
+	)
+}
+
+/* Status returns the serving status of a service and why the conditions it needs fail, keyed by condition name */
+func (r *Registry) Status(service string) (grpc_health.HealthCheckResponse_ServingStatus, map[string]string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	status, ok := r.statuses[service]
+	if !ok {
+		return grpc_health.HealthCheckResponse_SERVICE_UNKNOWN, nil
+	}
+
+	failures := make(map[string]string)
+	for _, name := range r.failing[service] {
+		failures[name] = r.failures[name]
+	}
+
+	if r.shutdown {
+		failures["shutdown"] = "server is shutting down"
+	}
+
+	return status, failures
+}
+
+/* Services returns the registered services, "" first */
+func (r *Registry) Services() []string {
+	services := append([]string{}, r.services...)
+	sort.Strings(services)
+	return services
+}
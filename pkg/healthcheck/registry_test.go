@@ -0,0 +1,293 @@
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	grpc_health "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+/* a condition that fails with whatever the test sets */
+type testCondition struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (c *testCondition) set(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+func (c *testCondition) check(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func newTestRegistry(logger *zap.Logger) (*Registry, *testCondition, *testCondition) {
+	r := NewRegistry(logger, "helloworld.Greeter", "other.Service")
+
+	all, greeter := &testCondition{}, &testCondition{}
+	r.Register(Condition{Name: "tenant-config", Check: all.check})
+	r.Register(Condition{Name: "metadata", Check: greeter.check, Services: []string{"helloworld.Greeter"}})
+
+	return r, all, greeter
+}
+
+func TestRegistryStatus(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	r, all, greeter := newTestRegistry(zap.New(core))
+
+	const (
+		serving    = grpc_health.HealthCheckResponse_SERVING
+		notServing = grpc_health.HealthCheckResponse_NOT_SERVING
+	)
+
+	type statuses map[string]grpc_health.HealthCheckResponse_ServingStatus
+
+	steps := []struct {
+		name     string
+		all      error
+		greeter  error
+		check    bool
+		want     statuses
+		failures map[string]map[string]string
+		changes  int
+	}{
+		{
+			name: "not checked yet",
+			want: statuses{"": notServing, "helloworld.Greeter": notServing, "other.Service": notServing},
+		},
+		{
+			name:    "healthy",
+			check:   true,
+			want:    statuses{"": serving, "helloworld.Greeter": serving, "other.Service": serving},
+			changes: 3,
+		},
+		{
+			name:    "a condition one service needs",
+			greeter: errors.New("metadata server unreachable"),
+			check:   true,
+			want:    statuses{"": serving, "helloworld.Greeter": notServing, "other.Service": serving},
+			failures: map[string]map[string]string{
+				"helloworld.Greeter": {"metadata": "metadata server unreachable"},
+				"":                   {},
+			},
+			changes: 1,
+		},
+		{
+			name:    "a condition every service needs",
+			all:     errors.New("not loaded"),
+			greeter: errors.New("metadata server unreachable"),
+			check:   true,
+			want:    statuses{"": notServing, "helloworld.Greeter": notServing, "other.Service": notServing},
+			failures: map[string]map[string]string{
+				"helloworld.Greeter": {"tenant-config": "not loaded", "metadata": "metadata server unreachable"},
+				"other.Service":      {"tenant-config": "not loaded"},
+				"":                   {"tenant-config": "not loaded"},
+			},
+			changes: 2,
+		},
+		{
+			name:    "recovered",
+			check:   true,
+			want:    statuses{"": serving, "helloworld.Greeter": serving, "other.Service": serving},
+			changes: 3,
+		},
+		{
+			name:    "unchanged",
+			check:   true,
+			want:    statuses{"": serving, "helloworld.Greeter": serving, "other.Service": serving},
+			changes: 0,
+		},
+	}
+
+	for _, step := range steps {
+		all.set(step.all)
+		greeter.set(step.greeter)
+		logged := logs.Len()
+
+		if step.check {
+			r.Check(context.Background())
+		}
+
+		for service, want := range step.want {
+			status, failures := r.Status(service)
+			if status != want {
+				t.Errorf("%v: expected %q to be %v, got %v", step.name, service, want, status)
+			}
+
+			if wantFailures, ok := step.failures[service]; ok && !reflect.DeepEqual(failures, wantFailures) {
+				t.Errorf("%v: expected %q failures %v, got %v", step.name, service, wantFailures, failures)
+			}
+
+			// the gRPC health service agrees
+			resp, err := r.HealthServer().Check(context.Background(), &grpc_health.HealthCheckRequest{Service: service})
+			if err != nil || resp.GetStatus() != want {
+				t.Errorf("%v: expected Check(%q) to be %v, got %v, %v", step.name, service, want, resp.GetStatus(), err)
+			}
+		}
+
+		if changes := logs.Len() - logged; changes != step.changes {
+			t.Errorf("%v: expected %d status changes logged, got %d", step.name, step.changes, changes)
+		}
+	}
+
+	if status, failures := r.Status("unknown.Service"); status != grpc_health.HealthCheckResponse_SERVICE_UNKNOWN || failures != nil {
+		t.Errorf("expected an unknown service to be %v, got %v %v", grpc_health.HealthCheckResponse_SERVICE_UNKNOWN, status, failures)
+	}
+
+	if services := r.Services(); !reflect.DeepEqual(services, []string{"", "helloworld.Greeter", "other.Service"}) {
+		t.Errorf("expected the registered services, got %v", services)
+	}
+}
+
+func TestRegistryConditionTimeout(t *testing.T) {
+	r := NewRegistry(zap.NewNop())
+
+	var deadline bool
+	r.Register(Condition{Name: "slow", Check: func(ctx context.Context) error {
+		_, deadline = ctx.Deadline()
+		return ctx.Err()
+	}})
+
+	r.Check(context.Background())
+
+	if !deadline {
+		t.Errorf("expected each condition to get a deadline")
+	}
+
+	if status, _ := r.Status(""); status != grpc_health.HealthCheckResponse_SERVING {
+		t.Errorf("expected %v, got %v", grpc_health.HealthCheckResponse_SERVING, status)
+	}
+}
+
+/* a Health Watch stream that records the statuses it is sent */
+type watchStream struct {
+	grpc_health.Health_WatchServer
+	ctx      context.Context
+	statuses chan grpc_health.HealthCheckResponse_ServingStatus
+}
+
+func (s *watchStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *watchStream) Send(resp *grpc_health.HealthCheckResponse) error {
+	s.statuses <- resp.GetStatus()
+	return nil
+}
+
+func (s *watchStream) SetHeader(metadata.MD) error {
+	return nil
+}
+
+func (s *watchStream) SendHeader(metadata.MD) error {
+	return nil
+}
+
+func (s *watchStream) SetTrailer(metadata.MD) {}
+
+func (s *watchStream) SendMsg(m interface{}) error {
+	return nil
+}
+
+func (s *watchStream) RecvMsg(m interface{}) error {
+	return nil
+}
+
+func expectStatus(t *testing.T, statuses chan grpc_health.HealthCheckResponse_ServingStatus, want grpc_health.HealthCheckResponse_ServingStatus) {
+	t.Helper()
+
+	select {
+	case status := <-statuses:
+		if status != want {
+			t.Errorf("expected %v, got %v", want, status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected %v to be sent", want)
+	}
+}
+
+func TestRegistryWatch(t *testing.T) {
+	r, all, greeter := newTestRegistry(zap.NewNop())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	greeterStream := &watchStream{ctx: ctx, statuses: make(chan grpc_health.HealthCheckResponse_ServingStatus, 10)}
+	go r.HealthServer().Watch(&grpc_health.HealthCheckRequest{Service: "helloworld.Greeter"}, greeterStream)
+
+	otherStream := &watchStream{ctx: ctx, statuses: make(chan grpc_health.HealthCheckResponse_ServingStatus, 10)}
+	go r.HealthServer().Watch(&grpc_health.HealthCheckRequest{Service: "other.Service"}, otherStream)
+
+	expectStatus(t, greeterStream.statuses, grpc_health.HealthCheckResponse_NOT_SERVING)
+	expectStatus(t, otherStream.statuses, grpc_health.HealthCheckResponse_NOT_SERVING)
+
+	// the registry checks the conditions on its own
+	go r.Watch(ctx, time.Millisecond)
+
+	expectStatus(t, greeterStream.statuses, grpc_health.HealthCheckResponse_SERVING)
+	expectStatus(t, otherStream.statuses, grpc_health.HealthCheckResponse_SERVING)
+
+	greeter.set(errors.New("metadata server unreachable"))
+	expectStatus(t, greeterStream.statuses, grpc_health.HealthCheckResponse_NOT_SERVING)
+
+	all.set(errors.New("not loaded"))
+	expectStatus(t, otherStream.statuses, grpc_health.HealthCheckResponse_NOT_SERVING)
+
+	greeter.set(nil)
+	all.set(nil)
+	expectStatus(t, greeterStream.statuses, grpc_health.HealthCheckResponse_SERVING)
+	expectStatus(t, otherStream.statuses, grpc_health.HealthCheckResponse_SERVING)
+
+	// only changes are sent
+	select {
+	case status := <-otherStream.statuses:
+		t.Errorf("expected no update without a change, got %v", status)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestRegistryShutdown(t *testing.T) {
+	r, _, _ := newTestRegistry(zap.NewNop())
+	r.Check(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream := &watchStream{ctx: ctx, statuses: make(chan grpc_health.HealthCheckResponse_ServingStatus, 10)}
+	go r.HealthServer().Watch(&grpc_health.HealthCheckRequest{Service: ""}, stream)
+	expectStatus(t, stream.statuses, grpc_health.HealthCheckResponse_SERVING)
+
+	r.Shutdown()
+	expectStatus(t, stream.statuses, grpc_health.HealthCheckResponse_NOT_SERVING)
+
+	// healthy conditions don't bring it back
+	r.Check(context.Background())
+
+	for _, service := range r.Services() {
+		status, failures := r.Status(service)
+		if status != grpc_health.HealthCheckResponse_NOT_SERVING {
+			t.Errorf("expected %q to be %v after shutdown, got %v", service, grpc_health.HealthCheckResponse_NOT_SERVING, status)
+		}
+
+		if _, ok := failures["shutdown"]; !ok {
+			t.Errorf("expected %q to report the shutdown, got %v", service, failures)
+		}
+
+		resp, err := r.HealthServer().Check(context.Background(), &grpc_health.HealthCheckRequest{Service: service})
+		if err != nil || resp.GetStatus() != grpc_health.HealthCheckResponse_NOT_SERVING {
+			t.Errorf("expected Check(%q) to be %v after shutdown, got %v, %v", service, grpc_health.HealthCheckResponse_NOT_SERVING, resp.GetStatus(), err)
+		}
+	}
+}
@@ -25,10 +25,10 @@ const (
 type HelloServer struct {
 	pb.GreeterServer
 
-	ServerTenantConfig tenant.TenantConfig
+	ServerTenantConfig *tenant.TenantConfigStore
 }
 
-func NewHelloServer(tenantConfig tenant.TenantConfig) *HelloServer {
+func NewHelloServer(tenantConfig *tenant.TenantConfigStore) *HelloServer {
 	s := &HelloServer{
 		ServerTenantConfig: tenantConfig,
 	}
@@ -85,12 +85,14 @@ func getHelloReply(ctx context.Context, in *pb.HelloRequest, clientTargetTenantI
 	return result, nil
 }
 
-func (s *HelloServer) validateTenantId(tenantId string) (error) {
-	if !s.ServerTenantConfig.CheckTenantId(tenantId) {
-		return status.Error(codes.InvalidArgument, "Wrong Tenant-Id for instance")
+/* the tenant is authorized by tenant.AuthorizationInterceptor before the handlers run */
+func authorizedTenantId(ctx context.Context) (string, error) {
+	identity, ok := tenant.FromContext(ctx)
+	if !ok {
+		return "", status.Error(codes.Internal, "No tenant identity, is the tenant authorization interceptor installed?")
 	}
 
-	return nil
+	return identity.TenantId, nil
 }
 
 // SayHello implements helloworld.GreeterServer
@@ -98,12 +100,7 @@ func (s *HelloServer) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.He
 	p, _ := peer.FromContext(ctx)
 	frontendip := p.Addr.String()
 
-	clientTargetTenantId, err := tenant.GetTenantId(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	err = s.validateTenantId(clientTargetTenantId)
+	clientTargetTenantId, err := authorizedTenantId(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -123,12 +120,7 @@ func (s *HelloServer) StreamingHello(stream pb.Greeter_StreamingHelloServer) err
 	p, _ := peer.FromContext(stream.Context())
 	frontendip := p.Addr.String()
 
-	clientTargetTenantId, err := tenant.GetTenantId(stream.Context())
-	if err != nil {
-		return err
-	}
-
-	err = s.validateTenantId(clientTargetTenantId)
+	clientTargetTenantId, err := authorizedTenantId(stream.Context())
 	if err != nil {
 		return err
 	}
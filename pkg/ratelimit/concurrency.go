@@ -0,0 +1,39 @@
+package ratelimit
+
+import (
+	"sync"
+)
+
+/* counts in-flight calls per key, e.g. open streams per tenant */
+type ConcurrencyLimiter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func NewConcurrencyLimiter() *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		counts: make(map[string]int),
+	}
+}
+
+/* Acquire takes a slot if fewer than max are in use, max <= 0 is unlimited.  Every successful Acquire needs a Release */
+func (c *ConcurrencyLimiter) Acquire(key string, max int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if max > 0 && c.counts[key] >= max {
+		return false
+	}
+
+	c.counts[key]++
+	return true
+}
+
+func (c *ConcurrencyLimiter) Release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.counts[key]--; c.counts[key] <= 0 {
+		delete(c.counts, key)
+	}
+}
@@ -0,0 +1,96 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	c := NewConcurrencyLimiter()
+
+	for i := 0; i < 2; i++ {
+		if !c.Acquire("tenant-a", 2) {
+			t.Fatalf("acquire %d: expected a slot", i)
+		}
+	}
+	if c.Acquire("tenant-a", 2) {
+		t.Fatal("expected no slot over the limit")
+	}
+
+	// slots are per key
+	if !c.Acquire("tenant-b", 2) {
+		t.Error("expected tenant-b to have its own slots")
+	}
+
+	c.Release("tenant-a")
+	if !c.Acquire("tenant-a", 2) {
+		t.Error("expected a released slot to be available")
+	}
+
+	// max <= 0 is unlimited
+	for i := 0; i < 10; i++ {
+		if !c.Acquire("tenant-c", 0) {
+			t.Fatalf("acquire %d: expected an unlimited slot", i)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		c.Release("tenant-c")
+	}
+	if _, ok := c.counts["tenant-c"]; ok {
+		t.Error("expected the key to be dropped once every slot is released")
+	}
+}
+
+func TestConcurrencyLimitStreams(t *testing.T) {
+	r := newTestInterceptor(t, "- exactMatch: [tenant-a]\n  max_concurrent_streams: 1\n")
+	ss := &fakeServerStream{ctx: tenantContext(context.Background(), "tenant-a")}
+
+	var nested error
+	err := r.RateLimitStreamInterceptor(nil, ss, &grpc.StreamServerInfo{}, func(srv interface{}, stream grpc.ServerStream) error {
+		// a second stream while the first is open
+		nested = r.RateLimitStreamInterceptor(nil, ss, &grpc.StreamServerInfo{}, func(interface{}, grpc.ServerStream) error { return nil })
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected the first stream to open, got %v", err)
+	}
+	if status.Code(nested) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted for a second concurrent stream, got %v", nested)
+	}
+
+	// the slot is released when the stream ends
+	if err := r.RateLimitStreamInterceptor(nil, ss, &grpc.StreamServerInfo{}, func(interface{}, grpc.ServerStream) error { return nil }); err != nil {
+		t.Errorf("expected a new stream once the first ended, got %v", err)
+	}
+}
+
+func TestConcurrencyLimitUnary(t *testing.T) {
+	r := newTestInterceptor(t, "- exactMatch: [tenant-a]\n  max_in_flight_unary: 1\n")
+	ctx := tenantContext(context.Background(), "tenant-a")
+	info := &grpc.UnaryServerInfo{}
+
+	var nested error
+	_, err := r.RateLimitUnaryInterceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		_, nested = r.RateLimitUnaryInterceptor(ctx, nil, info, func(context.Context, interface{}) (interface{}, error) { return nil, nil })
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("expected the first call to be allowed, got %v", err)
+	}
+	if status.Code(nested) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted for a second in-flight call, got %v", nested)
+	}
+
+	// streams and unary calls are limited separately
+	ss := &fakeServerStream{ctx: ctx}
+	_, err = r.RateLimitUnaryInterceptor(ctx, nil, info, func(context.Context, interface{}) (interface{}, error) {
+		return nil, r.RateLimitStreamInterceptor(nil, ss, &grpc.StreamServerInfo{}, func(interface{}, grpc.ServerStream) error { return nil })
+	})
+	if err != nil {
+		t.Errorf("expected a stream while a unary call is in flight, got %v", err)
+	}
+}
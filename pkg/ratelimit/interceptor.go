@@ -0,0 +1,291 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	tenant "helloworld/pkg/tenant"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+/* google.rpc.ErrorInfo reasons of limit rejections, in the tenant error domain */
+const (
+	ReasonRateLimited        = "RATE_LIMITED"
+	ReasonConcurrencyLimited = "CONCURRENCY_LIMITED"
+)
+
+/* the kind label of tenant_concurrency_limited_total */
+const (
+	kindStream = "stream"
+	kindUnary  = "unary"
+)
+
+/*
+applies the tenant_limits from the live tenant config: the rate limit to unary calls, opening a stream and each
+message received on a stream, and the concurrency limits to open streams and in-flight unary calls.  Unary calls and
+new streams over the rate limit are rejected, while stream messages wait for a token so a long-lived stream is slowed
+down rather than torn down.  It reads the tenant from the context, so it has to run after
+tenant.AuthorizationInterceptor.
+*/
+type RateLimitInterceptor struct {
+	store   *tenant.TenantConfigStore
+	limiter *Limiter
+	streams *ConcurrencyLimiter
+	unary   *ConcurrencyLimiter
+	metrics *rateLimitMetrics
+}
+
+type rateLimitMetrics struct {
+	limited            prometheus.CounterVec
+	delayed            prometheus.CounterVec
+	concurrencyLimited prometheus.CounterVec
+}
+
+type rateLimitedServerStream struct {
+	grpc.ServerStream
+	interceptor *RateLimitInterceptor
+	tenantId    string
+}
+
+func NewRateLimitInterceptor(store *tenant.TenantConfigStore) (*RateLimitInterceptor, error) {
+	r := &RateLimitInterceptor{
+		store:   store,
+		limiter: NewLimiter(),
+		streams: NewConcurrencyLimiter(),
+		unary:   NewConcurrencyLimiter(),
+		metrics: &rateLimitMetrics{},
+	}
+
+	if err := r.metrics.init(); err != nil {
+		return nil, fmt.Errorf("unable to register rate limit metrics: %v", err)
+	}
+
+	return r, nil
+}
+
+func (metrics *rateLimitMetrics) init() error {
+	metrics.limited = *prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tenant_rate_limited_total",
+			Help: "Number of unary calls and streams rejected by the per-tenant rate limit",
+		},
+		[]string{"tenantId"},
+	)
+
+	metrics.delayed = *prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tenant_rate_limit_delayed_total",
+			Help: "Number of stream messages that waited for the per-tenant rate limit",
+		},
+		[]string{"tenantId"},
+	)
+
+	metrics.concurrencyLimited = *prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tenant_concurrency_limited_total",
+			Help: "Number of streams and unary calls rejected by the per-tenant concurrency limits",
+		},
+		[]string{"tenantId", "kind"},
+	)
+
+	for _, c := range []prometheus.Collector{metrics.limited, metrics.delayed, metrics.concurrencyLimited} {
+		if err := prometheus.Register(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+acquire a stream or unary slot for the tenant, returns a ResourceExhausted error if it already has the maximum open.
+The returned release func must be called when the call finishes.
+*/
+func (r *RateLimitInterceptor) acquire(ctx context.Context, tenantId string, kind string) (func(), error) {
+	limiter, max := r.unary, 0
+	if limit := r.store.Get().Limit(tenantId); limit != nil {
+		max = limit.MaxInFlightUnary
+		if kind == kindStream {
+			max = limit.MaxConcurrentStreams
+		}
+	}
+
+	if kind == kindStream {
+		limiter = r.streams
+	}
+
+	if !limiter.Acquire(tenantId, max) {
+		r.metrics.concurrencyLimited.WithLabelValues(tenantId, kind).Inc()
+
+		ctxzap.Extract(ctx).Info("Concurrency limited tenant",
+			zap.String("tenantId", tenantId),
+			zap.String("kind", kind),
+			zap.Int("max", max),
+		)
+
+		return nil, concurrencyLimitedError(tenantId, kind, max)
+	}
+
+	return func() { limiter.Release(tenantId) }, nil
+}
+
+func concurrencyLimitedError(tenantId string, kind string, max int) error {
+	message := fmt.Sprintf("Too many concurrent streams for tenant %v, at most %v", tenantId, max)
+	if kind == kindUnary {
+		message = fmt.Sprintf("Too many in-flight calls for tenant %v, at most %v", tenantId, max)
+	}
+
+	st := status.New(codes.ResourceExhausted, message)
+
+	withDetails, err := st.WithDetails(
+		&errdetails.ErrorInfo{
+			Reason: ReasonConcurrencyLimited,
+			Domain: tenant.ErrorDomain,
+			Metadata: map[string]string{
+				tenant.MetadataTenantId: tenantId,
+				"kind":                  kind,
+				"max":                   strconv.Itoa(max),
+			},
+		},
+	)
+	if err != nil {
+		return st.Err()
+	}
+
+	return withDetails.Err()
+}
+
+/* returns a ResourceExhausted error if the tenant is over its limit */
+func (r *RateLimitInterceptor) take(ctx context.Context, tenantId string) error {
+	limit := r.store.Get().Limit(tenantId)
+	if limit == nil || limit.RequestsPerSecond <= 0 {
+		return nil
+	}
+
+	ok, retryAfter := r.limiter.Allow(tenantId, limit.RequestsPerSecond, limit.Burst)
+	if ok {
+		return nil
+	}
+
+	r.metrics.limited.WithLabelValues(tenantId).Inc()
+
+	ctxzap.Extract(ctx).Info("Rate limited tenant",
+		zap.String("tenantId", tenantId),
+		zap.Float64("requestsPerSecond", limit.RequestsPerSecond),
+		zap.Int("burst", limit.Burst),
+		zap.Duration("retryAfter", retryAfter),
+	)
+
+	return rateLimitedError(tenantId, limit, retryAfter)
+}
+
+/* waits until the tenant has a token, returns an error if the context is done first */
+func (r *RateLimitInterceptor) wait(ctx context.Context, tenantId string) error {
+	delayed := false
+
+	for {
+		// the limit may change while waiting when the tenant config is reloaded
+		limit := r.store.Get().Limit(tenantId)
+		if limit == nil || limit.RequestsPerSecond <= 0 {
+			return nil
+		}
+
+		ok, retryAfter := r.limiter.Allow(tenantId, limit.RequestsPerSecond, limit.Burst)
+		if ok {
+			return nil
+		}
+
+		if !delayed {
+			delayed = true
+			r.metrics.delayed.WithLabelValues(tenantId).Inc()
+		}
+
+		timer := time.NewTimer(retryAfter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return status.FromContextError(ctx.Err()).Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func rateLimitedError(tenantId string, limit *tenant.TenantLimit, retryAfter time.Duration) error {
+	st := status.Newf(codes.ResourceExhausted, "Rate limit exceeded for tenant %v: %v requests per second, burst %v",
+		tenantId, limit.RequestsPerSecond, limit.Burst)
+
+	withDetails, err := st.WithDetails(
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)},
+		&errdetails.ErrorInfo{
+			Reason: ReasonRateLimited,
+			Domain: tenant.ErrorDomain,
+			Metadata: map[string]string{
+				tenant.MetadataTenantId: tenantId,
+			},
+		},
+	)
+	if err != nil {
+		return st.Err()
+	}
+
+	return withDetails.Err()
+}
+
+func (r *RateLimitInterceptor) RateLimitUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	identity, ok := tenant.FromContext(ctx)
+	if !ok {
+		// exempt from tenant authorization, e.g. health checks
+		return handler(ctx, req)
+	}
+
+	release, err := r.acquire(ctx, identity.TenantId, kindUnary)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := r.take(ctx, identity.TenantId); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (r *RateLimitInterceptor) RateLimitStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	identity, ok := tenant.FromContext(ss.Context())
+	if !ok {
+		return handler(srv, ss)
+	}
+
+	release, err := r.acquire(ss.Context(), identity.TenantId, kindStream)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// opening the stream counts like a unary call
+	if err := r.take(ss.Context(), identity.TenantId); err != nil {
+		return err
+	}
+
+	return handler(srv, &rateLimitedServerStream{ss, r, identity.TenantId})
+}
+
+/* every message received on the stream counts against the tenant's limit, waiting for a token instead of failing */
+func (stream *rateLimitedServerStream) RecvMsg(m interface{}) error {
+	if err := stream.interceptor.wait(stream.Context(), stream.tenantId); err != nil {
+		return err
+	}
+
+	return stream.ServerStream.RecvMsg(m)
+}
@@ -0,0 +1,158 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	tenant "helloworld/pkg/tenant"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	testMetrics     *rateLimitMetrics
+	testMetricsOnce sync.Once
+)
+
+/* the metrics are registered globally, so every test interceptor shares one set */
+func newTestInterceptor(t *testing.T, limits string) *RateLimitInterceptor {
+	t.Helper()
+
+	testMetricsOnce.Do(func() {
+		testMetrics = &rateLimitMetrics{}
+		if err := testMetrics.init(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	config, err := tenant.ParseTenantConfig([]byte("allowed_tenants:\n- exactMatch: [\"*\"]\ntenant_limits:\n" + limits))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &RateLimitInterceptor{
+		store:   tenant.NewTenantConfigStore(config),
+		limiter: NewLimiter(),
+		streams: NewConcurrencyLimiter(),
+		unary:   NewConcurrencyLimiter(),
+		metrics: testMetrics,
+	}
+}
+
+/* a server stream that counts the messages received */
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	received int
+}
+
+func (s *fakeServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeServerStream) RecvMsg(m interface{}) error {
+	s.received++
+	return nil
+}
+
+func tenantContext(ctx context.Context, tenantId string) context.Context {
+	return tenant.NewContext(ctx, tenant.TenantIdentity{TenantId: tenantId})
+}
+
+func TestNewRateLimitInterceptorRegistrationError(t *testing.T) {
+	newTestInterceptor(t, "- exactMatch: [tenant-a]\n  requests_per_second: 1\n")
+
+	// the metrics are already registered by the test interceptor
+	r, err := NewRateLimitInterceptor(nil)
+	if err == nil || r != nil {
+		t.Errorf("expected an error registering the metrics twice, got %v, %v", r, err)
+	}
+}
+
+func TestRateLimitUnaryInterceptor(t *testing.T) {
+	r := newTestInterceptor(t, "- exactMatch: [tenant-a]\n  requests_per_second: 1\n  burst: 1\n")
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+
+	ctx := tenantContext(context.Background(), "tenant-a")
+	if _, err := r.RateLimitUnaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler); err != nil {
+		t.Fatalf("expected the first call to be allowed, got %v", err)
+	}
+
+	_, err := r.RateLimitUnaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+
+	// tenants without a limit and calls without a tenant aren't limited
+	for _, ctx := range []context.Context{tenantContext(context.Background(), "tenant-b"), context.Background()} {
+		for i := 0; i < 3; i++ {
+			if _, err := r.RateLimitUnaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler); err != nil {
+				t.Fatalf("expected an unlimited call, got %v", err)
+			}
+		}
+	}
+}
+
+func TestRateLimitStreamOpen(t *testing.T) {
+	r := newTestInterceptor(t, "- exactMatch: [tenant-a]\n  requests_per_second: 1\n  burst: 1\n")
+	handler := func(srv interface{}, ss grpc.ServerStream) error { return nil }
+
+	ss := &fakeServerStream{ctx: tenantContext(context.Background(), "tenant-a")}
+	if err := r.RateLimitStreamInterceptor(nil, ss, &grpc.StreamServerInfo{}, handler); err != nil {
+		t.Fatalf("expected the first stream to open, got %v", err)
+	}
+
+	err := r.RateLimitStreamInterceptor(nil, ss, &grpc.StreamServerInfo{}, handler)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted opening a stream over the limit, got %v", err)
+	}
+}
+
+func TestRateLimitStreamMessagesWait(t *testing.T) {
+	// opening the stream takes the burst, each message then waits 50ms for a token
+	r := newTestInterceptor(t, "- exactMatch: [tenant-a]\n  requests_per_second: 20\n  burst: 1\n")
+
+	ss := &fakeServerStream{ctx: tenantContext(context.Background(), "tenant-a")}
+	start := time.Now()
+	err := r.RateLimitStreamInterceptor(nil, ss, &grpc.StreamServerInfo{}, func(srv interface{}, stream grpc.ServerStream) error {
+		for i := 0; i < 3; i++ {
+			if err := stream.RecvMsg(nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected messages over the limit to wait instead of failing, got %v", err)
+	}
+
+	if ss.received != 3 {
+		t.Errorf("expected 3 messages, got %d", ss.received)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected the messages to be delayed by the rate limit, took %v", elapsed)
+	}
+}
+
+func TestRateLimitStreamWaitCancelled(t *testing.T) {
+	// the bucket never refills, so a message can only wait until the stream ends
+	r := newTestInterceptor(t, "- exactMatch: [tenant-a]\n  requests_per_second: 0.001\n  burst: 1\n")
+
+	ctx, cancel := context.WithCancel(tenantContext(context.Background(), "tenant-a"))
+	ss := &fakeServerStream{ctx: ctx}
+
+	err := r.RateLimitStreamInterceptor(nil, ss, &grpc.StreamServerInfo{}, func(srv interface{}, stream grpc.ServerStream) error {
+		time.AfterFunc(10*time.Millisecond, cancel)
+		return stream.RecvMsg(nil)
+	})
+	if status.Code(err) != codes.Canceled {
+		t.Fatalf("expected Canceled once the stream's context is done, got %v", err)
+	}
+	if ss.received != 0 {
+		t.Errorf("expected no message to be received while waiting, got %d", ss.received)
+	}
+}
@@ -0,0 +1,114 @@
+// Package ratelimit implements per-tenant token bucket rate limiting for the gRPC server.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// buckets that have refilled completely are dropped after this long, a full bucket is the same as a new one
+	idleBucketTimeout = time.Minute
+)
+
+/* refills at rate tokens per second up to burst, each request takes one token */
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate float64, burst int, now time.Time) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+	}
+	b.last = now
+}
+
+/* SetLimit changes the rate and burst, keeping the tokens already in the bucket up to the new burst */
+func (b *TokenBucket) SetLimit(rate float64, burst int, now time.Time) {
+	b.refill(now)
+	b.rate = rate
+	b.burst = float64(burst)
+	b.tokens = math.Min(b.tokens, b.burst)
+}
+
+/* Take takes a token if there is one, otherwise returns how long until the next one is available */
+func (b *TokenBucket) Take(now time.Time) (bool, time.Duration) {
+	b.refill(now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	if b.rate <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+
+	wait := (1 - b.tokens) / b.rate
+	return false, time.Duration(math.Ceil(wait * float64(time.Second)))
+}
+
+func (b *TokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+/* a token bucket per key, e.g. per tenant */
+type Limiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*TokenBucket
+	lastSweep time.Time
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{
+		buckets: make(map[string]*TokenBucket),
+	}
+}
+
+/* Allow takes a token from the key's bucket, creating it or updating its limits as needed */
+func (l *Limiter) Allow(key string, rate float64, burst int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = NewTokenBucket(rate, burst, now)
+		l.buckets[key] = b
+	} else if b.rate != rate || b.burst != float64(burst) {
+		// the tenant config was reloaded
+		b.SetLimit(rate, burst, now)
+	}
+
+	return b.Take(now)
+}
+
+/* drop buckets that have refilled, so tenants that come and go don't grow the map forever */
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleBucketTimeout {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > idleBucketTimeout && b.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Unix(1000, 0)
+	b := NewTokenBucket(2, 3, start)
+
+	// the burst is available straight away
+	for i := 0; i < 3; i++ {
+		if ok, _ := b.Take(start); !ok {
+			t.Fatalf("take %d: expected a token from the burst", i)
+		}
+	}
+
+	ok, wait := b.Take(start)
+	if ok {
+		t.Fatal("expected the bucket to be empty")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("expected to wait 500ms for the next token at 2/s, got %v", wait)
+	}
+
+	if ok, _ := b.Take(start.Add(499 * time.Millisecond)); ok {
+		t.Error("expected no token before 500ms")
+	}
+	if ok, _ := b.Take(start.Add(500 * time.Millisecond)); !ok {
+		t.Error("expected a token after 500ms")
+	}
+
+	// refills up to the burst and no further
+	later := start.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := b.Take(later); !ok {
+			t.Fatalf("take %d: expected the bucket to refill", i)
+		}
+	}
+	if ok, _ := b.Take(later); ok {
+		t.Error("expected the bucket to refill only up to the burst")
+	}
+}
+
+func TestTokenBucketSetLimit(t *testing.T) {
+	start := time.Unix(1000, 0)
+	b := NewTokenBucket(1, 10, start)
+
+	// lowering the burst drops the tokens above it
+	b.SetLimit(1, 2, start)
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.Take(start); !ok {
+			t.Fatalf("take %d: expected a token", i)
+		}
+	}
+	if ok, _ := b.Take(start); ok {
+		t.Error("expected the tokens to be capped at the new burst")
+	}
+
+	// raising the burst doesn't add tokens
+	b.SetLimit(1, 20, start)
+	if ok, _ := b.Take(start); ok {
+		t.Error("expected raising the burst to keep the bucket empty")
+	}
+
+	// a zero rate never refills
+	b.SetLimit(0, 20, start)
+	ok, wait := b.Take(start.Add(time.Hour))
+	if ok {
+		t.Error("expected no tokens at rate 0")
+	}
+	if wait <= time.Hour {
+		t.Errorf("expected an unbounded wait at rate 0, got %v", wait)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter()
+
+	if ok, _ := l.Allow("tenant-a", 1, 1); !ok {
+		t.Fatal("expected the first call to be allowed")
+	}
+	if ok, wait := l.Allow("tenant-a", 1, 1); ok || wait <= 0 {
+		t.Errorf("expected the second call to wait, got %v, %v", ok, wait)
+	}
+
+	// buckets are per key
+	if ok, _ := l.Allow("tenant-b", 1, 1); !ok {
+		t.Error("expected tenant-b to have its own bucket")
+	}
+
+	// a reloaded limit applies to the existing bucket
+	if ok, _ := l.Allow("tenant-a", 1, 5); ok {
+		t.Error("expected raising the burst to keep the bucket empty")
+	}
+}
+
+func TestLimiterSweep(t *testing.T) {
+	l := NewLimiter()
+	start := time.Unix(1000, 0)
+
+	l.buckets["idle"] = NewTokenBucket(1, 1, start)
+	l.buckets["busy"] = NewTokenBucket(1, 100, start)
+	l.buckets["busy"].Take(start.Add(idleBucketTimeout))
+
+	l.sweep(start.Add(idleBucketTimeout + time.Second))
+
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("expected the full idle bucket to be dropped")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("expected the recently used bucket to be kept")
+	}
+}
@@ -0,0 +1,82 @@
+package serverconfig
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* the interceptors the server can chain, by name */
+const (
+	InterceptorPrometheus    = "prometheus"
+	InterceptorTags          = "tags"
+	InterceptorLogging       = "logging"
+	InterceptorPeerIdentity  = "peer-identity"
+	InterceptorAuthorization = "authorization"
+	InterceptorTenantMetrics = "tenant-metrics"
+	InterceptorRateLimit     = "rate-limit"
+	// streams only, closes the streams left at the drain deadline
+	InterceptorDrain    = "drain"
+	InterceptorRecovery = "recovery"
+)
+
+/* DefaultInterceptors is the chain a call goes through when the config doesn't set one, outermost first */
+var DefaultInterceptors = []string{
+	InterceptorPrometheus,
+	InterceptorTags,
+	InterceptorLogging,
+	InterceptorPeerIdentity,
+	InterceptorAuthorization,
+	InterceptorTenantMetrics,
+	InterceptorRateLimit,
+	InterceptorDrain,
+	InterceptorRecovery,
+}
+
+/* interceptors that read what an earlier one put in the context */
+var interceptorRequires = map[string]string{
+	// tags the peer's certificate identity on the request tags
+	InterceptorPeerIdentity: InterceptorTags,
+	// count and limit the tenant authorization identified
+	InterceptorTenantMetrics: InterceptorAuthorization,
+	InterceptorRateLimit:     InterceptorAuthorization,
+}
+
+/* ValidateInterceptors checks a chain only has known interceptors, once each, after the ones they depend on */
+func ValidateInterceptors(chain []string) error {
+	position := make(map[string]int, len(chain))
+
+	for i, name := range chain {
+		if !knownInterceptor(name) {
+			return fmt.Errorf("unknown interceptor %q, must be one of %v", name, strings.Join(DefaultInterceptors, ", "))
+		}
+
+		if _, ok := position[name]; ok {
+			return fmt.Errorf("interceptor %q is in the chain twice", name)
+		}
+
+		position[name] = i
+	}
+
+	for _, name := range chain {
+		required, ok := interceptorRequires[name]
+		if !ok {
+			continue
+		}
+
+		if p, ok := position[required]; !ok || p > position[name] {
+			return fmt.Errorf("interceptor %q needs %q earlier in the chain", name, required)
+		}
+	}
+
+	return nil
+}
+
+func knownInterceptor(name string) bool {
+	for _, known := range DefaultInterceptors {
+		if name == known {
+			return true
+		}
+	}
+
+	return false
+}
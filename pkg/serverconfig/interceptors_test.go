@@ -0,0 +1,40 @@
+package serverconfig
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateInterceptors(t *testing.T) {
+	tests := []struct {
+		name  string
+		chain []string
+		err   string
+	}{
+		{name: "default", chain: DefaultInterceptors},
+		{name: "empty", chain: []string{}},
+		{name: "reordered", chain: []string{"recovery", "tags", "authorization", "logging", "rate-limit", "prometheus"}},
+		{name: "without tenant interceptors", chain: []string{"prometheus", "tags", "peer-identity", "recovery"}},
+		{name: "unknown", chain: []string{"tags", "auditing"}, err: `unknown interceptor "auditing"`},
+		{name: "twice", chain: []string{"tags", "logging", "tags"}, err: `interceptor "tags" is in the chain twice`},
+		{name: "rate limit before authorization", chain: []string{"rate-limit", "authorization"}, err: `"rate-limit" needs "authorization" earlier`},
+		{name: "tenant metrics without authorization", chain: []string{"tenant-metrics"}, err: `"tenant-metrics" needs "authorization" earlier`},
+		{name: "peer identity without tags", chain: []string{"peer-identity", "authorization"}, err: `"peer-identity" needs "tags" earlier`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateInterceptors(test.chain)
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("expected %v to be valid, got %v", test.chain, err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
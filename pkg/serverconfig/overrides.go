@@ -0,0 +1,241 @@
+package serverconfig
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* environment variables are the flag names in upper case with this prefix, e.g. HELLOWORLD_GRPC_ADDR */
+const EnvPrefix = "HELLOWORLD_"
+
+/* a config setting that can be overridden by a flag and an environment variable */
+type option struct {
+	name  string
+	usage string
+	field func(c *Config) interface{}
+}
+
+var options = []option{
+	{"grpc-addr", "listen address for gRPC",
+		func(c *Config) interface{} { return &c.Listeners.GRPC.Address }},
+	{"admin-addr", "listen address for /healthz, /livez, /readyz and /startupz, empty to serve them on --grpc-addr next to gRPC",
+		func(c *Config) interface{} { return &c.Listeners.Admin.Address }},
+	{"admin-tls", "serve --admin-addr over TLS with the server certificate",
+		func(c *Config) interface{} { return &c.Listeners.Admin.TLS }},
+	{"metrics-addr", "listen address for /metrics, empty to serve it with the admin endpoints, which are on --grpc-addr unless --admin-addr is set",
+		func(c *Config) interface{} { return &c.Listeners.Metrics.Address }},
+	{"metrics-tls", "serve --metrics-addr over TLS with the server certificate",
+		func(c *Config) interface{} { return &c.Listeners.Metrics.TLS }},
+
+	{"tls", "enable TLS",
+		func(c *Config) interface{} { return &c.TLS.Enabled }},
+	{"crt", "TLS certificate",
+		func(c *Config) interface{} { return &c.TLS.Cert }},
+	{"key", "TLS private key",
+		func(c *Config) interface{} { return &c.TLS.Key }},
+	{"cert-dir", "directory of additional keypairs chosen by SNI: <name>.crt (or .pem) and <name>.key, or <name>/tls.crt and <name>/tls.key",
+		func(c *Config) interface{} { return &c.TLS.CertDir }},
+	{"default-cert", "name of the keypair in --cert-dir served when no certificate matches the SNI, defaults to --crt and --key",
+		func(c *Config) interface{} { return &c.TLS.DefaultCert }},
+	{"cert-reload-interval", "how often to check --crt, --key and --cert-dir for renewed certificates",
+		func(c *Config) interface{} { return &c.TLS.ReloadInterval }},
+	{"client-ca", "PEM bundle of CAs that sign client certificates, for mutual TLS",
+		func(c *Config) interface{} { return &c.TLS.ClientCA }},
+	{"client-auth", "client certificates: none, request (verify if sent) or require-and-verify, request and require-and-verify need --client-ca",
+		func(c *Config) interface{} { return &c.TLS.ClientAuth }},
+
+	{"config-dir", "config directory",
+		func(c *Config) interface{} { return &c.Tenancy.ConfigDir }},
+	{"tenant-config-source", "where to load the tenant config from: a directory or file:// path, an http(s):// URL or configmap://namespace/name?key=tenant-config.yaml, defaults to --config-dir",
+		func(c *Config) interface{} { return &c.Tenancy.Source }},
+	{"tenant-config-failure-policy", "what to do if the tenant config cannot be loaded: fail-open (accept all tenants), fail-closed (reject all tenants and report NOT_SERVING) or refuse-to-start",
+		func(c *Config) interface{} { return &c.Tenancy.FailurePolicy }},
+	{"config-reload-interval", "how often to poll the tenant config source for changes, an edit takes up to this long to apply",
+		func(c *Config) interface{} { return &c.Tenancy.ReloadInterval }},
+	{"tenant-identity", "how the tenant is authenticated: header (trust X-Tenant-Id), jwt (a claim in a bearer token) or mtls (the client certificate)",
+		func(c *Config) interface{} { return &c.Tenancy.Identity.Mode }},
+	{"jwks", "for --tenant-identity=jwt, the JSON Web Key Set to verify tokens with: a file path or http(s):// URL",
+		func(c *Config) interface{} { return &c.Tenancy.Identity.JWKS }},
+	{"jwt-tenant-claim", "for --tenant-identity=jwt, the claim holding the tenant id",
+		func(c *Config) interface{} { return &c.Tenancy.Identity.JWTClaim }},
+	{"jwt-issuer", "for --tenant-identity=jwt, the required iss claim, empty to accept any",
+		func(c *Config) interface{} { return &c.Tenancy.Identity.JWTIssuer }},
+	{"jwt-audience", "for --tenant-identity=jwt, the required aud claim, empty to accept any",
+		func(c *Config) interface{} { return &c.Tenancy.Identity.JWTAudience }},
+	{"tenant-cert-field", "for --tenant-identity=mtls, where the tenant is in the client certificate: uri (last path segment of the URI SAN, e.g. SPIFFE ID), dns (DNS SAN) or cn",
+		func(c *Config) interface{} { return &c.Tenancy.Identity.CertField }},
+
+	{"log-level", "debug, info, warn or error",
+		func(c *Config) interface{} { return &c.Logging.Level }},
+	{"log-format", "json or console",
+		func(c *Config) interface{} { return &c.Logging.Format }},
+
+	{"metrics-handling-time-histogram", "export grpc_server_handling_seconds histograms",
+		func(c *Config) interface{} { return &c.Metrics.HandlingTimeHistogram }},
+
+	{"health-check-interval", "how often the conditions behind the health service are checked",
+		func(c *Config) interface{} { return &c.Health.CheckInterval }},
+	{"metadata-health", "report helloworld.Greeter NOT_SERVING while the GCP metadata server is unreachable, disable when not running on GCP",
+		func(c *Config) interface{} { return &c.Health.Metadata }},
+	{"metadata-url", "the GCP metadata server",
+		func(c *Config) interface{} { return &c.Metadata.URL }},
+
+	{"shutdown-delay", "on SIGTERM, how long to report NOT_SERVING before sending GOAWAY, so the load balancer stops sending new calls",
+		func(c *Config) interface{} { return &c.Shutdown.Delay }},
+	{"drain-timeout", "after GOAWAY, how long in-flight calls and streams get to finish before they are closed with UNAVAILABLE",
+		func(c *Config) interface{} { return &c.Shutdown.DrainTimeout }},
+
+	{"keepalive-time", "ping clients after this long without activity",
+		func(c *Config) interface{} { return &c.Keepalive.Time }},
+	{"keepalive-timeout", "close the connection if a ping isn't answered within this long",
+		func(c *Config) interface{} { return &c.Keepalive.Timeout }},
+	{"keepalive-min-time", "close connections of clients that ping more often than this",
+		func(c *Config) interface{} { return &c.Keepalive.MinTime }},
+	{"keepalive-permit-without-stream", "allow client pings without open streams",
+		func(c *Config) interface{} { return &c.Keepalive.PermitWithoutStream }},
+	{"max-connection-idle", "close connections idle for this long with GOAWAY, 0 for no limit",
+		func(c *Config) interface{} { return &c.Keepalive.MaxConnectionIdle }},
+	{"max-connection-age", "close connections with GOAWAY after this long, e.g. to rebalance behind the GLB, 0 for no limit",
+		func(c *Config) interface{} { return &c.Keepalive.MaxConnectionAge }},
+	{"max-connection-age-grace", "after --max-connection-age, how long open calls get before the connection is closed, 0 for no limit",
+		func(c *Config) interface{} { return &c.Keepalive.MaxConnectionAgeGrace }},
+
+	{"interceptors", "comma separated interceptors every call goes through, outermost first: " + strings.Join(DefaultInterceptors, ","),
+		func(c *Config) interface{} { return &c.Interceptors.Chain }},
+}
+
+/* EnvName is the environment variable that overrides a flag */
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+/* the flags of every option, registered on a flag set */
+type Flags struct {
+	fs *flag.FlagSet
+	// parsed flag values, only the ones set on the command line are used
+	values *Config
+}
+
+/* RegisterFlags adds a flag for every setting, defaulting to Default() */
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{fs: fs, values: Default()}
+
+	for _, o := range options {
+		usage := o.usage + " (env " + EnvName(o.name) + ")"
+
+		switch field := o.field(f.values).(type) {
+		case *string:
+			fs.StringVar(field, o.name, *field, usage)
+		case *bool:
+			fs.BoolVar(field, o.name, *field, usage)
+		case *Duration:
+			fs.DurationVar(&field.Duration, o.name, field.Duration, usage)
+		case *[]string:
+			fs.Var(&listValue{field}, o.name, usage)
+		}
+	}
+
+	return f
+}
+
+/*
+Load builds the effective config once the flags are parsed.  Later sources win:
+
+ 1. the defaults
+ 2. the config file, if any
+ 3. HELLOWORLD_* environment variables
+ 4. flags set on the command line
+*/
+func (f *Flags) Load(configFile string) (*Config, error) {
+	c := Default()
+
+	if configFile != "" {
+		if err := c.LoadFile(configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, o := range options {
+		if value, ok := os.LookupEnv(EnvName(o.name)); ok {
+			if err := setField(o.field(c), value); err != nil {
+				return nil, fmt.Errorf("invalid %v: %v", EnvName(o.name), err)
+			}
+		}
+	}
+
+	set := make(map[string]bool)
+	f.fs.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+
+	for _, o := range options {
+		if set[o.name] {
+			if err := setField(o.field(c), f.fs.Lookup(o.name).Value.String()); err != nil {
+				return nil, fmt.Errorf("invalid --%v: %v", o.name, err)
+			}
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func setField(field interface{}, s string) error {
+	switch field := field.(type) {
+	case *string:
+		*field = s
+	case *bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		*field = b
+	case *Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.Duration = d
+	case *[]string:
+		*field = splitList(s)
+	default:
+		return fmt.Errorf("unsupported setting type %T", field)
+	}
+
+	return nil
+}
+
+/* a comma separated list flag */
+type listValue struct {
+	values *[]string
+}
+
+func (l *listValue) String() string {
+	if l.values == nil {
+		return ""
+	}
+
+	return strings.Join(*l.values, ",")
+}
+
+func (l *listValue) Set(s string) error {
+	*l.values = splitList(s)
+	return nil
+}
+
+/* an empty string is an empty list */
+func splitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,182 @@
+package serverconfig
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, dir string, yaml string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, "server.yaml")
+	if err := ioutil.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func setEnv(t *testing.T, env map[string]string) func() {
+	t.Helper()
+
+	for name, value := range env {
+		if err := os.Setenv(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return func() {
+		for name := range env {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+/* parse args and load the config over the file and environment */
+func loadConfig(t *testing.T, configFile string, env map[string]string, args ...string) (*Config, error) {
+	t.Helper()
+
+	defer setEnv(t, env)()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	flags := RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	return flags.Load(configFile)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serverconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFile := writeConfigFile(t, dir, `
+logging:
+  level: warn
+tls:
+  enabled: false
+shutdown:
+  drain_timeout: 10s
+interceptors:
+  chain: [tags, authorization, recovery]
+`)
+
+	type settings struct {
+		level        string
+		tls          bool
+		drainTimeout time.Duration
+		interceptors []string
+	}
+
+	defaults := Default()
+
+	tests := []struct {
+		name       string
+		configFile string
+		env        map[string]string
+		args       []string
+		want       settings
+	}{
+		{
+			name: "defaults",
+			want: settings{defaults.Logging.Level, defaults.TLS.Enabled, defaults.Shutdown.DrainTimeout.Duration, DefaultInterceptors},
+		},
+		{
+			name:       "file over defaults",
+			configFile: configFile,
+			want:       settings{"warn", false, 10 * time.Second, []string{"tags", "authorization", "recovery"}},
+		},
+		{
+			name:       "environment over file",
+			configFile: configFile,
+			env: map[string]string{
+				"HELLOWORLD_LOG_LEVEL":    "error",
+				"HELLOWORLD_TLS":          "true",
+				"HELLOWORLD_INTERCEPTORS": "authorization, rate-limit",
+			},
+			want: settings{"error", true, 10 * time.Second, []string{"authorization", "rate-limit"}},
+		},
+		{
+			name:       "flags over environment",
+			configFile: configFile,
+			env: map[string]string{
+				"HELLOWORLD_LOG_LEVEL":     "error",
+				"HELLOWORLD_DRAIN_TIMEOUT": "20s",
+			},
+			args: []string{"--log-level", "debug", "--drain-timeout", "30s", "--interceptors", "prometheus,recovery"},
+			want: settings{"debug", false, 30 * time.Second, []string{"prometheus", "recovery"}},
+		},
+		{
+			name:       "flags set to the default",
+			configFile: configFile,
+			env:        map[string]string{"HELLOWORLD_LOG_LEVEL": "error"},
+			args:       []string{"--log-level", "info", "--tls=true"},
+			want:       settings{"info", true, 10 * time.Second, []string{"tags", "authorization", "recovery"}},
+		},
+		{
+			name: "empty interceptor chain",
+			env:  map[string]string{"HELLOWORLD_INTERCEPTORS": ""},
+			want: settings{defaults.Logging.Level, defaults.TLS.Enabled, defaults.Shutdown.DrainTimeout.Duration, []string{}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := loadConfig(t, test.configFile, test.env, test.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := settings{c.Logging.Level, c.TLS.Enabled, c.Shutdown.DrainTimeout.Duration, c.Interceptors.Chain}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %+v, got %+v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serverconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		err  string
+	}{
+		{name: "unknown key", file: "logging:\n  colour: true\n", err: "field colour not found"},
+		{name: "invalid environment variable", env: map[string]string{"HELLOWORLD_DRAIN_TIMEOUT": "soon"}, err: "invalid HELLOWORLD_DRAIN_TIMEOUT"},
+		{name: "invalid after the overrides", file: "logging:\n  level: debug\n", args: []string{"--log-level", "loud"}, err: `unknown log level "loud"`},
+		{name: "unknown interceptor", args: []string{"--interceptors", "authorization,auditing"}, err: `unknown interceptor "auditing"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configFile := ""
+			if test.file != "" {
+				configFile = writeConfigFile(t, dir, test.file)
+			}
+
+			_, err := loadConfig(t, configFile, test.env, test.args...)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
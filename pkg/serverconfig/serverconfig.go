@@ -0,0 +1,299 @@
+// Package serverconfig loads the server's configuration from a YAML file, environment variables and flags.
+package serverconfig
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+
+	http_health "helloworld/pkg/healthcheck"
+	shutdown "helloworld/pkg/shutdown"
+	tenant "helloworld/pkg/tenant"
+	tlsconfig "helloworld/pkg/tlsconfig"
+
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DefaultGrpcAddress = ":50051"
+	// only reachable from the pod, so /metrics isn't exposed on the gRPC port behind the load balancer
+	DefaultMetricsAddress = "127.0.0.1:9090"
+	DefaultMetadataURL    = "http://metadata/computeMetadata/v1/"
+
+	LogFormatJSON    = "json"
+	LogFormatConsole = "console"
+
+	redacted = "REDACTED"
+)
+
+type Config struct {
+	Listeners    ListenersConfig    `yaml:"listeners"`
+	TLS          TLSConfig          `yaml:"tls"`
+	Tenancy      TenancyConfig      `yaml:"tenancy"`
+	Logging      LoggingConfig      `yaml:"logging"`
+	Metrics      MetricsConfig      `yaml:"metrics"`
+	Health       HealthConfig       `yaml:"health"`
+	Metadata     MetadataConfig     `yaml:"metadata"`
+	Shutdown     ShutdownConfig     `yaml:"shutdown"`
+	Keepalive    KeepaliveConfig    `yaml:"keepalive"`
+	Interceptors InterceptorsConfig `yaml:"interceptors"`
+}
+
+type ListenersConfig struct {
+	GRPC ListenerConfig `yaml:"grpc"`
+	// empty to serve the admin endpoints on the gRPC port, e.g. for the NEG health check
+	Admin ListenerConfig `yaml:"admin"`
+	// empty to serve /metrics with the admin endpoints
+	Metrics ListenerConfig `yaml:"metrics"`
+}
+
+type ListenerConfig struct {
+	Address string `yaml:"address"`
+	// only for admin and metrics, gRPC uses tls.enabled
+	TLS bool `yaml:"tls"`
+}
+
+type TLSConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	Cert           string   `yaml:"cert"`
+	Key            string   `yaml:"key"`
+	CertDir        string   `yaml:"cert_dir"`
+	DefaultCert    string   `yaml:"default_cert"`
+	ReloadInterval Duration `yaml:"reload_interval"`
+	ClientCA       string   `yaml:"client_ca"`
+	ClientAuth     string   `yaml:"client_auth"`
+}
+
+type TenancyConfig struct {
+	ConfigDir      string         `yaml:"config_dir"`
+	Source         string         `yaml:"source"`
+	FailurePolicy  string         `yaml:"failure_policy"`
+	ReloadInterval Duration       `yaml:"reload_interval"`
+	Identity       IdentityConfig `yaml:"identity"`
+}
+
+type IdentityConfig struct {
+	Mode        string `yaml:"mode"`
+	JWKS        string `yaml:"jwks"`
+	JWTClaim    string `yaml:"jwt_claim"`
+	JWTIssuer   string `yaml:"jwt_issuer"`
+	JWTAudience string `yaml:"jwt_audience"`
+	CertField   string `yaml:"cert_field"`
+}
+
+type LoggingConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+type MetricsConfig struct {
+	HandlingTimeHistogram bool `yaml:"handling_time_histogram"`
+}
+
+type HealthConfig struct {
+	CheckInterval Duration `yaml:"check_interval"`
+	Metadata      bool     `yaml:"metadata"`
+}
+
+type MetadataConfig struct {
+	URL string `yaml:"url"`
+}
+
+type ShutdownConfig struct {
+	Delay        Duration `yaml:"delay"`
+	DrainTimeout Duration `yaml:"drain_timeout"`
+}
+
+/* grpc keepalive.ServerParameters and EnforcementPolicy, 0 for the max connection settings is no limit */
+type KeepaliveConfig struct {
+	Time                  Duration `yaml:"time"`
+	Timeout               Duration `yaml:"timeout"`
+	MinTime               Duration `yaml:"min_time"`
+	PermitWithoutStream   bool     `yaml:"permit_without_stream"`
+	MaxConnectionIdle     Duration `yaml:"max_connection_idle"`
+	MaxConnectionAge      Duration `yaml:"max_connection_age"`
+	MaxConnectionAgeGrace Duration `yaml:"max_connection_age_grace"`
+}
+
+type InterceptorsConfig struct {
+	// the interceptors every call goes through, outermost first, see DefaultInterceptors
+	Chain []string `yaml:"chain"`
+}
+
+/* a time.Duration written as a string like 30s in YAML */
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %v", value.Line, err)
+	}
+
+	d.Duration = parsed
+	return nil
+}
+
+/* Default is the config without a file, environment variables or flags */
+func Default() *Config {
+	return &Config{
+		Listeners: ListenersConfig{
+			GRPC:    ListenerConfig{Address: DefaultGrpcAddress},
+			Metrics: ListenerConfig{Address: DefaultMetricsAddress},
+		},
+		TLS: TLSConfig{
+			Enabled:        true,
+			Cert:           "certs/tls.crt",
+			Key:            "certs/tls.key",
+			ReloadInterval: Duration{tlsconfig.DefaultCertReloadInterval},
+			ClientAuth:     tlsconfig.ClientAuthNone,
+		},
+		Tenancy: TenancyConfig{
+			ConfigDir:      "config/",
+			FailurePolicy:  tenant.FailurePolicyFailOpen,
+			ReloadInterval: Duration{tenant.DefaultReloadInterval},
+			Identity: IdentityConfig{
+				Mode:      tenant.IdentityModeHeader,
+				JWTClaim:  tenant.DefaultTenantClaim,
+				CertField: tenant.CertFieldURI,
+			},
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: LogFormatJSON,
+		},
+		Health: HealthConfig{
+			CheckInterval: Duration{http_health.DefaultCheckInterval},
+			Metadata:      true,
+		},
+		Metadata: MetadataConfig{
+			URL: DefaultMetadataURL,
+		},
+		Shutdown: ShutdownConfig{
+			Delay:        Duration{shutdown.DefaultShutdownDelay},
+			DrainTimeout: Duration{shutdown.DefaultDrainTimeout},
+		},
+		// the grpc defaults
+		Keepalive: KeepaliveConfig{
+			Time:    Duration{2 * time.Hour},
+			Timeout: Duration{20 * time.Second},
+			MinTime: Duration{5 * time.Minute},
+		},
+		Interceptors: InterceptorsConfig{
+			Chain: append([]string(nil), DefaultInterceptors...),
+		},
+	}
+}
+
+/* LoadFile reads a config file over the config, unknown keys are an error */
+func (c *Config) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read server config: %v", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("unable to parse server config %v: %v", path, err)
+	}
+
+	return nil
+}
+
+func (c *Config) Validate() error {
+	if _, err := c.LogLevel(); err != nil {
+		return err
+	}
+
+	switch c.Logging.Format {
+	case LogFormatJSON, LogFormatConsole:
+	default:
+		return fmt.Errorf("unknown log format %q, must be %v or %v", c.Logging.Format, LogFormatJSON, LogFormatConsole)
+	}
+
+	if err := tenant.ValidateFailurePolicy(c.Tenancy.FailurePolicy); err != nil {
+		return err
+	}
+
+	if _, err := tlsconfig.ParseClientAuth(c.TLS.ClientAuth); err != nil {
+		return err
+	}
+
+	if err := ValidateInterceptors(c.Interceptors.Chain); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Config) LogLevel() (zapcore.Level, error) {
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+		return level, fmt.Errorf("unknown log level %q", c.Logging.Level)
+	}
+
+	return level, nil
+}
+
+/* Redacted is a copy of the config safe to print, without the private key path or credentials in the source URLs */
+func (c *Config) Redacted() *Config {
+	r := *c
+	if c.TLS.Key != "" {
+		r.TLS.Key = redacted
+	}
+	r.Tenancy.Source = redactURL(c.Tenancy.Source)
+	r.Tenancy.Identity.JWKS = redactURL(c.Tenancy.Identity.JWKS)
+	r.Metadata.URL = redactURL(c.Metadata.URL)
+
+	return &r
+}
+
+/* drop the password of user:password@, a token@ user without one and query parameters that look like credentials, e.g. a signed URL */
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" {
+		return s
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	} else if u.User != nil && u.User.Username() != "" {
+		u.User = url.User(redacted)
+	}
+
+	query := u.Query()
+	for name := range query {
+		if secretQueryParam(name) {
+			query.Set(name, redacted)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+func secretQueryParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, secret := range []string{"token", "secret", "password", "signature", "sig", "credential", "auth", "apikey", "api_key"} {
+		if strings.Contains(name, secret) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c)
+}
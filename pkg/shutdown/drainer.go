@@ -0,0 +1,217 @@
+// Package shutdown drains the server on SIGTERM: health checks fail first so the load balancer stops sending new
+// connections, then in-flight calls get until the drain deadline to finish.
+package shutdown
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// long enough for the GLB health check (every 5s, unhealthy after 2 failures) to take the endpoint out
+	DefaultShutdownDelay = 10 * time.Second
+	// with the shutdown delay, within the default 30s terminationGracePeriodSeconds
+	DefaultDrainTimeout = 15 * time.Second
+)
+
+/*
+tracks whether the server is shutting down and the streams that are still open, so they can be closed with a
+clear status when the drain deadline passes instead of the connection just going away
+*/
+type Drainer struct {
+	draining int32
+	streams  int64
+
+	closeOnce sync.Once
+	closing   chan struct{}
+
+	onDrain []func()
+}
+
+func NewDrainer() *Drainer {
+	return &Drainer{
+		closing: make(chan struct{}),
+	}
+}
+
+/* Draining is true once shutdown started, health checks report NOT_SERVING from then on */
+func (d *Drainer) Draining() bool {
+	return atomic.LoadInt32(&d.draining) == 1
+}
+
+/* OnDrain registers a func to call when draining starts, e.g. to flip the health service */
+func (d *Drainer) OnDrain(f func()) {
+	d.onDrain = append(d.onDrain, f)
+}
+
+func (d *Drainer) StartDraining() {
+	if atomic.SwapInt32(&d.draining, 1) == 1 {
+		return
+	}
+
+	for _, f := range d.onDrain {
+		f()
+	}
+}
+
+/* OpenStreams is the number of streams that haven't finished yet */
+func (d *Drainer) OpenStreams() int64 {
+	return atomic.LoadInt64(&d.streams)
+}
+
+/* CloseStreams ends every open stream with Unavailable, for when the drain deadline passed */
+func (d *Drainer) CloseStreams() {
+	d.closeOnce.Do(func() { close(d.closing) })
+}
+
+/* the status of streams closed at the drain deadline */
+func shuttingDownError() error {
+	return status.Error(codes.Unavailable, "Server is shutting down, reconnect to continue the stream")
+}
+
+func (d *Drainer) closed() bool {
+	select {
+	case <-d.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+the handler gets a stream whose context is cancelled and whose Recv returns Unavailable when the streams are
+closed.  The handler runs on the calling goroutine, so the stream is only finished once it has returned.
+*/
+func (d *Drainer) StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	atomic.AddInt64(&d.streams, 1)
+	defer atomic.AddInt64(&d.streams, -1)
+
+	ctx, cancel := context.WithCancel(ss.Context())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-d.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := handler(srv, &drainingServerStream{ss, ctx, d})
+	if err != nil && d.closed() {
+		return shuttingDownError()
+	}
+
+	return err
+}
+
+type drainingServerStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	drainer *Drainer
+}
+
+func (s *drainingServerStream) Context() context.Context {
+	return s.ctx
+}
+
+/*
+a client that sends nothing would keep the handler blocked in Recv past the deadline, so once draining started the
+receive is raced against the streams being closed.  Until then Recv goes straight to the stream, one that is still
+blocked at the deadline ends when Shutdown stops the server.
+*/
+func (s *drainingServerStream) RecvMsg(m interface{}) error {
+	if s.drainer.closed() {
+		return s.closedError()
+	}
+
+	if !s.drainer.Draining() {
+		return s.ServerStream.RecvMsg(m)
+	}
+
+	// the abandoned receive decodes into its own message, so it can't write into m after we returned
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return s.ServerStream.RecvMsg(m)
+	}
+	received := msg.ProtoReflect().New().Interface()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ServerStream.RecvMsg(received)
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			proto.Reset(msg)
+			proto.Merge(msg, received)
+		}
+		return err
+	case <-s.drainer.closing:
+		return s.closedError()
+	}
+}
+
+/* the context is cancelled by then, so a handler that checks it after the error sees it done */
+func (s *drainingServerStream) closedError() error {
+	<-s.ctx.Done()
+	return shuttingDownError()
+}
+
+/*
+Shutdown drains the gRPC server: health is flipped to NOT_SERVING, after delay GOAWAY is sent and in-flight calls
+get until drainTimeout to finish.  Streams still open then are closed with Unavailable and anything left after that
+is cut off.  stopHTTP is called with the drain deadline to shut down the other servers.
+*/
+func (d *Drainer) Shutdown(s *grpc.Server, delay time.Duration, drainTimeout time.Duration, stopHTTP func(context.Context), logger *zap.Logger) {
+	d.StartDraining()
+	logger.Info("Shutting down, reporting NOT_SERVING", zap.Duration("delay", delay))
+	time.Sleep(delay)
+
+	logger.Info("Draining connections",
+		zap.Int64("openStreams", d.OpenStreams()),
+		zap.Duration("drainTimeout", drainTimeout),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		// sends GOAWAY and waits for in-flight calls
+		s.GracefulStop()
+		close(stopped)
+	}()
+
+	if stopHTTP != nil {
+		stopHTTP(ctx)
+	}
+
+	select {
+	case <-stopped:
+		logger.Info("Drained all connections")
+		return
+	case <-ctx.Done():
+	}
+
+	logger.Warn("Drain timeout reached, closing open streams", zap.Int64("openStreams", d.OpenStreams()))
+	d.CloseStreams()
+
+	select {
+	case <-stopped:
+		logger.Info("Closed open streams, server stopped")
+	case <-time.After(time.Second):
+		// unary calls that are still running
+		logger.Warn("Stopping the server with calls still in flight")
+		s.Stop()
+	}
+}
@@ -0,0 +1,219 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+/* a server stream whose Recv blocks until the stream ends, like a client that sends nothing */
+type idleServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *idleServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *idleServerStream) RecvMsg(m interface{}) error {
+	<-s.ctx.Done()
+	return s.ctx.Err()
+}
+
+/* a server stream that receives the values sent on msgs into the message it was given */
+type chanServerStream struct {
+	grpc.ServerStream
+	msgs chan string
+	// the message each receive decoded into
+	into chan interface{}
+}
+
+func newChanServerStream() *chanServerStream {
+	return &chanServerStream{msgs: make(chan string), into: make(chan interface{}, 10)}
+}
+
+func (s *chanServerStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *chanServerStream) RecvMsg(m interface{}) error {
+	s.into <- m
+	m.(*wrapperspb.StringValue).Value = <-s.msgs
+	return nil
+}
+
+func TestDrainerStartDraining(t *testing.T) {
+	d := NewDrainer()
+
+	calls := 0
+	d.OnDrain(func() { calls++ })
+
+	if d.Draining() {
+		t.Error("expected a new drainer not to be draining")
+	}
+
+	d.StartDraining()
+	d.StartDraining()
+
+	if !d.Draining() {
+		t.Error("expected the drainer to be draining")
+	}
+	if calls != 1 {
+		t.Errorf("expected the drain funcs to be called once, got %d", calls)
+	}
+}
+
+func TestDrainerStreamFinishes(t *testing.T) {
+	d := NewDrainer()
+	ss := &idleServerStream{ctx: context.Background()}
+	handlerErr := errors.New("handler error")
+
+	err := d.StreamServerInterceptor(nil, ss, &grpc.StreamServerInfo{}, func(srv interface{}, stream grpc.ServerStream) error {
+		if n := d.OpenStreams(); n != 1 {
+			t.Errorf("expected 1 open stream, got %d", n)
+		}
+		return handlerErr
+	})
+
+	if err != handlerErr {
+		t.Errorf("expected the handler's error, got %v", err)
+	}
+	if n := d.OpenStreams(); n != 0 {
+		t.Errorf("expected no open streams, got %d", n)
+	}
+}
+
+func TestDrainerCloseStreams(t *testing.T) {
+	d := NewDrainer()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ss := &idleServerStream{ctx: ctx}
+
+	var handlerDone int32
+	var handlerCtxErr error
+	result := make(chan error, 1)
+
+	d.StartDraining()
+	go func() {
+		result <- d.StreamServerInterceptor(nil, ss, &grpc.StreamServerInfo{}, func(srv interface{}, stream grpc.ServerStream) error {
+			defer atomic.StoreInt32(&handlerDone, 1)
+
+			err := stream.RecvMsg(&wrapperspb.StringValue{})
+			handlerCtxErr = stream.Context().Err()
+			return err
+		})
+	}()
+
+	for d.OpenStreams() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	d.CloseStreams()
+
+	select {
+	case err := <-result:
+		if status.Code(err) != codes.Unavailable {
+			t.Errorf("expected Unavailable, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the blocked stream to end when the streams are closed")
+	}
+
+	if atomic.LoadInt32(&handlerDone) != 1 {
+		t.Error("expected the interceptor to return only after the handler did")
+	}
+	if handlerCtxErr != context.Canceled {
+		t.Errorf("expected the handler's context to be cancelled, got %v", handlerCtxErr)
+	}
+
+	// streams opened after the close end straight away
+	err := d.StreamServerInterceptor(nil, ss, &grpc.StreamServerInfo{}, func(srv interface{}, stream grpc.ServerStream) error {
+		return stream.RecvMsg(&wrapperspb.StringValue{})
+	})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable, got %v", err)
+	}
+}
+
+func TestDrainerCloseStreamsHandlerSucceeds(t *testing.T) {
+	d := NewDrainer()
+	d.CloseStreams()
+
+	// a handler that finishes cleanly keeps its status
+	err := d.StreamServerInterceptor(nil, &idleServerStream{ctx: context.Background()}, &grpc.StreamServerInfo{},
+		func(srv interface{}, stream grpc.ServerStream) error { return nil })
+	if err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestDrainerRecv(t *testing.T) {
+	for _, draining := range []bool{false, true} {
+		d := NewDrainer()
+		if draining {
+			d.StartDraining()
+		}
+
+		ss := newChanServerStream()
+		m := &wrapperspb.StringValue{Value: "stale"}
+		result := make(chan error, 1)
+
+		go func() {
+			result <- d.StreamServerInterceptor(nil, ss, &grpc.StreamServerInfo{}, func(srv interface{}, stream grpc.ServerStream) error {
+				return stream.RecvMsg(m)
+			})
+		}()
+
+		into := <-ss.into
+		ss.msgs <- "hello"
+
+		if err := <-result; err != nil {
+			t.Fatalf("draining %v: expected the message, got %v", draining, err)
+		}
+		if m.Value != "hello" {
+			t.Errorf("draining %v: expected the message received into m, got %q", draining, m.Value)
+		}
+
+		// only a receive that can be abandoned needs a message of its own
+		if direct := into == interface{}(m); direct == draining {
+			t.Errorf("draining %v: expected the stream to receive into m %v, got %v", draining, !draining, direct)
+		}
+	}
+}
+
+func TestDrainerAbandonedRecv(t *testing.T) {
+	d := NewDrainer()
+	d.StartDraining()
+
+	ss := newChanServerStream()
+	m := &wrapperspb.StringValue{}
+	result := make(chan error, 1)
+
+	go func() {
+		result <- d.StreamServerInterceptor(nil, ss, &grpc.StreamServerInfo{}, func(srv interface{}, stream grpc.ServerStream) error {
+			return stream.RecvMsg(m)
+		})
+	}()
+
+	<-ss.into
+	d.CloseStreams()
+
+	if err := <-result; status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable, got %v", err)
+	}
+
+	// the receive still running finishes without touching m
+	ss.msgs <- "late"
+	time.Sleep(10 * time.Millisecond)
+
+	if m.Value != "" {
+		t.Errorf("expected the abandoned receive not to write into m, got %q", m.Value)
+	}
+}
@@ -2,9 +2,11 @@ package tenant
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
-	"strings"
+	"regexp"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
@@ -12,112 +14,308 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-/* TODO: how to define a RANGE of tenants?  e.g. 0000-4999 is valid for this deployment */
-type TenantConfig struct {
+/* how allow and deny rules are combined when a tenant matches both */
+const (
+	// a matching deny rule always wins over a matching allow rule (default)
+	PrecedenceDenyOverrides = "deny-overrides"
+	// a matching allow rule always wins over a matching deny rule
+	PrecedenceAllowOverrides = "allow-overrides"
+	// rules are evaluated in order and the first matching rule wins
+	PrecedenceFirstMatch = "first-match"
+)
+
+const tenantConfigFile = "tenant-config.yaml"
+
+/* what to do when the tenant config cannot be loaded */
+const (
+	// accept all tenants until a valid config is loaded (default)
+	FailurePolicyFailOpen = "fail-open"
+	// reject all tenants and report NOT_SERVING until a valid config is loaded
+	FailurePolicyFailClosed = "fail-closed"
+	// exit at startup
+	FailurePolicyRefuseToStart = "refuse-to-start"
+)
+
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+/* allow and deny rules and how they are combined */
+type TenantPolicy struct {
+	Precedence 		string 			`yaml:"precedence,omitempty" json:"precedence,omitempty"`
+	Rules 			[]TenantRule 	`yaml:"rules,omitempty" json:"rules,omitempty"`
 	AllowedTenants 	[]TenantMatch `yaml:"allowed_tenants" json:"allowed_tenants"`
 	DeniedTenants 	[]TenantMatch `yaml:"denied_tenants" json:"denied_tenants"`
+
+	// the name of the policy in decisions and errors, empty for the global policy
+	name string
+}
+
+type TenantConfig struct {
+	// the global policy, used for every method without an override
+	TenantPolicy `yaml:",inline"`
+
+	// policies for a single method (/helloworld.Greeter/StreamingHello) or every method of a service
+	// (/helloworld.Greeter/) that replace the global policy
+	Methods map[string]*TenantPolicy `yaml:"methods,omitempty" json:"methods,omitempty"`
+
+	// per-tenant rate limits, the first matching entry applies
+	Limits []TenantLimit `yaml:"tenant_limits,omitempty" json:"tenant_limits,omitempty"`
+
+	// the name of this shard and the tenants the other shards own, used to tell rejected clients where to go
+	Shard  string     `yaml:"shard,omitempty" json:"shard,omitempty"`
+	Shards []ShardRef `yaml:"shards,omitempty" json:"shards,omitempty"`
+
+	hash     string
+	fallback string
+}
+
+/* an entry in the ordered rule list, a matcher with an explicit allow or deny action */
+type TenantRule struct {
+	Action 		string `yaml:"action" json:"action"`
+	TenantMatch `yaml:",inline"`
 }
 
 type TenantMatch struct {
 	RangeMatch 	*[]TenantRangeMatch `yaml:"range,omitempty" json:"range,omitempty"`
 	PrefixMatch *[]string 			`yaml:"prefix,omitempty" json:"prefix,omitempty"`
 	ExactMatch 	*[]string 			`yaml:"exactMatch,omitempty" json:"exactMatch,omitempty"`
+	SuffixMatch *[]string 			`yaml:"suffix,omitempty" json:"suffix,omitempty"`
+	RegexMatch 	*[]string 			`yaml:"regex,omitempty" json:"regex,omitempty"`
+	GlobMatch 	*[]string 			`yaml:"glob,omitempty" json:"glob,omitempty"`
+
+	// boolean combinations of other matchers
+	AllOf 		*[]TenantMatch 		`yaml:"all_of,omitempty" json:"all_of,omitempty"`
+	AnyOf 		*[]TenantMatch 		`yaml:"any_of,omitempty" json:"any_of,omitempty"`
+	Not 		*TenantMatch 		`yaml:"not,omitempty" json:"not,omitempty"`
+
+	// compiled RegexMatch, set when the config is loaded
+	regexes []*regexp.Regexp
+	// the matchers of a deprecated entry that set several, all but the first are dropped when the config is loaded
+	legacyMatchers []string
 }
 
 type TenantRangeMatch struct {
+	Type  string `yaml:"type,omitempty" json:"type,omitempty"`
 	Start string `yaml:"start" json:"start"`
 	End   string `yaml:"end" json:"end"`
+
+	// normalized start and end, set when the config is loaded
+	start *rangeBound
+	end   *rangeBound
 }
 
 func makeDefaultTenantConfig() (*TenantConfig) {
 	defaultTenantConfig := TenantConfig{}
+	defaultTenantConfig.Precedence = PrecedenceDenyOverrides
 	defaultTenantConfig.AllowedTenants = make([]TenantMatch, 1)
 	defaultTenantConfig.AllowedTenants[0].ExactMatch = &[]string{"*"}
 	defaultTenantConfig.DeniedTenants = []TenantMatch{}
+	defaultTenantConfig.fallback = FailurePolicyFailOpen
 
 	return &defaultTenantConfig
 }
 
+func makeDenyAllTenantConfig() (*TenantConfig) {
+	denyAllTenantConfig := TenantConfig{}
+	denyAllTenantConfig.Precedence = PrecedenceDenyOverrides
+	denyAllTenantConfig.AllowedTenants = []TenantMatch{}
+	denyAllTenantConfig.DeniedTenants = []TenantMatch{}
+	denyAllTenantConfig.fallback = FailurePolicyFailClosed
+
+	return &denyAllTenantConfig
+}
+
+func ValidateFailurePolicy(failurePolicy string) error {
+	switch failurePolicy {
+	case FailurePolicyFailOpen, FailurePolicyFailClosed, FailurePolicyRefuseToStart:
+		return nil
+	}
+
+	return fmt.Errorf("unknown tenant config failure policy %q, must be one of %v, %v, %v",
+		failurePolicy, FailurePolicyFailOpen, FailurePolicyFailClosed, FailurePolicyRefuseToStart)
+}
+
+/* load the tenant config, accepting all tenants if it can't be loaded */
 func LoadTenantConfig(configDir string) (*TenantConfig, error) {
-	t := &TenantConfig{}
+	return LoadTenantConfigWithPolicy(configDir, FailurePolicyFailOpen)
+}
+
+/*
+  load the tenant config.  If it can't be loaded an error is returned along with a fallback config chosen by the
+  failure policy: fail-open accepts all tenants, fail-closed rejects all tenants and refuse-to-start returns nil
+*/
+func LoadTenantConfigWithPolicy(configDir string, failurePolicy string) (*TenantConfig, error) {
+	return LoadTenantConfigFromSource(context.Background(), NewFileTenantConfigSource(configDir), failurePolicy)
+}
 
-	yamlFile, err := ioutil.ReadFile(fmt.Sprintf("%v/tenant-config.yaml", configDir))
+func LoadTenantConfigFromSource(ctx context.Context, source TenantConfigSource, failurePolicy string) (*TenantConfig, error) {
+	var fallback *TenantConfig
+	var fallbackMsg string
+
+	switch failurePolicy {
+	case FailurePolicyFailClosed:
+		fallback, fallbackMsg = makeDenyAllTenantConfig(), "reject all tenants"
+	case FailurePolicyRefuseToStart:
+		fallback, fallbackMsg = nil, "refusing to start"
+	default:
+		fallback, fallbackMsg = makeDefaultTenantConfig(), "accept all tenants"
+	}
+
+	yamlFile, err := source.Fetch(ctx)
 	if err != nil {
 		//log.Printf("Unable to load tenantConfig: %v, accept all tenants", err.Error())
-		return makeDefaultTenantConfig(), fmt.Errorf("unable to load tenantConfig: %v, accept all tenants", err.Error())
+		return fallback, fmt.Errorf("unable to load tenantConfig: %v, %v", err.Error(), fallbackMsg)
 	}
 
-	err = yaml.Unmarshal(yamlFile, t)
+	t, err := ParseTenantConfig(yamlFile)
 	if err != nil {
-		return makeDefaultTenantConfig(), fmt.Errorf("unable to parse tenantConfig: %v, accept all tenants", err.Error())
+		return fallback, fmt.Errorf("%v, %v", err.Error(), fallbackMsg)
 	}
 
 	return t, nil
 }
 
-func tenantMatches(tenantIdToCheck string, tm TenantMatch) bool {
-	if tm.ExactMatch != nil {
-		for _, t := range *tm.ExactMatch {
-			if tenantIdToCheck == t {
-				return true
-			}
-			
-			// special case: "*"
-			if t == "*" {
-				return true
-			}
-		}
+/* the failure policy that produced this config if it is a fallback used because the real config couldn't be loaded */
+func (t *TenantConfig) Fallback() string {
+	return t.fallback
+}
 
-		return false
+/*
+  load a tenant config from a single file, which is either a tenant-config.yaml or a kubernetes ConfigMap manifest
+  with a tenant-config.yaml key like the ones in manifests/standalone_negs_*
+*/
+func LoadTenantConfigFile(path string) (*TenantConfig, error) {
+	yamlFile, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load tenantConfig: %v", err.Error())
 	}
 
+	configMap := struct {
+		Kind string            `yaml:"kind"`
+		Data map[string]string `yaml:"data"`
+	}{}
 
-	if tm.PrefixMatch != nil {
-		for _, t := range *tm.PrefixMatch {
-			if strings.HasPrefix(tenantIdToCheck, t) {
-				return true
-			}
+	if err := yaml.Unmarshal(yamlFile, &configMap); err == nil && configMap.Kind == "ConfigMap" {
+		data, ok := configMap.Data[tenantConfigFile]
+		if !ok {
+			return nil, fmt.Errorf("ConfigMap %v has no %v key", path, tenantConfigFile)
 		}
 
-		return false
+		yamlFile = []byte(data)
 	}
 
-	if tm.RangeMatch != nil {
-		for _, t := range *tm.RangeMatch {
-			if tenantIdToCheck < t.Start {
-				continue
-			}
-			if tenantIdToCheck > t.End {
-				continue	
-			}
+	return ParseTenantConfig(yamlFile)
+}
+
+func TenantConfigPath(configDir string) string {
+	return fmt.Sprintf("%v/%v", configDir, tenantConfigFile)
+}
+
+/* parse and validate a tenant config, the returned config remembers the hash of the raw yaml */
+func ParseTenantConfig(yamlFile []byte) (*TenantConfig, error) {
+	t := &TenantConfig{}
+
+	err := yaml.Unmarshal(yamlFile, t)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse tenantConfig: %v", err.Error())
+	}
+
+	err = t.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenantConfig: %v", err.Error())
+	}
+
+	t.hash = hashTenantConfig(yamlFile)
+
+	return t, nil
+}
+
+func hashTenantConfig(yamlFile []byte) string {
+	sum := sha256.Sum256(yamlFile)
+	return hex.EncodeToString(sum[:])
+}
+
+/* the sha256 of the yaml the config was parsed from, empty for the built-in default config */
+func (t *TenantConfig) Hash() string {
+	return t.hash
+}
 
-			// string is within range here
-			return true
+func (t *TenantConfig) validate() error {
+	if err := t.TenantPolicy.validate(PrecedenceDenyOverrides); err != nil {
+		return err
+	}
+
+	for _, method := range t.methodNames() {
+		if err := validateMethodName(method); err != nil {
+			return fmt.Errorf("methods[%v]: %v", method, err)
+		}
+
+		policy := t.Methods[method]
+		if policy == nil {
+			return fmt.Errorf("methods[%v]: empty policy", method)
+		}
+
+		// overrides inherit the global precedence
+		if err := policy.validate(t.Precedence); err != nil {
+			return fmt.Errorf("methods[%v]: %v", method, err)
 		}
+		policy.name = fmt.Sprintf("methods[%v]", method)
+	}
 
+	for i := range t.Limits {
+		if err := t.Limits[i].compile(); err != nil {
+			return fmt.Errorf("tenant_limits[%d]: %v", i, err)
+		}
 	}
 
-	// how did we get here?  nothing is defined
-	return false
+	for i := range t.Shards {
+		if err := t.Shards[i].compile(); err != nil {
+			return fmt.Errorf("shards[%d]: %v", i, err)
+		}
+	}
+
+	return nil
 }
 
-func (t *TenantConfig) CheckTenantId(tenantIdToCheck string) bool {
-	// check if tenant is explicitly denied 
-	for _, tenantMatch := range t.DeniedTenants {
-		if tenantMatches(tenantIdToCheck, tenantMatch) {
-			// if the tenant matches a deny rule, see if it's allowed later
-			break
+func (p *TenantPolicy) validate(defaultPrecedence string) error {
+	switch p.Precedence {
+	case "":
+		p.Precedence = defaultPrecedence
+	case PrecedenceDenyOverrides, PrecedenceAllowOverrides, PrecedenceFirstMatch:
+	default:
+		return fmt.Errorf("unknown precedence %q, must be one of %v, %v, %v", 
+			p.Precedence, PrecedenceDenyOverrides, PrecedenceAllowOverrides, PrecedenceFirstMatch)
+	}
+
+	for i, rule := range p.Rules {
+		if rule.Action != ActionAllow && rule.Action != ActionDeny {
+			return fmt.Errorf("rules[%d]: unknown action %q, must be %v or %v", i, rule.Action, ActionAllow, ActionDeny)
+		}
+
+		if err := p.Rules[i].compile(); err != nil {
+			return fmt.Errorf("rules[%d]: %v", i, err)
 		}
 	}
 
-	for _, tenantMatch := range t.AllowedTenants {
-		if tenantMatches(tenantIdToCheck, tenantMatch) {
-			return true
+	for i := range p.AllowedTenants {
+		if err := p.AllowedTenants[i].compile(); err != nil {
+			return fmt.Errorf("allowed_tenants[%d]: %v", i, err)
 		}
+	}
 
+	for i := range p.DeniedTenants {
+		if err := p.DeniedTenants[i].compile(); err != nil {
+			return fmt.Errorf("denied_tenants[%d]: %v", i, err)
+		}
 	}
 
-	return false
+	return nil
+}
+
+func (p *TenantPolicy) CheckTenantId(tenantIdToCheck string) bool {
+	return p.Evaluate(tenantIdToCheck).Allowed
 }
 
 func GetTenantId(ctx context.Context) (string, error) {
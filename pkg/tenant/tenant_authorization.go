@@ -0,0 +1,117 @@
+package tenant
+
+import (
+	"context"
+	"strings"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+/* methods that are served without a tenant, e.g. load balancer health checks */
+var DefaultExemptMethods = []string{
+	"/grpc.health.v1.Health/",
+	"/grpc.reflection.v1alpha.ServerReflection/",
+}
+
+/*
+authenticates the tenant of each request with the identity provider, checks it against the live tenant config and
+stores the TenantIdentity in the context, so services don't have to do any tenant handling themselves.  It should
+run after the logging interceptors so rejections show up in the request logs.
+*/
+type AuthorizationInterceptor struct {
+	store    *TenantConfigStore
+	identity IdentityProvider
+
+	// full method names or prefixes ending in / that skip tenant authorization
+	exemptMethods []string
+}
+
+func NewAuthorizationInterceptor(store *TenantConfigStore, identity IdentityProvider, exemptMethods ...string) *AuthorizationInterceptor {
+	if len(exemptMethods) == 0 {
+		exemptMethods = DefaultExemptMethods
+	}
+
+	return &AuthorizationInterceptor{
+		store:         store,
+		identity:      identity,
+		exemptMethods: exemptMethods,
+	}
+}
+
+func (a *AuthorizationInterceptor) exempt(fullMethod string) bool {
+	for _, m := range a.exemptMethods {
+		if fullMethod == m || (strings.HasSuffix(m, "/") && strings.HasPrefix(fullMethod, m)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/* authorize the tenant against the method's policy and return a context carrying its identity */
+func (a *AuthorizationInterceptor) authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	tenantId, err := a.identity.TenantId(ctx)
+	if err != nil {
+		ctxzap.Extract(ctx).Info("Unable to authenticate tenant",
+			zap.String("identity", a.identity.String()),
+			zap.Error(err),
+		)
+
+		return nil, err
+	}
+
+	t := a.store.Get()
+	decision := t.EvaluateMethod(fullMethod, tenantId)
+
+	grpc_ctxtags.Extract(ctx).Set("tenant.id", tenantId)
+
+	if !decision.Allowed {
+		err := t.RejectionError(decision)
+
+		ctxzap.Extract(ctx).Info("Rejected tenant",
+			zap.String("tenantId", tenantId),
+			zap.String("rule", decision.Rule()),
+			zap.String("precedence", decision.Precedence),
+			zap.String("reason", decision.Reason()),
+			zap.String("code", status.Code(err).String()),
+		)
+
+		return nil, err
+	}
+
+	return NewContext(ctx, TenantIdentity{TenantId: tenantId, Source: a.identity.String(), Decision: decision}), nil
+}
+
+func (a *AuthorizationInterceptor) AuthorizationUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if a.exempt(info.FullMethod) {
+		return handler(ctx, req)
+	}
+
+	ctx, err := a.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (a *AuthorizationInterceptor) AuthorizationStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if a.exempt(info.FullMethod) {
+		return handler(srv, ss)
+	}
+
+	ctx, err := a.authorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+
+	wrapped := grpc_middleware.WrapServerStream(ss)
+	wrapped.WrappedContext = ctx
+
+	return handler(srv, wrapped)
+}
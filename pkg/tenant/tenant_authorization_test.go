@@ -0,0 +1,222 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type authorizationServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authorizationServerStream) Context() context.Context {
+	return s.ctx
+}
+
+/* an incoming request context with the tenant header, or none if tenantId is empty */
+func incomingContext(tenantId string) context.Context {
+	md := metadata.MD{}
+	if tenantId != "" {
+		md.Set("x-tenant-id", tenantId)
+	}
+
+	return metadata.NewIncomingContext(context.Background(), md)
+}
+
+func newTestAuthorizationInterceptor(t *testing.T) *AuthorizationInterceptor {
+	t.Helper()
+
+	config := parseTestConfig(t, `
+shard: a
+shards:
+- name: b
+  prefix: ["b-"]
+allowed_tenants:
+- prefix: ["a-"]
+denied_tenants:
+- exactMatch: ["a-blocked"]
+methods:
+  /helloworld.Greeter/StreamingHello:
+    allowed_tenants:
+    - prefix: ["a-"]
+    denied_tenants:
+    - exactMatch: ["a-nostream"]
+`)
+
+	return NewAuthorizationInterceptor(NewTenantConfigStore(config), &HeaderIdentityProvider{})
+}
+
+func TestAuthorizationInterceptor(t *testing.T) {
+	a := newTestAuthorizationInterceptor(t)
+
+	tests := []struct {
+		name     string
+		method   string
+		tenantId string
+		code     codes.Code
+	}{
+		{"allowed", "/helloworld.Greeter/SayHello", "a-1", codes.OK},
+		{"missing header", "/helloworld.Greeter/SayHello", "", codes.InvalidArgument},
+		{"wrong shard", "/helloworld.Greeter/SayHello", "b-1", codes.FailedPrecondition},
+		{"denied", "/helloworld.Greeter/SayHello", "a-blocked", codes.PermissionDenied},
+		{"method denied", "/helloworld.Greeter/StreamingHello", "a-nostream", codes.PermissionDenied},
+		{"allowed by the method policy", "/helloworld.Greeter/StreamingHello", "a-1", codes.OK},
+		{"health check without a tenant", "/grpc.health.v1.Health/Check", "", codes.OK},
+		{"health check of another shard's tenant", "/grpc.health.v1.Health/Watch", "b-1", codes.OK},
+		{"reflection without a tenant", "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo", "", codes.OK},
+		{"a method named like an exempt service", "/grpc.health.v1.HealthCheck/Check", "", codes.InvalidArgument},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name+"/unary", func(t *testing.T) {
+			called := false
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				called = true
+				return nil, nil
+			}
+
+			_, err := a.AuthorizationUnaryInterceptor(incomingContext(test.tenantId), nil, &grpc.UnaryServerInfo{FullMethod: test.method}, handler)
+			if status.Code(err) != test.code {
+				t.Errorf("expected %v, got %v", test.code, err)
+			}
+
+			if called != (test.code == codes.OK) {
+				t.Errorf("handler called = %v, expected it only to be called if the tenant is authorized", called)
+			}
+		})
+
+		t.Run(test.name+"/stream", func(t *testing.T) {
+			called := false
+			handler := func(srv interface{}, ss grpc.ServerStream) error {
+				called = true
+				return nil
+			}
+
+			ss := &authorizationServerStream{ctx: incomingContext(test.tenantId)}
+			err := a.AuthorizationStreamInterceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: test.method}, handler)
+			if status.Code(err) != test.code {
+				t.Errorf("expected %v, got %v", test.code, err)
+			}
+
+			if called != (test.code == codes.OK) {
+				t.Errorf("handler called = %v, expected it only to be called if the tenant is authorized", called)
+			}
+		})
+	}
+}
+
+func TestAuthorizationInterceptorIdentity(t *testing.T) {
+	a := newTestAuthorizationInterceptor(t)
+
+	var unary, stream TenantIdentity
+	var unaryOk, streamOk bool
+
+	_, err := a.AuthorizationUnaryInterceptor(incomingContext("a-1"), nil, &grpc.UnaryServerInfo{FullMethod: "/helloworld.Greeter/SayHello"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			unary, unaryOk = FromContext(ctx)
+			return nil, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ss := &authorizationServerStream{ctx: incomingContext("a-1")}
+	err = a.AuthorizationStreamInterceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: "/helloworld.Greeter/StreamingHello"},
+		func(srv interface{}, ss grpc.ServerStream) error {
+			stream, streamOk = FromContext(ss.Context())
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, got := range []struct {
+		name     string
+		identity TenantIdentity
+		ok       bool
+		policy   string
+	}{
+		{"unary", unary, unaryOk, ""},
+		{"stream", stream, streamOk, "methods[/helloworld.Greeter/StreamingHello]"},
+	} {
+		if !got.ok {
+			t.Errorf("%v: expected the identity in the handler's context", got.name)
+			continue
+		}
+
+		if got.identity.TenantId != "a-1" || got.identity.Source != IdentityModeHeader {
+			t.Errorf("%v: expected tenant a-1 from %v, got %+v", got.name, IdentityModeHeader, got.identity)
+		}
+
+		if !got.identity.Decision.Allowed || got.identity.Decision.Policy != got.policy {
+			t.Errorf("%v: expected the allowing decision of policy %q, got %+v", got.name, got.policy, got.identity.Decision)
+		}
+	}
+
+	// exempt methods don't get one
+	_, err = a.AuthorizationUnaryInterceptor(incomingContext("a-1"), nil, &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			if _, ok := FromContext(ctx); ok {
+				t.Errorf("expected no identity for an exempt method")
+			}
+			return nil, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthorizationInterceptorLogsRule(t *testing.T) {
+	a := newTestAuthorizationInterceptor(t)
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	ctx := ctxzap.ToContext(incomingContext("a-blocked"), zap.New(core))
+
+	_, err := a.AuthorizationUnaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/helloworld.Greeter/SayHello"},
+		func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil })
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected %v, got %v", codes.PermissionDenied, err)
+	}
+
+	entries := logs.FilterMessage("Rejected tenant").All()
+	if len(entries) != 1 {
+		t.Fatalf("expected one rejection logged, got %v", logs.All())
+	}
+
+	fields := entries[0].ContextMap()
+	if fields["rule"] != "denied_tenants[0]" || fields["code"] != codes.PermissionDenied.String() {
+		t.Errorf("expected the rule and code logged, got %v", fields)
+	}
+}
+
+func TestAuthorizationInterceptorExemptMethods(t *testing.T) {
+	a := NewAuthorizationInterceptor(NewTenantConfigStore(makeDenyAllTenantConfig()), &HeaderIdentityProvider{},
+		"/helloworld.Greeter/SayHello", "/custom.Service/")
+
+	tests := []struct {
+		method string
+		exempt bool
+	}{
+		{"/helloworld.Greeter/SayHello", true},
+		{"/helloworld.Greeter/StreamingHello", false},
+		{"/custom.Service/Anything", true},
+		// the defaults are replaced, not extended
+		{"/grpc.health.v1.Health/Check", false},
+	}
+
+	for _, test := range tests {
+		if got := a.exempt(test.method); got != test.exempt {
+			t.Errorf("%v: expected exempt %v, got %v", test.method, test.exempt, got)
+		}
+	}
+}
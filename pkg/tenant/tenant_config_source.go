@@ -0,0 +1,345 @@
+package tenant
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* somewhere raw bytes can be fetched from and polled for changes, e.g. the tenant config or a JWKS */
+type Source interface {
+	// Fetch returns the current content
+	Fetch(ctx context.Context) ([]byte, error)
+	// String describes the source for logs
+	String() string
+}
+
+/* somewhere the raw tenant-config.yaml can be fetched from */
+type TenantConfigSource = Source
+
+const (
+	inClusterTokenFile     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	inClusterCAFile        = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	inClusterNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+	sourceFetchTimeout = 10 * time.Second
+)
+
+/*
+NewTenantConfigSource creates a tenant config source from a URL:
+
+	/config, file:///config                     tenant-config.yaml in a local directory
+	file:///config/tenant-config.yaml           a local file
+	http(s)://host/path/tenant-config.yaml      an HTTP(S) endpoint, polled with If-None-Match
+	configmap://namespace/name?key=...          a ConfigMap read through the kubernetes API server, the namespace
+	                                            defaults to the pod's and the key to tenant-config.yaml
+*/
+func NewTenantConfigSource(sourceURL string) (TenantConfigSource, error) {
+	return newSource(sourceURL, tenantConfigFile)
+}
+
+/*
+NewSource creates a source for a single file from a URL like NewTenantConfigSource, except that a path is used as is
+and a ConfigMap source needs an explicit key
+*/
+func NewSource(sourceURL string) (Source, error) {
+	return newSource(sourceURL, "")
+}
+
+/* defaultFile is read from a directory path and is the default ConfigMap key, empty for neither */
+func newSource(sourceURL string, defaultFile string) (Source, error) {
+	u, err := url.Parse(sourceURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source %q: %v", sourceURL, err)
+	}
+
+	switch u.Scheme {
+	case "", "file":
+		path := u.Path
+		if u.Scheme == "" {
+			path = sourceURL
+		}
+
+		if defaultFile != "" {
+			path = fileInDir(path, defaultFile)
+		}
+
+		return NewFileSource(path), nil
+
+	case "http", "https":
+		return NewHTTPSource(sourceURL, http.DefaultClient), nil
+
+	case "configmap":
+		name := strings.Trim(u.Path, "/")
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid source %q: expected configmap://namespace/name", sourceURL)
+		}
+
+		key := u.Query().Get("key")
+		if key == "" {
+			key = defaultFile
+		}
+		if key == "" {
+			return nil, fmt.Errorf("invalid source %q: expected configmap://namespace/name?key=...", sourceURL)
+		}
+
+		getter, err := NewInClusterConfigMapGetter()
+		if err != nil {
+			return nil, err
+		}
+
+		namespace := u.Host
+		if namespace == "" {
+			ns, err := ioutil.ReadFile(inClusterNamespaceFile)
+			if err != nil {
+				return nil, fmt.Errorf("unable to determine namespace: %v", err)
+			}
+			namespace = strings.TrimSpace(string(ns))
+		}
+
+		return NewConfigMapSource(getter, namespace, name, key), nil
+	}
+
+	return nil, fmt.Errorf("invalid source %q: unsupported scheme %q", sourceURL, u.Scheme)
+}
+
+/*
+reads a local file.  The file is re-read through its path on every fetch, so kubernetes configmap volume updates
+(which atomically swap the ..data symlink) are picked up like in-place edits.
+*/
+type FileSource struct {
+	path string
+}
+
+func NewFileSource(path string) *FileSource {
+	return &FileSource{path: path}
+}
+
+/* reads tenant-config.yaml if path is a directory, or path itself */
+func NewFileTenantConfigSource(path string) *FileSource {
+	return NewFileSource(fileInDir(path, tenantConfigFile))
+}
+
+/* the file in path if path is a directory, path otherwise */
+func fileInDir(path string, file string) string {
+	if info, err := os.Stat(path); (err == nil && info.IsDir()) || strings.HasSuffix(path, "/") {
+		return filepath.Join(filepath.Clean(path), file)
+	}
+
+	return path
+}
+
+func (s *FileSource) Fetch(ctx context.Context) ([]byte, error) {
+	return ioutil.ReadFile(s.path)
+}
+
+func (s *FileSource) String() string {
+	return "file://" + s.path
+}
+
+/* polls an HTTP(S) endpoint, only downloading the content again when its ETag changes */
+type HTTPSource struct {
+	url    string
+	client *http.Client
+
+	mu   sync.Mutex
+	etag string
+	body []byte
+}
+
+func NewHTTPSource(url string, client *http.Client) *HTTPSource {
+	return &HTTPSource{
+		url:    url,
+		client: client,
+	}
+}
+
+func (s *HTTPSource) Fetch(ctx context.Context) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, sourceFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch %v: %v", s.url, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		if s.etag == "" {
+			return nil, fmt.Errorf("unable to fetch %v: %v without If-None-Match", s.url, resp.Status)
+		}
+		return s.body, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("unable to fetch %v: %v", s.url, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %v: %v", s.url, err)
+	}
+
+	s.etag = resp.Header.Get("ETag")
+	s.body = body
+
+	return body, nil
+}
+
+func (s *HTTPSource) String() string {
+	return s.url
+}
+
+/* reads the data of a ConfigMap, the kubernetes API server in cluster or a stand-in in tests */
+type ConfigMapGetter interface {
+	GetConfigMap(ctx context.Context, namespace string, name string) (map[string]string, error)
+}
+
+/* reads a key from a ConfigMap */
+type ConfigMapSource struct {
+	getter    ConfigMapGetter
+	namespace string
+	name      string
+	key       string
+}
+
+func NewConfigMapSource(getter ConfigMapGetter, namespace string, name string, key string) *ConfigMapSource {
+	return &ConfigMapSource{
+		getter:    getter,
+		namespace: namespace,
+		name:      name,
+		key:       key,
+	}
+}
+
+func (s *ConfigMapSource) Fetch(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, sourceFetchTimeout)
+	defer cancel()
+
+	data, err := s.getter.GetConfigMap(ctx, s.namespace, s.name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch %v: %v", s, err)
+	}
+
+	value, ok := data[s.key]
+	if !ok {
+		return nil, fmt.Errorf("%v has no %v key", s, s.key)
+	}
+
+	return []byte(value), nil
+}
+
+func (s *ConfigMapSource) String() string {
+	return fmt.Sprintf("configmap://%v/%v?key=%v", s.namespace, s.name, s.key)
+}
+
+/* reads ConfigMaps through the kubernetes API server with a bearer token */
+type APIServerConfigMapGetter struct {
+	apiServer string
+	tokenFile string
+	client    *http.Client
+}
+
+/* NewInClusterConfigMapGetter uses the pod's service account */
+func NewInClusterConfigMapGetter() (*APIServerConfigMapGetter, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("configmap source only works in cluster, KUBERNETES_SERVICE_HOST/PORT are not set")
+	}
+
+	caCert, err := ioutil.ReadFile(inClusterCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load kubernetes CA: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no certificates found in %v", inClusterCAFile)
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+
+	return NewAPIServerConfigMapGetter("https://"+net.JoinHostPort(host, port), inClusterTokenFile, client), nil
+}
+
+/* a getter for an explicit API server, token file and client, e.g. a local stand-in */
+func NewAPIServerConfigMapGetter(apiServer string, tokenFile string, client *http.Client) *APIServerConfigMapGetter {
+	return &APIServerConfigMapGetter{
+		apiServer: strings.TrimSuffix(apiServer, "/"),
+		tokenFile: tokenFile,
+		client:    client,
+	}
+}
+
+func (g *APIServerConfigMapGetter) GetConfigMap(ctx context.Context, namespace string, name string) (map[string]string, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%v/api/v1/namespaces/%v/configmaps/%v",
+		g.apiServer, url.PathEscape(namespace), url.PathEscape(name)), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+
+	if g.tokenFile != "" {
+		// service account tokens are rotated, so read it every time
+		token, err := ioutil.ReadFile(g.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read service account token: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return nil, fmt.Errorf("%v, the service account token was rejected", resp.Status)
+	case http.StatusForbidden:
+		return nil, fmt.Errorf("%v, the service account needs get on configmaps in %v", resp.Status, namespace)
+	default:
+		return nil, fmt.Errorf("%v", resp.Status)
+	}
+
+	configMap := struct {
+		Data map[string]string `json:"data"`
+	}{}
+
+	if err := json.NewDecoder(resp.Body).Decode(&configMap); err != nil {
+		return nil, fmt.Errorf("unable to decode: %v", err)
+	}
+
+	return configMap.Data, nil
+}
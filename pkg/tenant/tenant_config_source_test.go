@@ -0,0 +1,317 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHTTPSourceETag(t *testing.T) {
+	var mu sync.Mutex
+	body, etag := "v1", `"1"`
+	var ifNoneMatch []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		ifNoneMatch = append(ifNoneMatch, r.Header.Get("If-None-Match"))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	source := NewHTTPSource(server.URL, server.Client())
+
+	fetch := func(want string) {
+		t.Helper()
+
+		data, err := source.Fetch(context.Background())
+		if err != nil {
+			t.Fatalf("Fetch() = %v", err)
+		}
+		if string(data) != want {
+			t.Errorf("Fetch() = %q, want %q", data, want)
+		}
+	}
+
+	fetch("v1")
+	// not modified, served from the cached body
+	fetch("v1")
+
+	mu.Lock()
+	body, etag = "v2", `"2"`
+	mu.Unlock()
+	fetch("v2")
+	fetch("v2")
+
+	want := []string{"", `"1"`, `"1"`, `"2"`}
+	if strings.Join(ifNoneMatch, ",") != strings.Join(want, ",") {
+		t.Errorf("If-None-Match = %q, want %q", ifNoneMatch, want)
+	}
+}
+
+func TestHTTPSourceErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		err    string
+	}{
+		{"server error", http.StatusInternalServerError, "500 Internal Server Error"},
+		{"not found", http.StatusNotFound, "404 Not Found"},
+		{"unauthorized", http.StatusUnauthorized, "401 Unauthorized"},
+		{"forbidden", http.StatusForbidden, "403 Forbidden"},
+		// nothing cached to serve
+		{"not modified without If-None-Match", http.StatusNotModified, "without If-None-Match"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+			}))
+			defer server.Close()
+
+			_, err := NewHTTPSource(server.URL, server.Client()).Fetch(context.Background())
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Fetch() = %v, want an error containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestHTTPSourceKeepsCacheOnError(t *testing.T) {
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"1"`)
+		fmt.Fprint(w, "v1")
+	}))
+	defer server.Close()
+
+	source := NewHTTPSource(server.URL, server.Client())
+	if _, err := source.Fetch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	failing = true
+	if _, err := source.Fetch(context.Background()); err == nil {
+		t.Fatalf("Fetch() = nil, want an error for 502")
+	}
+
+	failing = false
+	data, err := source.Fetch(context.Background())
+	if err != nil || string(data) != "v1" {
+		t.Errorf("Fetch() = %q, %v, want the cached v1", data, err)
+	}
+}
+
+/* an API server stand-in serving one ConfigMap to one token */
+func newFakeAPIServer(t *testing.T, token string, data string) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("Authorization") == "":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.Header.Get("Authorization") != "Bearer "+token:
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/api/v1/namespaces/other/configmaps/tenants":
+			w.WriteHeader(http.StatusForbidden)
+		case r.URL.Path != "/api/v1/namespaces/hellogrpc-a/configmaps/tenants":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"kind": "ConfigMap", "data": %v}`, data)
+		}
+	}))
+}
+
+func writeToken(t *testing.T, dir string, token string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestAPIServerConfigMapGetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configmap-source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := newFakeAPIServer(t, "secret", `{"tenant-config.yaml": "allowed_tenants: []"}`)
+	defer server.Close()
+
+	tokenFile := writeToken(t, dir, "secret")
+
+	tests := []struct {
+		name      string
+		tokenFile string
+		namespace string
+		cm        string
+		key       string
+		want      string
+		err       string
+	}{
+		{"ok", tokenFile, "hellogrpc-a", "tenants", "tenant-config.yaml", "allowed_tenants: []", ""},
+		{"missing key", tokenFile, "hellogrpc-a", "tenants", "other.yaml", "", "has no other.yaml key"},
+		{"not found", tokenFile, "hellogrpc-a", "missing", "tenant-config.yaml", "", "404 Not Found"},
+		{"forbidden", tokenFile, "other", "tenants", "tenant-config.yaml", "", "needs get on configmaps in other"},
+		{"no token", "", "hellogrpc-a", "tenants", "tenant-config.yaml", "", "token was rejected"},
+		{"unreadable token", filepath.Join(dir, "missing"), "hellogrpc-a", "tenants", "tenant-config.yaml", "", "unable to read service account token"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			getter := NewAPIServerConfigMapGetter(server.URL+"/", test.tokenFile, server.Client())
+			source := NewConfigMapSource(getter, test.namespace, test.cm, test.key)
+
+			data, err := source.Fetch(context.Background())
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("Fetch() = %v, want an error containing %q", err, test.err)
+				}
+				return
+			}
+
+			if err != nil || string(data) != test.want {
+				t.Errorf("Fetch() = %q, %v, want %q", data, err, test.want)
+			}
+		})
+	}
+
+	// a rotated token is picked up on the next fetch
+	source := NewConfigMapSource(NewAPIServerConfigMapGetter(server.URL, tokenFile, server.Client()),
+		"hellogrpc-a", "tenants", "tenant-config.yaml")
+
+	writeToken(t, dir, "stale")
+	if _, err := source.Fetch(context.Background()); err == nil {
+		t.Errorf("Fetch() with a rejected token = nil, want an error")
+	}
+
+	writeToken(t, dir, "secret")
+	if _, err := source.Fetch(context.Background()); err != nil {
+		t.Errorf("Fetch() after the token was rotated = %v", err)
+	}
+}
+
+/* a ConfigMapGetter backed by a map, keyed by namespace/name */
+type fakeConfigMapGetter map[string]map[string]string
+
+func (f fakeConfigMapGetter) GetConfigMap(ctx context.Context, namespace string, name string) (map[string]string, error) {
+	data, ok := f[namespace+"/"+name]
+	if !ok {
+		return nil, fmt.Errorf("configmaps %q not found", name)
+	}
+
+	return data, nil
+}
+
+func TestConfigMapSource(t *testing.T) {
+	getter := fakeConfigMapGetter{
+		"hellogrpc-a/tenants": {"tenant-config.yaml": "v1", "jwks.json": "{}"},
+	}
+
+	source := NewConfigMapSource(getter, "hellogrpc-a", "tenants", "jwks.json")
+	if data, err := source.Fetch(context.Background()); err != nil || string(data) != "{}" {
+		t.Errorf("Fetch() = %q, %v", data, err)
+	}
+
+	if got, want := source.String(), "configmap://hellogrpc-a/tenants?key=jwks.json"; got != want {
+		t.Errorf("String() = %v, want %v", got, want)
+	}
+
+	getter["hellogrpc-a/tenants"]["jwks.json"] = `{"keys": []}`
+	if data, _ := source.Fetch(context.Background()); string(data) != `{"keys": []}` {
+		t.Errorf("Fetch() = %q after the ConfigMap changed", data)
+	}
+
+	missing := NewConfigMapSource(getter, "hellogrpc-b", "tenants", "jwks.json")
+	if _, err := missing.Fetch(context.Background()); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Fetch() = %v, want not found", err)
+	}
+}
+
+func TestNewSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, tenantConfigFile), []byte("tenants"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		source func() (Source, error)
+		want   string
+		err    string
+	}{
+		{"tenant config in a directory", func() (Source, error) { return NewTenantConfigSource(dir) },
+			"file://" + filepath.Join(dir, tenantConfigFile), ""},
+		{"tenant config in a file:// directory", func() (Source, error) { return NewTenantConfigSource("file://" + dir + "/") },
+			"file://" + filepath.Join(dir, tenantConfigFile), ""},
+		{"generic directory is used as is", func() (Source, error) { return NewSource(dir) },
+			"file://" + dir, ""},
+		{"generic file", func() (Source, error) { return NewSource("file://" + dir + "/jwks.json") },
+			"file://" + dir + "/jwks.json", ""},
+		{"http", func() (Source, error) { return NewSource("https://example.com/jwks.json") },
+			"https://example.com/jwks.json", ""},
+		{"generic configmap needs a key", func() (Source, error) { return NewSource("configmap://ns/jwks") },
+			"", "expected configmap://namespace/name?key="},
+		{"configmap without a name", func() (Source, error) { return NewTenantConfigSource("configmap://ns/") },
+			"", "expected configmap://namespace/name"},
+		{"unsupported scheme", func() (Source, error) { return NewSource("ftp://example.com/x") },
+			"", `unsupported scheme "ftp"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source, err := test.source()
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("err = %v, want an error containing %q", err, test.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if source.String() != test.want {
+				t.Errorf("String() = %v, want %v", source, test.want)
+			}
+		})
+	}
+
+	// the generic source doesn't look inside a directory
+	jwks, _ := NewSource(dir)
+	if _, err := jwks.Fetch(context.Background()); err == nil {
+		t.Errorf("Fetch() of a directory = nil, want an error")
+	}
+}
@@ -0,0 +1,38 @@
+package tenant
+
+import (
+	"sync/atomic"
+)
+
+/* holds the active tenant config so it can be swapped atomically while requests are being served */
+type TenantConfigStore struct {
+	current atomic.Value
+}
+
+func NewTenantConfigStore(t *TenantConfig) *TenantConfigStore {
+	s := &TenantConfigStore{}
+	s.Set(t)
+
+	return s
+}
+
+func (s *TenantConfigStore) Get() *TenantConfig {
+	return s.current.Load().(*TenantConfig)
+}
+
+func (s *TenantConfigStore) Set(t *TenantConfig) {
+	s.current.Store(t)
+}
+
+func (s *TenantConfigStore) Evaluate(tenantIdToCheck string) TenantDecision {
+	return s.Get().Evaluate(tenantIdToCheck)
+}
+
+func (s *TenantConfigStore) CheckTenantId(tenantIdToCheck string) bool {
+	return s.Get().CheckTenantId(tenantIdToCheck)
+}
+
+/* Serving is false while a fail-closed fallback config is active because the tenant config could not be loaded */
+func (s *TenantConfigStore) Serving() bool {
+	return s.Get().Fallback() != FailurePolicyFailClosed
+}
@@ -0,0 +1,178 @@
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultReloadInterval = 10 * time.Second
+)
+
+/*
+polls the tenant config source and swaps new tenant configs into the store.  A config that fails to load or
+validate is rejected and the last good config stays active.
+*/
+type TenantConfigWatcher struct {
+	source  TenantConfigSource
+	store   *TenantConfigStore
+	logger  *zap.Logger
+	metrics *tenantConfigMetrics
+
+	lastError  string
+	lastFailed string
+}
+
+type tenantConfigMetrics struct {
+	reloads    prometheus.CounterVec
+	lastReload prometheus.Gauge
+	configInfo prometheus.GaugeVec
+}
+
+func NewTenantConfigWatcher(source TenantConfigSource, store *TenantConfigStore, logger *zap.Logger) *TenantConfigWatcher {
+	w := &TenantConfigWatcher{
+		source:  source,
+		store:   store,
+		logger:  logger,
+		metrics: &tenantConfigMetrics{},
+	}
+
+	if err := w.metrics.init(); err != nil {
+		logger.Warn("Unable to register tenant config metrics", zap.Error(err))
+	}
+
+	w.metrics.setConfigHash("", store.Get().Hash())
+
+	return w
+}
+
+func (metrics *tenantConfigMetrics) init() error {
+	metrics.reloads = *prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tenant_config_reloads_total",
+			Help: "Number of tenant config reload attempts by result",
+		},
+		[]string{"result"},
+	)
+
+	metrics.lastReload = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tenant_config_last_reload_success_timestamp_seconds",
+			Help: "Time of the last successful tenant config reload",
+		},
+	)
+
+	metrics.configInfo = *prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tenant_config_info",
+			Help: "Always 1, labelled with the sha256 of the active tenant config",
+		},
+		[]string{"hash"},
+	)
+
+	for _, c := range []prometheus.Collector{metrics.reloads, metrics.lastReload, metrics.configInfo} {
+		if err := prometheus.Register(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (metrics *tenantConfigMetrics) setConfigHash(oldHash string, newHash string) {
+	metrics.configInfo.DeleteLabelValues(oldHash)
+	metrics.configInfo.WithLabelValues(newHash).Set(1)
+}
+
+/* Watch polls the source every interval until the context is cancelled */
+func (w *TenantConfigWatcher) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.Reload(ctx)
+		}
+	}
+}
+
+/* Reload re-reads the tenant config and swaps it in if it changed, returns true if a new config is active */
+func (w *TenantConfigWatcher) Reload(ctx context.Context) bool {
+	yamlFile, err := w.source.Fetch(ctx)
+	if err != nil {
+		w.reloadFailed("", err)
+		return false
+	}
+
+	oldHash := w.store.Get().Hash()
+	newHash := hashTenantConfig(yamlFile)
+	if newHash == oldHash {
+		// nothing changed, or the file was reverted to the active config after a bad edit
+		w.lastError = ""
+		w.lastFailed = ""
+		return false
+	}
+
+	if newHash == w.lastFailed {
+		// already rejected this config, count it without parsing or logging it again
+		w.metrics.reloads.WithLabelValues("failure").Inc()
+		return false
+	}
+
+	t, err := ParseTenantConfig(yamlFile)
+	if err != nil {
+		w.reloadFailed(newHash, err)
+		return false
+	}
+
+	w.store.Set(t)
+	w.lastError = ""
+	w.lastFailed = ""
+
+	w.metrics.reloads.WithLabelValues("success").Inc()
+	w.metrics.lastReload.SetToCurrentTime()
+	w.metrics.setConfigHash(oldHash, newHash)
+
+	tenantConfigJSON, _ := json.Marshal(t)
+	w.logger.Info("Reloaded tenant config",
+		zap.String("source", w.source.String()),
+		zap.String("oldHash", oldHash),
+		zap.String("hash", newHash),
+		zap.String("tenantConfigJson", string(tenantConfigJSON)),
+	)
+	for _, warning := range t.Deprecations() {
+		w.logger.Warn("Deprecated tenant config rule", zap.String("rule", warning.Rule), zap.String("warning", warning.Message))
+	}
+
+	return true
+}
+
+func (w *TenantConfigWatcher) reloadFailed(hash string, err error) {
+	w.lastFailed = hash
+	w.metrics.reloads.WithLabelValues("failure").Inc()
+
+	// only log each distinct failure once so a bad config doesn't spam the logs every interval
+	logKey := hash + ": " + err.Error()
+	if logKey == w.lastError {
+		return
+	}
+	w.lastError = logKey
+
+	w.logger.Warn("Rejected tenant config, keeping last good config",
+		zap.String("source", w.source.String()),
+		zap.String("hash", hash),
+		zap.String("activeHash", w.store.Get().Hash()),
+		zap.Error(err),
+	)
+}
@@ -0,0 +1,85 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+/* a source that returns whatever the test sets */
+type fakeTenantConfigSource struct {
+	yaml []byte
+	err  error
+}
+
+func (s *fakeTenantConfigSource) Fetch(ctx context.Context) ([]byte, error) {
+	return s.yaml, s.err
+}
+
+func (s *fakeTenantConfigSource) String() string {
+	return "fake"
+}
+
+func TestTenantConfigWatcherReload(t *testing.T) {
+	good := []byte("allowed_tenants:\n- exactMatch: [tenant-a]\n")
+	goodB := []byte("allowed_tenants:\n- exactMatch: [tenant-b]\n")
+	bad := []byte("allowed_tenants:\n- exactMatch: [tenant-a]\n  bogus: [\n")
+	// same error text as bad, different config
+	bad2 := []byte("allowed_tenants:\n- exactMatch: [tenant-b]\n  bogus: [\n")
+
+	initial, err := ParseTenantConfig(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := &fakeTenantConfigSource{yaml: good}
+	store := NewTenantConfigStore(initial)
+	w := NewTenantConfigWatcher(source, store, zap.NewNop())
+	failures := w.metrics.reloads.WithLabelValues("failure")
+	successes := w.metrics.reloads.WithLabelValues("success")
+
+	steps := []struct {
+		name      string
+		yaml      []byte
+		err       error
+		reloaded  bool
+		failures  float64
+		successes float64
+		accepts   string
+	}{
+		{"unchanged", good, nil, false, 0, 0, "tenant-a"},
+		{"bad config", bad, nil, false, 1, 0, "tenant-a"},
+		// still in place, counted again without being parsed
+		{"same bad config", bad, nil, false, 2, 0, "tenant-a"},
+		{"another bad config", bad2, nil, false, 3, 0, "tenant-a"},
+		{"fetch error", nil, fmt.Errorf("unreachable"), false, 4, 0, "tenant-a"},
+		{"same fetch error", nil, fmt.Errorf("unreachable"), false, 5, 0, "tenant-a"},
+		{"reverted", good, nil, false, 5, 0, "tenant-a"},
+		{"new config", goodB, nil, true, 5, 1, "tenant-b"},
+		{"bad after new", bad, nil, false, 6, 1, "tenant-b"},
+	}
+
+	for _, step := range steps {
+		source.yaml, source.err = step.yaml, step.err
+
+		if got := w.Reload(context.Background()); got != step.reloaded {
+			t.Errorf("%v: Reload() = %v, want %v", step.name, got, step.reloaded)
+		}
+		if got := testutil.ToFloat64(failures); got != step.failures {
+			t.Errorf("%v: failures = %v, want %v", step.name, got, step.failures)
+		}
+		if got := testutil.ToFloat64(successes); got != step.successes {
+			t.Errorf("%v: successes = %v, want %v", step.name, got, step.successes)
+		}
+		if !store.CheckTenantId(step.accepts) {
+			t.Errorf("%v: active config rejects %v", step.name, step.accepts)
+		}
+	}
+
+	if w.lastFailed != hashTenantConfig(bad) {
+		t.Errorf("lastFailed = %v, want the hash of the last rejected config", w.lastFailed)
+	}
+}
@@ -0,0 +1,160 @@
+package tenant
+
+import (
+	"fmt"
+)
+
+/* the result of checking a tenant id against the tenant config, including which rule decided it */
+type TenantDecision struct {
+	TenantId   string `json:"tenantId"`
+	Allowed    bool   `json:"allowed"`
+	Precedence string `json:"precedence"`
+
+	// the rule list and index of the rule that matched, e.g. denied_tenants[0].  RuleIndex is -1 if no
+	// rule matched and the default (deny) was applied
+	RuleSet   string `json:"ruleSet,omitempty"`
+	RuleIndex int    `json:"ruleIndex"`
+
+	// the method override that was evaluated, e.g. methods[/helloworld.Greeter/StreamingHello], empty for the
+	// global policy
+	Policy string `json:"policy,omitempty"`
+}
+
+type candidateRule struct {
+	action  string
+	ruleSet string
+	index   int
+	match   TenantMatch
+}
+
+func (d TenantDecision) Matched() bool {
+	return d.RuleIndex >= 0
+}
+
+func (d TenantDecision) Rule() string {
+	if !d.Matched() {
+		return ""
+	}
+
+	if d.Policy != "" {
+		return fmt.Sprintf("%v.%v[%d]", d.Policy, d.RuleSet, d.RuleIndex)
+	}
+
+	return fmt.Sprintf("%v[%d]", d.RuleSet, d.RuleIndex)
+}
+
+func (d TenantDecision) Reason() string {
+	if !d.Matched() && d.Policy != "" {
+		return fmt.Sprintf("no rule in %v matched tenant %v, denied by default (%v)", d.Policy, d.TenantId, d.Precedence)
+	}
+
+	if !d.Matched() {
+		return fmt.Sprintf("no rule matched tenant %v, denied by default (%v)", d.TenantId, d.Precedence)
+	}
+
+	action := "denied"
+	if d.Allowed {
+		action = "allowed"
+	}
+
+	return fmt.Sprintf("tenant %v %v by %v (%v)", d.TenantId, action, d.Rule(), d.Precedence)
+}
+
+func (p *TenantPolicy) allowRules() []candidateRule {
+	rules := make([]candidateRule, 0, len(p.Rules)+len(p.AllowedTenants))
+	for i, r := range p.Rules {
+		if r.Action == ActionAllow {
+			rules = append(rules, candidateRule{ActionAllow, "rules", i, r.TenantMatch})
+		}
+	}
+
+	for i, m := range p.AllowedTenants {
+		rules = append(rules, candidateRule{ActionAllow, "allowed_tenants", i, m})
+	}
+
+	return rules
+}
+
+func (p *TenantPolicy) denyRules() []candidateRule {
+	rules := make([]candidateRule, 0, len(p.Rules)+len(p.DeniedTenants))
+	for i, r := range p.Rules {
+		if r.Action == ActionDeny {
+			rules = append(rules, candidateRule{ActionDeny, "rules", i, r.TenantMatch})
+		}
+	}
+
+	for i, m := range p.DeniedTenants {
+		rules = append(rules, candidateRule{ActionDeny, "denied_tenants", i, m})
+	}
+
+	return rules
+}
+
+/* in first-match mode the ordered rules list is evaluated first, followed by denied_tenants and then allowed_tenants */
+func (p *TenantPolicy) orderedRules() []candidateRule {
+	rules := make([]candidateRule, 0, len(p.Rules)+len(p.DeniedTenants)+len(p.AllowedTenants))
+	for i, r := range p.Rules {
+		rules = append(rules, candidateRule{r.Action, "rules", i, r.TenantMatch})
+	}
+
+	for i, m := range p.DeniedTenants {
+		rules = append(rules, candidateRule{ActionDeny, "denied_tenants", i, m})
+	}
+
+	for i, m := range p.AllowedTenants {
+		rules = append(rules, candidateRule{ActionAllow, "allowed_tenants", i, m})
+	}
+
+	return rules
+}
+
+func firstMatch(tenantIdToCheck string, rules []candidateRule) *candidateRule {
+	for i := range rules {
+		if tenantMatches(tenantIdToCheck, rules[i].match) {
+			return &rules[i]
+		}
+	}
+
+	return nil
+}
+
+/* Evaluate checks the tenant id against the allow and deny rules using the configured precedence */
+func (p *TenantPolicy) Evaluate(tenantIdToCheck string) TenantDecision {
+	precedence := p.Precedence
+	if precedence == "" {
+		precedence = PrecedenceDenyOverrides
+	}
+
+	decision := TenantDecision{
+		TenantId:   tenantIdToCheck,
+		Allowed:    false,
+		Precedence: precedence,
+		RuleIndex:  -1,
+		Policy:     p.name,
+	}
+
+	var matched *candidateRule
+
+	switch precedence {
+	case PrecedenceAllowOverrides:
+		matched = firstMatch(tenantIdToCheck, p.allowRules())
+		if matched == nil {
+			matched = firstMatch(tenantIdToCheck, p.denyRules())
+		}
+	case PrecedenceFirstMatch:
+		matched = firstMatch(tenantIdToCheck, p.orderedRules())
+	default:
+		matched = firstMatch(tenantIdToCheck, p.denyRules())
+		if matched == nil {
+			matched = firstMatch(tenantIdToCheck, p.allowRules())
+		}
+	}
+
+	if matched != nil {
+		decision.Allowed = matched.action == ActionAllow
+		decision.RuleSet = matched.ruleSet
+		decision.RuleIndex = matched.index
+	}
+
+	return decision
+}
@@ -0,0 +1,125 @@
+package tenant
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/status"
+)
+
+func parseTestConfig(t *testing.T, yaml string) *TenantConfig {
+	t.Helper()
+
+	config, err := ParseTenantConfig([]byte(yaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return config
+}
+
+func TestEvaluatePrecedence(t *testing.T) {
+	// tenant-a1 matches both the allow and the deny list
+	lists := `
+allowed_tenants:
+- prefix: [tenant-a]
+denied_tenants:
+- exactMatch: [tenant-a1]
+`
+
+	// the first matching rule wins, the lists after the ordered rules
+	ordered := `
+precedence: first-match
+rules:
+- action: allow
+  exactMatch: [tenant-a1]
+- action: deny
+  prefix: [tenant-a]
+allowed_tenants:
+- prefix: [tenant]
+denied_tenants:
+- exactMatch: [tenant-b1]
+`
+
+	tests := []struct {
+		name     string
+		config   string
+		tenantId string
+		allowed  bool
+		rule     string
+	}{
+		{"deny-overrides is the default", lists, "tenant-a1", false, "denied_tenants[0]"},
+		{"deny-overrides allows the rest of the range", lists, "tenant-a2", true, "allowed_tenants[0]"},
+		{"deny-overrides default deny", lists, "tenant-b1", false, ""},
+		{"deny-overrides explicit", "precedence: deny-overrides\n" + lists, "tenant-a1", false, "denied_tenants[0]"},
+
+		{"allow-overrides", "precedence: allow-overrides\n" + lists, "tenant-a1", true, "allowed_tenants[0]"},
+		{"allow-overrides deny without allow", "precedence: allow-overrides\nallowed_tenants: []\ndenied_tenants:\n- exactMatch: [tenant-a1]\n", "tenant-a1", false, "denied_tenants[0]"},
+		{"allow-overrides default deny", "precedence: allow-overrides\n" + lists, "tenant-b1", false, ""},
+
+		{"first-match first rule", ordered, "tenant-a1", true, "rules[0]"},
+		{"first-match second rule", ordered, "tenant-a2", false, "rules[1]"},
+		{"first-match denied list before allowed list", ordered, "tenant-b1", false, "denied_tenants[0]"},
+		{"first-match allowed list", ordered, "tenant-b2", true, "allowed_tenants[0]"},
+		{"first-match default deny", ordered, "other", false, ""},
+
+		{"rules with deny-overrides", "rules:\n- action: allow\n  prefix: [t]\n- action: deny\n  exactMatch: [t1]\n", "t1", false, "rules[1]"},
+		{"empty config denies", "allowed_tenants: []\n", "tenant-a1", false, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decision := parseTestConfig(t, test.config).Evaluate(test.tenantId)
+
+			if decision.Allowed != test.allowed {
+				t.Errorf("expected allowed %v, got %v (%v)", test.allowed, decision.Allowed, decision.Reason())
+			}
+			if decision.Rule() != test.rule {
+				t.Errorf("expected rule %q, got %q", test.rule, decision.Rule())
+			}
+			if decision.Matched() != (test.rule != "") {
+				t.Errorf("expected matched %v, got %v", test.rule != "", decision.Matched())
+			}
+		})
+	}
+}
+
+func TestDecisionReason(t *testing.T) {
+	config := parseTestConfig(t, "allowed_tenants:\n- prefix: [tenant-a]\ndenied_tenants:\n- exactMatch: [tenant-a1]\n")
+
+	tests := []struct {
+		tenantId string
+		reason   string
+	}{
+		{"tenant-a1", "tenant tenant-a1 denied by denied_tenants[0] (deny-overrides)"},
+		{"tenant-a2", "tenant tenant-a2 allowed by allowed_tenants[0] (deny-overrides)"},
+		{"other", "no rule matched tenant other, denied by default (deny-overrides)"},
+	}
+
+	for _, test := range tests {
+		if reason := config.Evaluate(test.tenantId).Reason(); reason != test.reason {
+			t.Errorf("%v: expected %q, got %q", test.tenantId, test.reason, reason)
+		}
+	}
+}
+
+func TestRejectionErrorHidesRules(t *testing.T) {
+	config := parseTestConfig(t, "allowed_tenants:\n- prefix: [tenant-a]\ndenied_tenants:\n- exactMatch: [tenant-a1]\n")
+
+	for _, tenantId := range []string{"tenant-a1", "other"} {
+		st := status.Convert(config.RejectionError(config.Evaluate(tenantId)))
+
+		texts := []string{st.Message()}
+		for _, detail := range st.Proto().GetDetails() {
+			texts = append(texts, string(detail.GetValue()))
+		}
+
+		for _, text := range texts {
+			for _, internal := range []string{"denied_tenants", "allowed_tenants", "[0]", "deny-overrides"} {
+				if strings.Contains(text, internal) {
+					t.Errorf("%v: expected the rejection not to reveal %q, got %q", tenantId, internal, text)
+				}
+			}
+		}
+	}
+}
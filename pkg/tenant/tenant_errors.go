@@ -0,0 +1,144 @@
+package tenant
+
+import (
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/* the google.rpc.ErrorInfo domain and reasons of tenant rejections */
+const (
+	ErrorDomain = "helloworld.tenant"
+
+	// the tenant belongs on another shard, the request was misrouted
+	ReasonWrongShard = "WRONG_SHARD"
+	// a deny rule matched the tenant and no other shard owns it
+	ReasonTenantDenied = "TENANT_DENIED"
+	// the tenant config couldn't be loaded and the failure policy is fail-closed
+	ReasonTenantConfigUnavailable = "TENANT_CONFIG_UNAVAILABLE"
+
+	ResourceTypeTenant = "tenant"
+)
+
+/* ErrorInfo metadata keys */
+const (
+	MetadataTenantId     = "tenant_id"
+	MetadataShard        = "shard"
+	MetadataOwnerShard   = "owner_shard"
+	MetadataOwnerAddress = "owner_address"
+)
+
+/*
+RejectionError builds the status returned to a client whose tenant was rejected.  A misroute is FailedPrecondition
+with reason WRONG_SHARD and, if the shards list knows it, the owning shard, so clients can retry against the right
+backend.  A tenant explicitly denied and owned by no other shard is PermissionDenied, and a fail-closed server is
+Unavailable so clients try another backend.  A tenant this shard serves that a method override rejects is also
+PermissionDenied.  Clients only get a generic reason, the rule that decided is logged on the server instead.
+*/
+func (t *TenantConfig) RejectionError(decision TenantDecision) error {
+	code, reason := codes.FailedPrecondition, ReasonWrongShard
+	message := fmt.Sprintf("Wrong Tenant-Id for instance: tenant %v is not served by this shard", decision.TenantId)
+
+	// this shard serves the tenant, just not through this method
+	methodDenied := decision.Policy != "" && t.CheckTenantId(decision.TenantId)
+
+	owner := t.OwningShard(decision.TenantId)
+	if methodDenied {
+		owner = nil
+	}
+
+	switch {
+	case t.Fallback() == FailurePolicyFailClosed:
+		code, reason = codes.Unavailable, ReasonTenantConfigUnavailable
+		message = "Tenant config unavailable, rejecting all tenants"
+	case methodDenied:
+		code, reason = codes.PermissionDenied, ReasonTenantDenied
+		message = fmt.Sprintf("Tenant-Id denied for method: tenant %v may not call this method", decision.TenantId)
+	case owner == nil && decision.Matched():
+		code, reason = codes.PermissionDenied, ReasonTenantDenied
+		message = fmt.Sprintf("Tenant-Id denied: tenant %v is not allowed", decision.TenantId)
+	}
+
+	info := &errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: ErrorDomain,
+		Metadata: map[string]string{
+			MetadataTenantId: decision.TenantId,
+		},
+	}
+
+	resource := &errdetails.ResourceInfo{
+		ResourceType: ResourceTypeTenant,
+		ResourceName: decision.TenantId,
+		Description:  message,
+	}
+
+	if t.Shard != "" {
+		info.Metadata[MetadataShard] = t.Shard
+	}
+
+	if owner != nil && reason == ReasonWrongShard {
+		info.Metadata[MetadataOwnerShard] = owner.Name
+		resource.Owner = owner.Name
+
+		if owner.Address != "" {
+			info.Metadata[MetadataOwnerAddress] = owner.Address
+		}
+	}
+
+	st, err := status.New(code, message).WithDetails(info, resource)
+	if err != nil {
+		// only fails if the details can't be marshalled
+		return status.Error(code, message)
+	}
+
+	return st.Err()
+}
+
+/* a WRONG_SHARD rejection as seen by a client */
+type WrongShard struct {
+	TenantId     string
+	Shard        string
+	OwnerShard   string
+	OwnerAddress string
+}
+
+/* WrongShardFromError extracts the redirect hint from a WRONG_SHARD rejection, returns false for other errors */
+func WrongShardFromError(err error) (WrongShard, bool) {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.FailedPrecondition {
+		return WrongShard{}, false
+	}
+
+	for _, detail := range st.Details() {
+		info, ok := detail.(*errdetails.ErrorInfo)
+		if !ok || info.GetDomain() != ErrorDomain || info.GetReason() != ReasonWrongShard {
+			continue
+		}
+
+		return WrongShard{
+			TenantId:     info.GetMetadata()[MetadataTenantId],
+			Shard:        info.GetMetadata()[MetadataShard],
+			OwnerShard:   info.GetMetadata()[MetadataOwnerShard],
+			OwnerAddress: info.GetMetadata()[MetadataOwnerAddress],
+		}, true
+	}
+
+	return WrongShard{}, false
+}
+
+/* attach a google.rpc.ErrorInfo in the tenant domain to a status */
+func withErrorInfo(st *status.Status, reason string, metadata map[string]string) error {
+	withDetails, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   ErrorDomain,
+		Metadata: metadata,
+	})
+	if err != nil {
+		return st.Err()
+	}
+
+	return withDetails.Err()
+}
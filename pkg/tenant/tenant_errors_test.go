@@ -0,0 +1,251 @@
+package tenant
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const shardedTestConfig = `
+shard: a
+shards:
+- name: a
+  prefix: ["a-"]
+- name: b
+  address: hellogrpc-b.example.com:443
+  prefix: ["b-"]
+- name: c
+  prefix: ["c-"]
+allowed_tenants:
+- prefix: ["a-"]
+denied_tenants:
+- exactMatch: ["a-blocked", "d-blocked"]
+methods:
+  /helloworld.Greeter/StreamingHello:
+    denied_tenants:
+    - exactMatch: ["a-nostream"]
+`
+
+/* the ErrorInfo and ResourceInfo details of a rejection */
+func rejectionDetails(t *testing.T, err error) (*status.Status, *errdetails.ErrorInfo, *errdetails.ResourceInfo) {
+	t.Helper()
+
+	st, ok := status.FromError(err)
+	if !ok {
+		t.Fatalf("%v is not a status error", err)
+	}
+
+	var info *errdetails.ErrorInfo
+	var resource *errdetails.ResourceInfo
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			info = d
+		case *errdetails.ResourceInfo:
+			resource = d
+		}
+	}
+
+	if info == nil || resource == nil {
+		t.Fatalf("%v: expected an ErrorInfo and a ResourceInfo, got %v", err, st.Details())
+	}
+
+	return st, info, resource
+}
+
+func TestRejectionError(t *testing.T) {
+	config := parseTestConfig(t, shardedTestConfig)
+
+	tests := []struct {
+		name     string
+		method   string
+		tenantId string
+		code     codes.Code
+		reason   string
+		metadata map[string]string
+		owner    string
+	}{
+		{
+			name:     "wrong shard with an address",
+			tenantId: "b-1",
+			code:     codes.FailedPrecondition,
+			reason:   ReasonWrongShard,
+			metadata: map[string]string{MetadataTenantId: "b-1", MetadataShard: "a", MetadataOwnerShard: "b", MetadataOwnerAddress: "hellogrpc-b.example.com:443"},
+			owner:    "b",
+		},
+		{
+			name:     "wrong shard without an address",
+			tenantId: "c-1",
+			code:     codes.FailedPrecondition,
+			reason:   ReasonWrongShard,
+			metadata: map[string]string{MetadataTenantId: "c-1", MetadataShard: "a", MetadataOwnerShard: "c"},
+			owner:    "c",
+		},
+		{
+			name:     "wrong shard with no known owner",
+			tenantId: "x-1",
+			code:     codes.FailedPrecondition,
+			reason:   ReasonWrongShard,
+			metadata: map[string]string{MetadataTenantId: "x-1", MetadataShard: "a"},
+		},
+		{
+			name:     "denied",
+			tenantId: "a-blocked",
+			code:     codes.PermissionDenied,
+			reason:   ReasonTenantDenied,
+			metadata: map[string]string{MetadataTenantId: "a-blocked", MetadataShard: "a"},
+		},
+		{
+			name:     "denied and owned by no shard",
+			tenantId: "d-blocked",
+			code:     codes.PermissionDenied,
+			reason:   ReasonTenantDenied,
+			metadata: map[string]string{MetadataTenantId: "d-blocked", MetadataShard: "a"},
+		},
+		{
+			name:     "method denied",
+			method:   "/helloworld.Greeter/StreamingHello",
+			tenantId: "a-nostream",
+			code:     codes.PermissionDenied,
+			reason:   ReasonTenantDenied,
+			metadata: map[string]string{MetadataTenantId: "a-nostream", MetadataShard: "a"},
+		},
+		{
+			name:     "method policy on a tenant of another shard",
+			method:   "/helloworld.Greeter/StreamingHello",
+			tenantId: "b-1",
+			code:     codes.FailedPrecondition,
+			reason:   ReasonWrongShard,
+			metadata: map[string]string{MetadataTenantId: "b-1", MetadataShard: "a", MetadataOwnerShard: "b", MetadataOwnerAddress: "hellogrpc-b.example.com:443"},
+			owner:    "b",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decision := config.EvaluateMethod(test.method, test.tenantId)
+			if decision.Allowed {
+				t.Fatalf("%v is allowed, expected a rejection", test.tenantId)
+			}
+
+			st, info, resource := rejectionDetails(t, config.RejectionError(decision))
+
+			if st.Code() != test.code {
+				t.Errorf("expected code %v, got %v", test.code, st.Code())
+			}
+
+			if info.GetDomain() != ErrorDomain || info.GetReason() != test.reason {
+				t.Errorf("expected %v %v, got %v %v", ErrorDomain, test.reason, info.GetDomain(), info.GetReason())
+			}
+
+			if !reflect.DeepEqual(info.GetMetadata(), test.metadata) {
+				t.Errorf("expected metadata %v, got %v", test.metadata, info.GetMetadata())
+			}
+
+			if resource.GetResourceType() != ResourceTypeTenant || resource.GetResourceName() != test.tenantId {
+				t.Errorf("expected resource %v %v, got %v %v", ResourceTypeTenant, test.tenantId, resource.GetResourceType(), resource.GetResourceName())
+			}
+
+			if resource.GetOwner() != test.owner {
+				t.Errorf("expected resource owner %q, got %q", test.owner, resource.GetOwner())
+			}
+
+			if resource.GetDescription() != st.Message() {
+				t.Errorf("expected the resource description to be the message %q, got %q", st.Message(), resource.GetDescription())
+			}
+		})
+	}
+}
+
+func TestRejectionErrorFailClosed(t *testing.T) {
+	config := makeDenyAllTenantConfig()
+
+	st, info, _ := rejectionDetails(t, config.RejectionError(config.Evaluate("tenant-a")))
+
+	if st.Code() != codes.Unavailable {
+		t.Errorf("expected code %v, got %v", codes.Unavailable, st.Code())
+	}
+
+	if info.GetReason() != ReasonTenantConfigUnavailable {
+		t.Errorf("expected reason %v, got %v", ReasonTenantConfigUnavailable, info.GetReason())
+	}
+
+	if _, ok := WrongShardFromError(config.RejectionError(config.Evaluate("tenant-a"))); ok {
+		t.Errorf("a fail-closed rejection is not a redirect")
+	}
+}
+
+func TestWrongShardFromError(t *testing.T) {
+	config := parseTestConfig(t, shardedTestConfig)
+
+	tests := []struct {
+		name string
+		err  error
+		want WrongShard
+		ok   bool
+	}{
+		{
+			name: "wrong shard",
+			err:  config.RejectionError(config.Evaluate("b-1")),
+			want: WrongShard{TenantId: "b-1", Shard: "a", OwnerShard: "b", OwnerAddress: "hellogrpc-b.example.com:443"},
+			ok:   true,
+		},
+		{
+			name: "owner without an address",
+			err:  config.RejectionError(config.Evaluate("c-1")),
+			want: WrongShard{TenantId: "c-1", Shard: "a", OwnerShard: "c"},
+			ok:   true,
+		},
+		{
+			name: "denied",
+			err:  config.RejectionError(config.Evaluate("a-blocked")),
+		},
+		{
+			name: "method denied",
+			err:  config.RejectionError(config.EvaluateMethod("/helloworld.Greeter/StreamingHello", "a-nostream")),
+		},
+		{
+			name: "FailedPrecondition without details",
+			err:  status.Error(codes.FailedPrecondition, "Wrong Tenant-Id for instance"),
+		},
+		{
+			name: "WRONG_SHARD from another domain",
+			err:  withForeignErrorInfo(t, ReasonWrongShard),
+		},
+		{
+			name: "not a status",
+			err:  fmt.Errorf("connection refused"),
+		},
+		{
+			name: "nil",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := WrongShardFromError(test.err)
+			if ok != test.ok || got != test.want {
+				t.Errorf("expected %+v, %v, got %+v, %v", test.want, test.ok, got, ok)
+			}
+		})
+	}
+}
+
+func withForeignErrorInfo(t *testing.T, reason string) error {
+	t.Helper()
+
+	st, err := status.New(codes.FailedPrecondition, "misrouted").WithDetails(&errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   "example.com",
+		Metadata: map[string]string{MetadataOwnerAddress: "elsewhere:443"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return st.Err()
+}
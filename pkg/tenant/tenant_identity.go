@@ -0,0 +1,26 @@
+package tenant
+
+import (
+	"context"
+)
+
+/* the tenant a request was authorized for, stored in the request context by the authorization interceptor */
+type TenantIdentity struct {
+	TenantId string
+	// the identity provider that authenticated the tenant, e.g. header, jwt or mtls/uri
+	Source string
+	// the decision that allowed the tenant
+	Decision TenantDecision
+}
+
+type tenantIdentityKey struct{}
+
+func NewContext(ctx context.Context, identity TenantIdentity) context.Context {
+	return context.WithValue(ctx, tenantIdentityKey{}, identity)
+}
+
+/* FromContext returns the authorized tenant, false if the request didn't go through the authorization interceptor */
+func FromContext(ctx context.Context) (TenantIdentity, bool) {
+	identity, ok := ctx.Value(tenantIdentityKey{}).(TenantIdentity)
+	return identity, ok
+}
@@ -0,0 +1,185 @@
+package tenant
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+/* where the tenant of a request comes from */
+const (
+	// the X-Tenant-Id header, trusted as is (default)
+	IdentityModeHeader = "header"
+	// a claim in a signed JWT bearer token
+	IdentityModeJWT = "jwt"
+	// the verified mTLS client certificate
+	IdentityModeMTLS = "mtls"
+)
+
+/* which part of the client certificate names the tenant */
+const (
+	// the last path segment of the first URI SAN, e.g. spiffe://example.org/tenant/<tenant id>
+	CertFieldURI = "uri"
+	// the first DNS SAN
+	CertFieldDNS = "dns"
+	// the subject common name
+	CertFieldCN = "cn"
+)
+
+const ReasonTenantMismatch = "TENANT_MISMATCH"
+
+/* authenticates the tenant of a request */
+type IdentityProvider interface {
+	// TenantId returns the tenant of the request, or a gRPC status error if it can't be established
+	TenantId(ctx context.Context) (string, error)
+	// String describes the provider for logs and the identity in the context
+	String() string
+}
+
+type IdentityOptions struct {
+	Mode string
+
+	// jwt: where to load the JSON Web Key Set from (a path or http(s):// URL), the claim holding the tenant id and
+	// the optional issuer and audience the token must have
+	JWKSSource  string
+	TenantClaim string
+	Issuer      string
+	Audience    string
+
+	// mtls: uri, dns or cn
+	CertField string
+}
+
+func NewIdentityProvider(opts IdentityOptions) (IdentityProvider, error) {
+	switch opts.Mode {
+	case "", IdentityModeHeader:
+		return &HeaderIdentityProvider{}, nil
+
+	case IdentityModeJWT:
+		if opts.JWKSSource == "" {
+			return nil, fmt.Errorf("jwt tenant identity needs a JWKS source")
+		}
+
+		source, err := NewSource(opts.JWKSSource)
+		if err != nil {
+			return nil, err
+		}
+
+		claim := opts.TenantClaim
+		if claim == "" {
+			claim = DefaultTenantClaim
+		}
+
+		return NewJWTIdentityProvider(NewJWKS(source), claim, opts.Issuer, opts.Audience), nil
+
+	case IdentityModeMTLS:
+		switch opts.CertField {
+		case "":
+			opts.CertField = CertFieldURI
+		case CertFieldURI, CertFieldDNS, CertFieldCN:
+		default:
+			return nil, fmt.Errorf("unknown client certificate field %q, must be one of %v, %v, %v",
+				opts.CertField, CertFieldURI, CertFieldDNS, CertFieldCN)
+		}
+
+		return &CertIdentityProvider{field: opts.CertField}, nil
+	}
+
+	return nil, fmt.Errorf("unknown tenant identity mode %q, must be one of %v, %v, %v",
+		opts.Mode, IdentityModeHeader, IdentityModeJWT, IdentityModeMTLS)
+}
+
+/* the legacy mode, whatever the client puts in X-Tenant-Id.  Only safe behind something that sets the header */
+type HeaderIdentityProvider struct{}
+
+func (p *HeaderIdentityProvider) TenantId(ctx context.Context) (string, error) {
+	return GetTenantId(ctx)
+}
+
+func (p *HeaderIdentityProvider) String() string {
+	return IdentityModeHeader
+}
+
+/* reads the tenant from the client certificate verified during the TLS handshake */
+type CertIdentityProvider struct {
+	field string
+}
+
+func (p *CertIdentityProvider) TenantId(ctx context.Context) (string, error) {
+	cert, err := VerifiedClientCertificate(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	tenantId := certTenantId(cert, p.field)
+	if tenantId == "" {
+		return "", status.Errorf(codes.Unauthenticated, "Client certificate has no %v to take the tenant from", p.field)
+	}
+
+	return checkTenantHeader(ctx, tenantId)
+}
+
+func (p *CertIdentityProvider) String() string {
+	return IdentityModeMTLS + "/" + p.field
+}
+
+/* VerifiedClientCertificate returns the client certificate of the connection if it was verified against a client CA */
+func VerifiedClientCertificate(ctx context.Context) (*x509.Certificate, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "No peer information")
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "Connection is not using TLS")
+	}
+
+	if len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "No verified client certificate")
+	}
+
+	return tlsInfo.State.VerifiedChains[0][0], nil
+}
+
+func certTenantId(cert *x509.Certificate, field string) string {
+	switch field {
+	case CertFieldURI:
+		if len(cert.URIs) > 0 {
+			segments := strings.Split(strings.Trim(cert.URIs[0].Path, "/"), "/")
+			return segments[len(segments)-1]
+		}
+	case CertFieldDNS:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	case CertFieldCN:
+		return cert.Subject.CommonName
+	}
+
+	return ""
+}
+
+/*
+an authenticated tenant wins over the header, but a client that also sends X-Tenant-Id for a different tenant is
+rejected rather than silently served as someone else
+*/
+func checkTenantHeader(ctx context.Context, tenantId string) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	for _, header := range md.Get("X-Tenant-Id") {
+		if header != tenantId {
+			st := status.Newf(codes.PermissionDenied, "X-Tenant-Id %v does not match the authenticated tenant %v", header, tenantId)
+			return "", withErrorInfo(st, ReasonTenantMismatch, map[string]string{MetadataTenantId: tenantId})
+		}
+	}
+
+	return tenantId, nil
+}
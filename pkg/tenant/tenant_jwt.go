@@ -0,0 +1,434 @@
+package tenant
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	DefaultTenantClaim = "tenant_id"
+
+	// tolerated clock difference when checking exp and nbf
+	jwtLeeway = time.Minute
+	// how often the key set is fetched again when a token names a key id it doesn't have
+	jwksMinRefreshInterval = 30 * time.Second
+	// how long a fetched key set is used before it is fetched again
+	jwksMaxAge = 5 * time.Minute
+)
+
+/* the curve each ECDSA algorithm is defined for */
+var jwsCurves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+/*
+a JSON Web Key Set, fetched from a source and refreshed when it gets old or an unknown key id shows up.  Lookups only
+take a read lock, the fetch runs outside the lock and concurrent lookups wait for the one in progress.
+*/
+type JWKS struct {
+	source Source
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	lastFetch   time.Time
+	lastAttempt time.Time
+	lastError   error
+	// closed when the fetch in progress finishes, nil if there isn't one
+	fetching chan struct{}
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func NewJWKS(source Source) *JWKS {
+	return &JWKS{source: source}
+}
+
+/* Key returns the public key with the key id, fetching the key set again if needed */
+func (j *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	j.mu.RLock()
+	key, ok := j.keys[kid]
+	stale := time.Since(j.lastFetch) > jwksMaxAge
+	j.mu.RUnlock()
+
+	if ok && !stale {
+		return key, nil
+	}
+
+	j.refresh(ctx)
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	key, ok = j.keys[kid]
+	if j.keys == nil && j.lastError != nil {
+		// never loaded, keep reporting why
+		return nil, j.lastError
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	return key, nil
+}
+
+/* fetch the key set unless that was tried recently, or wait for the fetch already in progress */
+func (j *JWKS) refresh(ctx context.Context) {
+	j.mu.Lock()
+
+	if done := j.fetching; done != nil {
+		j.mu.Unlock()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+		return
+	}
+
+	if time.Since(j.lastAttempt) <= jwksMinRefreshInterval {
+		j.mu.Unlock()
+		return
+	}
+
+	done := make(chan struct{})
+	j.fetching = done
+	j.lastAttempt = time.Now()
+	j.mu.Unlock()
+
+	keys, err := j.fetch(ctx)
+
+	j.mu.Lock()
+	if err == nil {
+		j.keys = keys
+		j.lastFetch = time.Now()
+	}
+	j.lastError = err
+	j.fetching = nil
+	j.mu.Unlock()
+
+	close(done)
+}
+
+func (j *JWKS) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	data, err := j.source.Fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load JWKS from %v: %v", j.source, err)
+	}
+
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse JWKS from %v: %v", j.source, err)
+	}
+
+	return keys, nil
+}
+
+/* ParseJWKS parses the RSA and EC signing keys of a JSON Web Key Set, keyed by key id */
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("keys[%d]: %v", i, err)
+		}
+
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64BigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid n: %v", err)
+		}
+
+		e, err := base64BigInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid e")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := base64BigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %v", err)
+		}
+
+		y, err := base64BigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %v", err)
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %v", k.Crv)
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func base64BigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+/* reads the tenant from a claim of a JWT bearer token signed by a key in the key set */
+type JWTIdentityProvider struct {
+	keys     *JWKS
+	claim    string
+	issuer   string
+	audience string
+}
+
+func NewJWTIdentityProvider(keys *JWKS, claim string, issuer string, audience string) *JWTIdentityProvider {
+	return &JWTIdentityProvider{
+		keys:     keys,
+		claim:    claim,
+		issuer:   issuer,
+		audience: audience,
+	}
+}
+
+func (p *JWTIdentityProvider) String() string {
+	return IdentityModeJWT
+}
+
+func (p *JWTIdentityProvider) TenantId(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	auth := md.Get("authorization")
+	if len(auth) == 0 || !strings.HasPrefix(auth[0], "Bearer ") {
+		return "", status.Error(codes.Unauthenticated, "Missing bearer token")
+	}
+
+	claims, err := p.verify(ctx, strings.TrimPrefix(auth[0], "Bearer "))
+	if err != nil {
+		return "", status.Errorf(codes.Unauthenticated, "Invalid bearer token: %v", err)
+	}
+
+	tenantId, _ := claims[p.claim].(string)
+	if tenantId == "" {
+		return "", status.Errorf(codes.Unauthenticated, "Bearer token has no %v claim", p.claim)
+	}
+
+	return checkTenantHeader(ctx, tenantId)
+}
+
+/* check the signature, expiry, issuer and audience of a compact JWS and return its claims */
+func (p *JWTIdentityProvider) verify(ctx context.Context, token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %v", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %v", err)
+	}
+
+	key, err := p.keys.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]interface{})
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %v", err)
+	}
+
+	now := time.Now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("no exp claim")
+	}
+
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return nil, fmt.Errorf("token expired")
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("token not valid yet")
+	}
+
+	if p.issuer != "" && claims["iss"] != p.issuer {
+		return nil, fmt.Errorf("unexpected issuer %v", claims["iss"])
+	}
+
+	if p.audience != "" && !hasAudience(claims["aud"], p.audience) {
+		return nil, fmt.Errorf("unexpected audience %v", claims["aud"])
+	}
+
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed []byte, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %v doesn't match the key type", alg)
+		}
+
+		if err := rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature); err != nil {
+			return fmt.Errorf("invalid signature")
+		}
+
+	case "PS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %v doesn't match the key type", alg)
+		}
+
+		if err := rsa.VerifyPSS(rsaKey, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}); err != nil {
+			return fmt.Errorf("invalid signature")
+		}
+
+	case "ES":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %v doesn't match the key type", alg)
+		}
+
+		// each algorithm is defined for one curve, a key on another curve must not be accepted with it
+		if curve, ok := jwsCurves[alg]; !ok || ecKey.Curve.Params().Name != curve.Params().Name {
+			return fmt.Errorf("algorithm %v doesn't match the key's curve %v", alg, ecKey.Curve.Params().Name)
+		}
+
+		// JWS ECDSA signatures are r || s, each the size of the curve
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid signature")
+		}
+
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+
+	return nil
+}
+
+/* aud is either a string or a list of strings */
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if a == audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
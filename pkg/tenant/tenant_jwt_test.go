@@ -0,0 +1,443 @@
+package tenant
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var jwtHashes = map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+
+func jwtPart(t *testing.T, v interface{}) string {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+/* sign a token with alg, which doesn't have to suit the key so mismatches can be tested */
+func signJWT(t *testing.T, alg string, kid string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+
+	signed := jwtPart(t, map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + jwtPart(t, claims)
+
+	var signature []byte
+	switch {
+	case alg == "none":
+
+	case strings.HasPrefix(alg, "HS"):
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+
+	default:
+		hash := jwtHashes[alg[2:]]
+		h := hash.New()
+		h.Write([]byte(signed))
+		digest := h.Sum(nil)
+
+		var err error
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			if strings.HasPrefix(alg, "PS") {
+				signature, err = rsa.SignPSS(rand.Reader, k, hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+			} else {
+				signature, err = rsa.SignPKCS1v15(rand.Reader, k, hash, digest)
+			}
+
+		case *ecdsa.PrivateKey:
+			var r, s *big.Int
+			r, s, err = ecdsa.Sign(rand.Reader, k, digest)
+			size := (k.Curve.Params().BitSize + 7) / 8
+			signature = append(padBytes(r, size), padBytes(s, size)...)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+/* replace the header of a signed token, keeping its claims and signature */
+func withHeader(t *testing.T, token string, alg string, kid string) string {
+	t.Helper()
+
+	parts := strings.Split(token, ".")
+	parts[0] = jwtPart(t, map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+
+	return strings.Join(parts, ".")
+}
+
+func padBytes(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	return append(make([]byte, size-len(b)), b...)
+}
+
+/* the JWKS JSON of the public keys */
+func jwksJSON(t *testing.T, keys map[string]crypto.PublicKey) []byte {
+	t.Helper()
+
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+
+	for kid, key := range keys {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, jsonWebKey{Kty: "RSA", Kid: kid, Use: "sig",
+				N: base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+				E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())})
+
+		case *ecdsa.PublicKey:
+			size := (k.Curve.Params().BitSize + 7) / 8
+			set.Keys = append(set.Keys, jsonWebKey{Kty: "EC", Kid: kid, Crv: k.Curve.Params().Name,
+				X: base64.RawURLEncoding.EncodeToString(padBytes(k.X, size)),
+				Y: base64.RawURLEncoding.EncodeToString(padBytes(k.Y, size))})
+		}
+	}
+
+	b, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+/* a source that counts fetches and can hold them until released */
+type countingSource struct {
+	mu      sync.Mutex
+	data    []byte
+	err     error
+	fetches int
+	release chan struct{}
+}
+
+func (s *countingSource) Fetch(ctx context.Context) ([]byte, error) {
+	s.mu.Lock()
+	s.fetches++
+	release := s.release
+	s.mu.Unlock()
+
+	if release != nil {
+		<-release
+	}
+
+	return s.data, s.err
+}
+
+func (s *countingSource) String() string {
+	return "counting"
+}
+
+type jwtTestKeys struct {
+	rsa  *rsa.PrivateKey
+	p256 *ecdsa.PrivateKey
+	p384 *ecdsa.PrivateKey
+}
+
+func newJWTTestKeys(t *testing.T) jwtTestKeys {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return jwtTestKeys{rsaKey, p256, p384}
+}
+
+func TestJWTVerify(t *testing.T) {
+	keys := newJWTTestKeys(t)
+	source := &countingSource{data: jwksJSON(t, map[string]crypto.PublicKey{
+		"rsa":  &keys.rsa.PublicKey,
+		"p256": &keys.p256.PublicKey,
+		"p384": &keys.p384.PublicKey,
+	})}
+	p := NewJWTIdentityProvider(NewJWKS(source), DefaultTenantClaim, "https://issuer.example.com", "helloworld")
+
+	rsaPublicDER, err := x509.MarshalPKIXPublicKey(&keys.rsa.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Unix()
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"tenant_id": "tenant-a",
+			"iss":       "https://issuer.example.com",
+			"aud":       "helloworld",
+			"exp":       now + 3600,
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	valid := signJWT(t, "ES256", "p256", keys.p256, claims(nil))
+	validParts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{"RS256", signJWT(t, "RS256", "rsa", keys.rsa, claims(nil)), ""},
+		{"RS512", signJWT(t, "RS512", "rsa", keys.rsa, claims(nil)), ""},
+		{"PS256", signJWT(t, "PS256", "rsa", keys.rsa, claims(nil)), ""},
+		{"ES256", valid, ""},
+		{"ES384", signJWT(t, "ES384", "p384", keys.p384, claims(nil)), ""},
+
+		// algorithm attacks
+		{"alg none", signJWT(t, "none", "rsa", nil, claims(nil)), `unsupported algorithm "none"`},
+		{"HS256 with the RSA public key as secret", signJWT(t, "HS256", "rsa", rsaPublicDER, claims(nil)), `unsupported algorithm "HS256"`},
+		{"RS256 header on an EC key", withHeader(t, valid, "RS256", "p256"), "algorithm RS256 doesn't match the key type"},
+		{"ES256 header on an RSA key", withHeader(t, signJWT(t, "RS256", "rsa", keys.rsa, claims(nil)), "ES256", "rsa"),
+			"algorithm ES256 doesn't match the key type"},
+		{"ES384 signed with a P-256 key", signJWT(t, "ES384", "p256", keys.p256, claims(nil)), "algorithm ES384 doesn't match the key's curve P-256"},
+		{"ES256 signed with a P-384 key", signJWT(t, "ES256", "p384", keys.p384, claims(nil)), "algorithm ES256 doesn't match the key's curve P-384"},
+		{"unknown key id", signJWT(t, "ES256", "other", keys.p256, claims(nil)), `unknown key id "other"`},
+
+		// encoding
+		{"padded signature", valid + "==", "malformed signature"},
+		{"padded header", validParts[0] + "=." + validParts[1] + "." + validParts[2], "malformed header"},
+		{"two parts", validParts[0] + "." + validParts[1], "malformed token"},
+		{"tampered claims", validParts[0] + "." + jwtPart(t, claims(map[string]interface{}{"tenant_id": "tenant-b"})) + "." + validParts[2], "invalid signature"},
+		{"truncated signature", validParts[0] + "." + validParts[1] + "." + validParts[2][:20], "invalid signature"},
+
+		// claims
+		{"no exp", signJWT(t, "ES256", "p256", keys.p256, claims(map[string]interface{}{"exp": nil})), "no exp claim"},
+		{"expired", signJWT(t, "ES256", "p256", keys.p256, claims(map[string]interface{}{"exp": now - 120})), "token expired"},
+		{"expired within leeway", signJWT(t, "ES256", "p256", keys.p256, claims(map[string]interface{}{"exp": now - 30})), ""},
+		{"not valid yet", signJWT(t, "ES256", "p256", keys.p256, claims(map[string]interface{}{"nbf": now + 120})), "token not valid yet"},
+		{"nbf within leeway", signJWT(t, "ES256", "p256", keys.p256, claims(map[string]interface{}{"nbf": now + 30})), ""},
+		{"wrong issuer", signJWT(t, "ES256", "p256", keys.p256, claims(map[string]interface{}{"iss": "https://evil.example.com"})), "unexpected issuer"},
+		{"no issuer", signJWT(t, "ES256", "p256", keys.p256, claims(map[string]interface{}{"iss": nil})), "unexpected issuer"},
+		{"wrong audience", signJWT(t, "ES256", "p256", keys.p256, claims(map[string]interface{}{"aud": "other"})), "unexpected audience"},
+		{"audience list", signJWT(t, "ES256", "p256", keys.p256, claims(map[string]interface{}{"aud": []string{"other", "helloworld"}})), ""},
+		{"audience list without us", signJWT(t, "ES256", "p256", keys.p256, claims(map[string]interface{}{"aud": []string{"other"}})), "unexpected audience"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := p.verify(context.Background(), test.token)
+
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("expected the token to verify, got %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestVerifyJWTSignatureAlgorithmKeyMismatch(t *testing.T) {
+	keys := newJWTTestKeys(t)
+	signed := []byte("header.claims")
+
+	tests := []struct {
+		alg string
+		key crypto.PublicKey
+	}{
+		{"RS256", &keys.p256.PublicKey},
+		{"PS256", &keys.p256.PublicKey},
+		{"ES256", &keys.rsa.PublicKey},
+		{"ES256", &keys.p384.PublicKey},
+		{"ES384", &keys.p256.PublicKey},
+		{"ES512", &keys.p384.PublicKey},
+		{"HS256", &keys.rsa.PublicKey},
+		{"none", &keys.rsa.PublicKey},
+		{"EdDSA", &keys.rsa.PublicKey},
+		{"RS128", &keys.rsa.PublicKey},
+	}
+
+	for _, test := range tests {
+		if err := verifyJWTSignature(test.alg, test.key, signed, make([]byte, 256)); err == nil || err.Error() == "invalid signature" {
+			t.Errorf("%v with %T: expected the algorithm to be rejected before checking the signature, got %v", test.alg, test.key, err)
+		}
+	}
+}
+
+func TestJWTTenantId(t *testing.T) {
+	keys := newJWTTestKeys(t)
+	source := &countingSource{data: jwksJSON(t, map[string]crypto.PublicKey{"p256": &keys.p256.PublicKey})}
+	p := NewJWTIdentityProvider(NewJWKS(source), DefaultTenantClaim, "", "")
+
+	exp := time.Now().Add(time.Hour).Unix()
+	token := signJWT(t, "ES256", "p256", keys.p256, map[string]interface{}{"tenant_id": "tenant-a", "exp": exp})
+	noClaim := signJWT(t, "ES256", "p256", keys.p256, map[string]interface{}{"sub": "tenant-a", "exp": exp})
+
+	tests := []struct {
+		name     string
+		md       metadata.MD
+		tenantId string
+		code     codes.Code
+	}{
+		{"valid", metadata.Pairs("authorization", "Bearer "+token), "tenant-a", codes.OK},
+		{"matching header", metadata.Pairs("authorization", "Bearer "+token, "x-tenant-id", "tenant-a"), "tenant-a", codes.OK},
+		{"mismatched header", metadata.Pairs("authorization", "Bearer "+token, "x-tenant-id", "tenant-b"), "", codes.PermissionDenied},
+		{"no token", metadata.MD{}, "", codes.Unauthenticated},
+		{"not bearer", metadata.Pairs("authorization", "Basic "+token), "", codes.Unauthenticated},
+		{"no tenant claim", metadata.Pairs("authorization", "Bearer "+noClaim), "", codes.Unauthenticated},
+	}
+
+	for _, test := range tests {
+		tenantId, err := p.TenantId(metadata.NewIncomingContext(context.Background(), test.md))
+		if status.Code(err) != test.code || tenantId != test.tenantId {
+			t.Errorf("%v: expected %q, %v, got %q, %v", test.name, test.tenantId, test.code, tenantId, err)
+		}
+	}
+}
+
+func TestJWKSRefresh(t *testing.T) {
+	keys := newJWTTestKeys(t)
+	source := &countingSource{data: jwksJSON(t, map[string]crypto.PublicKey{"p256": &keys.p256.PublicKey})}
+	j := NewJWKS(source)
+
+	if _, err := j.Key(context.Background(), "p256"); err != nil {
+		t.Fatal(err)
+	}
+
+	// an unknown key id right after a fetch doesn't fetch again
+	if _, err := j.Key(context.Background(), "p384"); err == nil {
+		t.Error("expected an unknown key id error")
+	}
+	if source.fetches != 1 {
+		t.Errorf("expected 1 fetch, got %d", source.fetches)
+	}
+
+	// once the refresh interval has passed it does, and finds the new key
+	source.data = jwksJSON(t, map[string]crypto.PublicKey{"p256": &keys.p256.PublicKey, "p384": &keys.p384.PublicKey})
+	j.lastAttempt = j.lastAttempt.Add(-jwksMinRefreshInterval - time.Second)
+	if _, err := j.Key(context.Background(), "p384"); err != nil {
+		t.Errorf("expected the refreshed key set to have the new key, got %v", err)
+	}
+	if source.fetches != 2 {
+		t.Errorf("expected 2 fetches, got %d", source.fetches)
+	}
+
+	// a failed refresh keeps the keys already loaded
+	source.err = fmt.Errorf("unavailable")
+	j.lastFetch = j.lastFetch.Add(-jwksMaxAge - time.Second)
+	j.lastAttempt = j.lastAttempt.Add(-jwksMinRefreshInterval - time.Second)
+	if _, err := j.Key(context.Background(), "p256"); err != nil {
+		t.Errorf("expected the stale key to be used when the refresh fails, got %v", err)
+	}
+}
+
+func TestJWKSNeverLoaded(t *testing.T) {
+	j := NewJWKS(&countingSource{err: fmt.Errorf("unavailable")})
+
+	for i := 0; i < 2; i++ {
+		if _, err := j.Key(context.Background(), "p256"); err == nil || !strings.Contains(err.Error(), "unavailable") {
+			t.Errorf("lookup %d: expected the fetch error, got %v", i, err)
+		}
+	}
+}
+
+func TestJWKSSingleFlight(t *testing.T) {
+	keys := newJWTTestKeys(t)
+	source := &countingSource{
+		data:    jwksJSON(t, map[string]crypto.PublicKey{"p256": &keys.p256.PublicKey}),
+		release: make(chan struct{}),
+	}
+	j := NewJWKS(source)
+
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := j.Key(context.Background(), "p256")
+			errs <- err
+		}()
+	}
+
+	// the lookups queue up behind the first fetch, and the lock isn't held while it runs
+	for {
+		j.mu.RLock()
+		fetching := j.fetching != nil
+		j.mu.RUnlock()
+		if fetching {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(source.release)
+
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("expected every lookup to get the key, got %v", err)
+		}
+	}
+
+	if source.fetches != 1 {
+		t.Errorf("expected concurrent lookups to share 1 fetch, got %d", source.fetches)
+	}
+}
+
+func TestJWKSWaitCancelled(t *testing.T) {
+	source := &countingSource{release: make(chan struct{})}
+	defer close(source.release)
+	j := NewJWKS(source)
+
+	go j.Key(context.Background(), "p256")
+	for {
+		j.mu.RLock()
+		fetching := j.fetching != nil
+		j.mu.RUnlock()
+		if fetching {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// a lookup waiting for someone else's fetch gives up with its own context
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := j.Key(ctx, "p256"); err == nil {
+		t.Error("expected an error when the lookup's context is done before the key set is loaded")
+	}
+}
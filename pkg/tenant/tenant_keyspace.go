@@ -0,0 +1,353 @@
+package tenant
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+/*
+  helpers for reasoning about which part of the UUID tenant keyspace a config accepts.  Tenant ids are treated as
+  128 bit integers, and range, prefix and exact matchers that only talk about UUIDs are converted to intervals.
+*/
+
+const uuidHexDigits = 32
+
+var (
+	uuidKeyspaceMin = big.NewInt(0)
+	uuidKeyspaceMax = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+)
+
+/* an inclusive interval of the UUID keyspace */
+type KeyInterval struct {
+	Start *big.Int
+	End   *big.Int
+}
+
+func UUIDKeyspace() KeyInterval {
+	return KeyInterval{Start: uuidKeyspaceMin, End: uuidKeyspaceMax}
+}
+
+func (k KeyInterval) String() string {
+	return fmt.Sprintf("%v - %v", FormatUUIDKey(k.Start), FormatUUIDKey(k.End))
+}
+
+func ParseUUIDKey(value string) (*big.Int, error) {
+	u, err := uuid.Parse(strings.TrimSpace(value))
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(u[:]), nil
+}
+
+func FormatUUIDKey(n *big.Int) string {
+	// left pad into the 16 bytes, keys are always within the keyspace
+	var u uuid.UUID
+	b := n.Bytes()
+	copy(u[len(u)-len(b):], b)
+
+	return u.String()
+}
+
+/*
+UUIDPrefixes returns the smallest set of lower case UUID string prefixes (e.g. "3f", "40000000-1") that together
+match exactly the UUIDs in the interval.  The whole keyspace is the empty prefix.
+*/
+func (k KeyInterval) UUIDPrefixes() []string {
+	prefixes := make([]string, 0)
+	coverWithPrefixes("", k, &prefixes)
+
+	for i, prefix := range prefixes {
+		prefixes[i] = formatUUIDPrefix(prefix)
+	}
+
+	return prefixes
+}
+
+func coverWithPrefixes(prefix string, k KeyInterval, prefixes *[]string) {
+	interval, _ := hexPrefixInterval(prefix)
+
+	if interval.End.Cmp(k.Start) < 0 || interval.Start.Cmp(k.End) > 0 {
+		// no overlap
+		return
+	}
+
+	if interval.Start.Cmp(k.Start) >= 0 && interval.End.Cmp(k.End) <= 0 {
+		// every UUID with this prefix is in the interval
+		*prefixes = append(*prefixes, prefix)
+		return
+	}
+
+	for _, digit := range "0123456789abcdef" {
+		coverWithPrefixes(prefix+string(digit), k, prefixes)
+	}
+}
+
+/* insert the dashes of the UUID string layout into a hex prefix */
+func formatUUIDPrefix(hexPrefix string) string {
+	var b strings.Builder
+	for i, c := range hexPrefix {
+		if i == 8 || i == 12 || i == 16 || i == 20 {
+			b.WriteRune('-')
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+/* the interval covered by a hex prefix, e.g. "3f" covers 3f000000-... through 3fffffff-... */
+func hexPrefixInterval(prefix string) (KeyInterval, bool) {
+	if len(prefix) > uuidHexDigits {
+		return KeyInterval{}, false
+	}
+
+	start, ok := new(big.Int).SetString(prefix+strings.Repeat("0", uuidHexDigits-len(prefix)), 16)
+	if !ok {
+		return KeyInterval{}, false
+	}
+
+	end, _ := new(big.Int).SetString(prefix+strings.Repeat("f", uuidHexDigits-len(prefix)), 16)
+
+	return KeyInterval{Start: start, End: end}, true
+}
+
+/*
+a string prefix matcher is only meaningful on the keyspace if it is a prefix of a lower case, dashed UUID,
+e.g. "3fffffff-f".  Returns false otherwise.
+*/
+func uuidStringPrefixInterval(prefix string) (KeyInterval, bool) {
+	const layout = "xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx"
+
+	if len(prefix) > len(layout) {
+		return KeyInterval{}, false
+	}
+
+	for i, c := range prefix {
+		if layout[i] == '-' {
+			if c != '-' {
+				return KeyInterval{}, false
+			}
+			continue
+		}
+
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return KeyInterval{}, false
+		}
+	}
+
+	return hexPrefixInterval(strings.ReplaceAll(prefix, "-", ""))
+}
+
+func (r *TenantRangeMatch) uuidInterval() (KeyInterval, bool) {
+	switch r.rangeType() {
+	case RangeTypeUUID:
+		start, err := ParseUUIDKey(r.Start)
+		if err != nil {
+			return KeyInterval{}, false
+		}
+
+		end, err := ParseUUIDKey(r.End)
+		if err != nil {
+			return KeyInterval{}, false
+		}
+
+		return KeyInterval{Start: start, End: end}, true
+
+	case RangeTypeHexPrefix:
+		startBound, err := normalizeRangeKey(RangeTypeHexPrefix, r.Start)
+		if err != nil {
+			return KeyInterval{}, false
+		}
+
+		endBound, err := normalizeRangeKey(RangeTypeHexPrefix, r.End)
+		if err != nil {
+			return KeyInterval{}, false
+		}
+
+		start, ok := hexPrefixInterval(startBound.key)
+		if !ok {
+			return KeyInterval{}, false
+		}
+
+		end, ok := hexPrefixInterval(endBound.key)
+		if !ok {
+			return KeyInterval{}, false
+		}
+
+		return KeyInterval{Start: start.Start, End: end.End}, true
+	}
+
+	return KeyInterval{}, false
+}
+
+/* UUIDIntervals converts the matcher to keyspace intervals, returns false if it can't be expressed that way */
+func (tm *TenantMatch) UUIDIntervals() ([]KeyInterval, bool) {
+	intervals := make([]KeyInterval, 0)
+
+	switch {
+	case tm.RangeMatch != nil:
+		for i := range *tm.RangeMatch {
+			interval, ok := (*tm.RangeMatch)[i].uuidInterval()
+			if !ok {
+				return nil, false
+			}
+			intervals = append(intervals, interval)
+		}
+
+	case tm.PrefixMatch != nil:
+		for _, prefix := range *tm.PrefixMatch {
+			interval, ok := uuidStringPrefixInterval(prefix)
+			if !ok {
+				return nil, false
+			}
+			intervals = append(intervals, interval)
+		}
+
+	case tm.ExactMatch != nil:
+		for _, value := range *tm.ExactMatch {
+			if value == "*" {
+				return []KeyInterval{UUIDKeyspace()}, true
+			}
+
+			key, err := ParseUUIDKey(value)
+			if err != nil || FormatUUIDKey(key) != value {
+				// exact matches are case sensitive, only the canonical form is on the keyspace
+				return nil, false
+			}
+			intervals = append(intervals, KeyInterval{Start: key, End: key})
+		}
+
+	case tm.AnyOf != nil:
+		for i := range *tm.AnyOf {
+			anyIntervals, ok := (*tm.AnyOf)[i].UUIDIntervals()
+			if !ok {
+				return nil, false
+			}
+			intervals = append(intervals, anyIntervals...)
+		}
+
+	default:
+		return nil, false
+	}
+
+	return MergeIntervals(intervals), true
+}
+
+/* MergeIntervals sorts the intervals and merges any that overlap or touch */
+func MergeIntervals(intervals []KeyInterval) []KeyInterval {
+	sorted := make([]KeyInterval, len(intervals))
+	copy(sorted, intervals)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Start.Cmp(sorted[j].Start) < 0
+	})
+
+	merged := make([]KeyInterval, 0, len(sorted))
+	for _, interval := range sorted {
+		if len(merged) > 0 {
+			last := &merged[len(merged)-1]
+			next := new(big.Int).Add(last.End, big.NewInt(1))
+			if interval.Start.Cmp(next) <= 0 {
+				if interval.End.Cmp(last.End) > 0 {
+					last.End = interval.End
+				}
+				continue
+			}
+		}
+
+		merged = append(merged, interval)
+	}
+
+	return merged
+}
+
+/* SubtractIntervals returns the parts of a that are not covered by b */
+func SubtractIntervals(a []KeyInterval, b []KeyInterval) []KeyInterval {
+	result := make([]KeyInterval, 0, len(a))
+	b = MergeIntervals(b)
+
+	for _, interval := range MergeIntervals(a) {
+		start := interval.Start
+		for _, cut := range b {
+			if cut.End.Cmp(start) < 0 || cut.Start.Cmp(interval.End) > 0 {
+				continue
+			}
+
+			if cut.Start.Cmp(start) > 0 {
+				result = append(result, KeyInterval{Start: start, End: new(big.Int).Sub(cut.Start, big.NewInt(1))})
+			}
+
+			start = new(big.Int).Add(cut.End, big.NewInt(1))
+			if start.Cmp(interval.End) > 0 {
+				break
+			}
+		}
+
+		if start.Cmp(interval.End) <= 0 {
+			result = append(result, KeyInterval{Start: start, End: interval.End})
+		}
+	}
+
+	return result
+}
+
+/* the rules in the order the configured precedence evaluates them */
+func (p *TenantPolicy) evaluationOrder() []candidateRule {
+	switch p.Precedence {
+	case PrecedenceAllowOverrides:
+		return append(p.allowRules(), p.denyRules()...)
+	case PrecedenceFirstMatch:
+		return p.orderedRules()
+	}
+
+	return append(p.denyRules(), p.allowRules()...)
+}
+
+/* a rule as seen by tools that translate tenant configs into other formats, e.g. load balancer routes */
+type TenantRuleRef struct {
+	Name   string
+	Action string
+	Match  TenantMatch
+}
+
+/* EvaluationOrder returns the rules in the order the configured precedence evaluates them */
+func (p *TenantPolicy) EvaluationOrder() []TenantRuleRef {
+	rules := p.evaluationOrder()
+	refs := make([]TenantRuleRef, 0, len(rules))
+	for _, rule := range rules {
+		refs = append(refs, TenantRuleRef{Name: rule.name(), Action: rule.action, Match: rule.match})
+	}
+
+	return refs
+}
+
+/*
+AcceptedUUIDKeyspace returns the parts of the UUID keyspace the config accepts.  Rules that can't be expressed as
+keyspace intervals (regex, glob, non-UUID values, ...) are skipped and returned in ignored, so the result is an
+approximation when ignored is not empty.
+*/
+func (p *TenantPolicy) AcceptedUUIDKeyspace() (accepted []KeyInterval, ignored []string) {
+	decided := make([]KeyInterval, 0)
+	accepted = make([]KeyInterval, 0)
+	ignored = make([]string, 0)
+
+	for _, rule := range p.evaluationOrder() {
+		intervals, ok := rule.match.UUIDIntervals()
+		if !ok {
+			ignored = append(ignored, fmt.Sprintf("%v[%d]", rule.ruleSet, rule.index))
+			continue
+		}
+
+		if rule.action == ActionAllow {
+			accepted = append(accepted, SubtractIntervals(intervals, decided)...)
+		}
+
+		decided = MergeIntervals(append(decided, intervals...))
+	}
+
+	return MergeIntervals(accepted), ignored
+}
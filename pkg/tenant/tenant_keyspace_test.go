@@ -0,0 +1,214 @@
+package tenant
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func uuidInterval(t *testing.T, start string, end string) KeyInterval {
+	t.Helper()
+
+	s, err := ParseUUIDKey(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := ParseUUIDKey(end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return KeyInterval{Start: s, End: e}
+}
+
+func formatIntervals(intervals []KeyInterval) []string {
+	result := make([]string, 0, len(intervals))
+	for _, interval := range intervals {
+		result = append(result, interval.String())
+	}
+
+	return result
+}
+
+func TestFormatUUIDKey(t *testing.T) {
+	for _, value := range []string{
+		"00000000-0000-0000-0000-000000000000",
+		"00000000-0000-0000-0000-000000000001",
+		"00000000-0000-0001-0000-000000000000",
+		"12345678-9abc-def0-1234-56789abcdef0",
+		"ffffffff-ffff-ffff-ffff-ffffffffffff",
+	} {
+		key, err := ParseUUIDKey(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := FormatUUIDKey(key); got != value {
+			t.Errorf("FormatUUIDKey(ParseUUIDKey(%q)) = %q", value, got)
+		}
+	}
+}
+
+func TestUUIDPrefixes(t *testing.T) {
+	tests := []struct {
+		name  string
+		start string
+		end   string
+		want  []string
+	}{
+		{"whole keyspace", "00000000-0000-0000-0000-000000000000", "ffffffff-ffff-ffff-ffff-ffffffffffff", []string{""}},
+		{"first quarter", "00000000-0000-0000-0000-000000000000", "3fffffff-ffff-ffff-ffff-ffffffffffff", []string{"0", "1", "2", "3"}},
+		{"a single digit", "a0000000-0000-0000-0000-000000000000", "afffffff-ffff-ffff-ffff-ffffffffffff", []string{"a"}},
+		{"unaligned start", "3f000000-0000-0000-0000-000000000000", "4fffffff-ffff-ffff-ffff-ffffffffffff", []string{"3f", "4"}},
+		{"across the first dash", "00000000-1000-0000-0000-000000000000", "00000000-2fff-ffff-ffff-ffffffffffff", []string{"00000000-1", "00000000-2"}},
+		{"a single uuid", "12345678-9abc-def0-1234-56789abcdef0", "12345678-9abc-def0-1234-56789abcdef0", []string{"12345678-9abc-def0-1234-56789abcdef0"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := uuidInterval(t, test.start, test.end).UUIDPrefixes()
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("UUIDPrefixes() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMergeAndSubtractIntervals(t *testing.T) {
+	n := func(v int64) *big.Int { return big.NewInt(v) }
+	iv := func(start int64, end int64) KeyInterval { return KeyInterval{Start: n(start), End: n(end)} }
+
+	tests := []struct {
+		name string
+		got  []KeyInterval
+		want []KeyInterval
+	}{
+		{"merge overlapping", MergeIntervals([]KeyInterval{iv(5, 10), iv(0, 6)}), []KeyInterval{iv(0, 10)}},
+		{"merge touching", MergeIntervals([]KeyInterval{iv(0, 4), iv(5, 10)}), []KeyInterval{iv(0, 10)}},
+		{"merge contained", MergeIntervals([]KeyInterval{iv(0, 10), iv(2, 3)}), []KeyInterval{iv(0, 10)}},
+		{"keep gaps", MergeIntervals([]KeyInterval{iv(7, 10), iv(0, 5)}), []KeyInterval{iv(0, 5), iv(7, 10)}},
+		{"merge nothing", MergeIntervals(nil), []KeyInterval{}},
+		{"subtract middle", SubtractIntervals([]KeyInterval{iv(0, 10)}, []KeyInterval{iv(3, 5)}), []KeyInterval{iv(0, 2), iv(6, 10)}},
+		{"subtract start", SubtractIntervals([]KeyInterval{iv(0, 10)}, []KeyInterval{iv(0, 5)}), []KeyInterval{iv(6, 10)}},
+		{"subtract end", SubtractIntervals([]KeyInterval{iv(0, 10)}, []KeyInterval{iv(5, 20)}), []KeyInterval{iv(0, 4)}},
+		{"subtract everything", SubtractIntervals([]KeyInterval{iv(3, 5)}, []KeyInterval{iv(0, 10)}), []KeyInterval{}},
+		{"subtract several", SubtractIntervals([]KeyInterval{iv(0, 10)}, []KeyInterval{iv(8, 8), iv(2, 3)}), []KeyInterval{iv(0, 1), iv(4, 7), iv(9, 10)}},
+		{"subtract disjoint", SubtractIntervals([]KeyInterval{iv(0, 3)}, []KeyInterval{iv(5, 8)}), []KeyInterval{iv(0, 3)}},
+		{"subtract nothing", SubtractIntervals([]KeyInterval{iv(0, 3)}, nil), []KeyInterval{iv(0, 3)}},
+	}
+
+	for _, test := range tests {
+		if !reflect.DeepEqual(formatIntervals(test.got), formatIntervals(test.want)) {
+			t.Errorf("%v: got %v, want %v", test.name, formatIntervals(test.got), formatIntervals(test.want))
+		}
+	}
+}
+
+func TestUUIDIntervals(t *testing.T) {
+	tests := []struct {
+		match string
+		want  []string
+		ok    bool
+	}{
+		{`range: [{type: uuid, start: 00000000-0000-0000-0000-000000000000, end: 3fffffff-ffff-ffff-ffff-ffffffffffff}]`,
+			[]string{"00000000-0000-0000-0000-000000000000 - 3fffffff-ffff-ffff-ffff-ffffffffffff"}, true},
+		{`range: [{type: hex-prefix, start: "4", end: "7f"}]`,
+			[]string{"40000000-0000-0000-0000-000000000000 - 7fffffff-ffff-ffff-ffff-ffffffffffff"}, true},
+		{`prefix: ["3f", "40000000-"]`,
+			[]string{"3f000000-0000-0000-0000-000000000000 - 40000000-ffff-ffff-ffff-ffffffffffff"}, true},
+		{`exactMatch: ["*"]`,
+			[]string{"00000000-0000-0000-0000-000000000000 - ffffffff-ffff-ffff-ffff-ffffffffffff"}, true},
+		{`any_of: [{prefix: ["0"]}, {prefix: ["1"]}]`,
+			[]string{"00000000-0000-0000-0000-000000000000 - 1fffffff-ffff-ffff-ffff-ffffffffffff"}, true},
+		// exact matches are case sensitive, so only the canonical form is on the keyspace
+		{`exactMatch: ["3FFFFFFF-FFFF-FFFF-FFFF-FFFFFFFFFFFF"]`, nil, false},
+		{`prefix: ["acme-"]`, nil, false},
+		{`range: [{start: "a", end: "b"}]`, nil, false},
+		{`regex: ["[0-9a-f-]{36}"]`, nil, false},
+		{`not: {prefix: ["0"]}`, nil, false},
+	}
+
+	for _, test := range tests {
+		tm, err := compileMatch(t, test.match)
+		if err != nil {
+			t.Fatalf("%v: %v", test.match, err)
+		}
+
+		got, ok := tm.UUIDIntervals()
+		if ok != test.ok || (ok && !reflect.DeepEqual(formatIntervals(got), test.want)) {
+			t.Errorf("%v: UUIDIntervals() = %v, %v, want %v, %v", test.match, formatIntervals(got), ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestAcceptedUUIDKeyspace(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    []string
+		ignored []string
+	}{
+		{
+			name: "deny-overrides",
+			config: `
+allowed_tenants:
+- range: [{type: hex-prefix, start: "0", end: "3"}]
+denied_tenants:
+- range: [{type: hex-prefix, start: "1", end: "1"}]
+- regex: ["x.*"]
+`,
+			want: []string{
+				"00000000-0000-0000-0000-000000000000 - 0fffffff-ffff-ffff-ffff-ffffffffffff",
+				"20000000-0000-0000-0000-000000000000 - 3fffffff-ffff-ffff-ffff-ffffffffffff",
+			},
+			ignored: []string{"denied_tenants[1]"},
+		},
+		{
+			name: "allow-overrides",
+			config: `
+precedence: allow-overrides
+allowed_tenants:
+- range: [{type: hex-prefix, start: "0", end: "3"}]
+denied_tenants:
+- range: [{type: hex-prefix, start: "1", end: "1"}]
+`,
+			want: []string{"00000000-0000-0000-0000-000000000000 - 3fffffff-ffff-ffff-ffff-ffffffffffff"},
+		},
+		{
+			name: "first-match",
+			config: `
+precedence: first-match
+rules:
+- action: allow
+  prefix: ["1"]
+- action: deny
+  range: [{type: hex-prefix, start: "0", end: "2"}]
+- action: allow
+  exactMatch: ["*"]
+`,
+			want: []string{
+				"10000000-0000-0000-0000-000000000000 - 1fffffff-ffff-ffff-ffff-ffffffffffff",
+				"30000000-0000-0000-0000-000000000000 - ffffffff-ffff-ffff-ffff-ffffffffffff",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := ParseTenantConfig([]byte(test.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			accepted, ignored := config.AcceptedUUIDKeyspace()
+			if !reflect.DeepEqual(formatIntervals(accepted), test.want) {
+				t.Errorf("accepted = %v, want %v", formatIntervals(accepted), test.want)
+			}
+
+			if len(ignored) != len(test.ignored) || (len(ignored) > 0 && !reflect.DeepEqual(ignored, test.ignored)) {
+				t.Errorf("ignored = %v, want %v", ignored, test.ignored)
+			}
+		})
+	}
+}
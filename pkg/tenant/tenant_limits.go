@@ -0,0 +1,58 @@
+package tenant
+
+import (
+	"fmt"
+	"math"
+)
+
+/*
+limits applied to each tenant the matcher matches.  Every tenant gets its own allowance, the matcher only picks
+which limits apply.  Zero means unlimited.
+*/
+type TenantLimit struct {
+	TenantMatch `yaml:",inline"`
+
+	// sustained unary calls plus stream messages per second, and how many can be used at once
+	RequestsPerSecond float64 `yaml:"requests_per_second,omitempty" json:"requests_per_second,omitempty"`
+	Burst             int     `yaml:"burst,omitempty" json:"burst,omitempty"`
+
+	// how many streams and unary calls the tenant may have open at once on each pod
+	MaxConcurrentStreams int `yaml:"max_concurrent_streams,omitempty" json:"max_concurrent_streams,omitempty"`
+	MaxInFlightUnary     int `yaml:"max_in_flight_unary,omitempty" json:"max_in_flight_unary,omitempty"`
+}
+
+func (l *TenantLimit) compile() error {
+	if l.RequestsPerSecond < 0 || math.IsNaN(l.RequestsPerSecond) || math.IsInf(l.RequestsPerSecond, 0) {
+		return fmt.Errorf("invalid requests_per_second %v", l.RequestsPerSecond)
+	}
+
+	if l.Burst < 0 {
+		return fmt.Errorf("invalid burst %v", l.Burst)
+	}
+
+	if l.MaxConcurrentStreams < 0 {
+		return fmt.Errorf("invalid max_concurrent_streams %v", l.MaxConcurrentStreams)
+	}
+
+	if l.MaxInFlightUnary < 0 {
+		return fmt.Errorf("invalid max_in_flight_unary %v", l.MaxInFlightUnary)
+	}
+
+	if l.RequestsPerSecond > 0 && l.Burst == 0 {
+		// allow at least one second's worth of requests at once
+		l.Burst = int(math.Ceil(l.RequestsPerSecond))
+	}
+
+	return l.TenantMatch.compile()
+}
+
+/* Limit returns the first tenant_limits entry matching the tenant, nil if the tenant is unlimited */
+func (t *TenantConfig) Limit(tenantId string) *TenantLimit {
+	for i := range t.Limits {
+		if tenantMatches(tenantId, t.Limits[i].TenantMatch) {
+			return &t.Limits[i]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,357 @@
+package tenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+/* a problem in a config that loads fine but probably doesn't do what was intended */
+type LintWarning struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (w LintWarning) String() string {
+	return fmt.Sprintf("%v: %v", w.Rule, w.Message)
+}
+
+func (r candidateRule) name() string {
+	return fmt.Sprintf("%v[%d]", r.ruleSet, r.index)
+}
+
+/* Lint looks for deprecated and empty matchers, overlapping ranges and rules that can never decide a tenant */
+func (t *TenantConfig) Lint() []LintWarning {
+	return append(t.Deprecations(), t.eachPolicy((*TenantPolicy).lint)...)
+}
+
+/* Deprecations lists rules that still load but should be rewritten, the server logs them */
+func (t *TenantConfig) Deprecations() []LintWarning {
+	return t.eachPolicy((*TenantPolicy).deprecations)
+}
+
+/* collect the warnings of the global policy and every method override */
+func (t *TenantConfig) eachPolicy(check func(p *TenantPolicy) []LintWarning) []LintWarning {
+	warnings := check(&t.TenantPolicy)
+
+	for _, method := range t.methodNames() {
+		for _, w := range check(t.Methods[method]) {
+			w.Rule = fmt.Sprintf("methods[%v].%v", method, w.Rule)
+			warnings = append(warnings, w)
+		}
+	}
+
+	return warnings
+}
+
+func (p *TenantPolicy) deprecations() []LintWarning {
+	warnings := make([]LintWarning, 0)
+
+	for _, rule := range p.orderedRules() {
+		if names := rule.match.legacyMatchers; len(names) > 0 {
+			warnings = append(warnings, LintWarning{rule.name(), fmt.Sprintf(
+				"deprecated: sets several matchers (%v) but only %v is used, remove the others or nest them in any_of",
+				strings.Join(names, ", "), names[0])})
+		}
+	}
+
+	return warnings
+}
+
+func (p *TenantPolicy) lint() []LintWarning {
+	warnings := make([]LintWarning, 0)
+
+	for _, rule := range p.orderedRules() {
+		if path := emptyMatcher(rule.match); path != "" {
+			warnings = append(warnings, LintWarning{rule.name(), fmt.Sprintf("%v is empty and never matches", path)})
+		}
+	}
+
+	warnings = append(warnings, overlappingRanges(p.allowRules())...)
+	warnings = append(warnings, overlappingRanges(p.denyRules())...)
+
+	// a rule is unreachable if a rule evaluated before it matches every tenant it could match
+	order := p.evaluationOrder()
+	for j, later := range order {
+		if emptyMatcher(later.match) != "" {
+			// already reported as empty
+			continue
+		}
+
+		for _, earlier := range order[:j] {
+			if earlier.action == later.action && p.Precedence != PrecedenceFirstMatch {
+				// with deny-overrides/allow-overrides, rules with the same action are all equivalent
+				if matcherEqual(earlier.match, later.match) {
+					warnings = append(warnings, LintWarning{later.name(), fmt.Sprintf("duplicates %v", earlier.name())})
+					break
+				}
+				continue
+			}
+
+			if matcherCovers(earlier.match, later.match) {
+				warnings = append(warnings, LintWarning{later.name(), fmt.Sprintf("unreachable, every tenant it matches is already %v by %v (%v)",
+					actionPastTense(earlier.action), earlier.name(), p.Precedence)})
+				break
+			}
+		}
+	}
+
+	return warnings
+}
+
+func actionPastTense(action string) string {
+	if action == ActionAllow {
+		return "allowed"
+	}
+
+	return "denied"
+}
+
+/* returns the path to an empty list inside the matcher, or "" if there isn't one */
+func emptyMatcher(tm TenantMatch) string {
+	lists := map[string]*[]string{
+		"exactMatch": tm.ExactMatch,
+		"prefix":     tm.PrefixMatch,
+		"suffix":     tm.SuffixMatch,
+		"regex":      tm.RegexMatch,
+		"glob":       tm.GlobMatch,
+	}
+
+	for name, list := range lists {
+		if list != nil && len(*list) == 0 {
+			return name
+		}
+	}
+
+	if tm.RangeMatch != nil && len(*tm.RangeMatch) == 0 {
+		return "range"
+	}
+
+	composites := map[string]*[]TenantMatch{
+		"all_of": tm.AllOf,
+		"any_of": tm.AnyOf,
+	}
+
+	for name, list := range composites {
+		if list == nil {
+			continue
+		}
+
+		for i, m := range *list {
+			if path := emptyMatcher(m); path != "" {
+				return fmt.Sprintf("%v[%d].%v", name, i, path)
+			}
+		}
+	}
+
+	if tm.Not != nil {
+		if path := emptyMatcher(*tm.Not); path != "" {
+			return "not." + path
+		}
+	}
+
+	return ""
+}
+
+func overlappingRanges(rules []candidateRule) []LintWarning {
+	type namedRange struct {
+		name string
+		r    TenantRangeMatch
+	}
+
+	ranges := make([]namedRange, 0)
+	for _, rule := range rules {
+		if rule.match.RangeMatch == nil {
+			continue
+		}
+
+		for i, r := range *rule.match.RangeMatch {
+			ranges = append(ranges, namedRange{fmt.Sprintf("%v.range[%d]", rule.name(), i), r})
+		}
+	}
+
+	warnings := make([]LintWarning, 0)
+	for j := range ranges {
+		for i := 0; i < j; i++ {
+			if rangesOverlap(ranges[i].r, ranges[j].r) {
+				warnings = append(warnings, LintWarning{ranges[j].name, fmt.Sprintf("overlaps %v", ranges[i].name)})
+			}
+		}
+	}
+
+	return warnings
+}
+
+/* the normalized bounds of a range, hex prefixes are widened so ranges with different prefix lengths compare */
+func rangeBounds(r TenantRangeMatch, width int) (rangeBound, rangeBound, bool) {
+	start, err := normalizeRangeKey(r.rangeType(), r.Start)
+	if err != nil {
+		return rangeBound{}, rangeBound{}, false
+	}
+
+	end, err := normalizeRangeKey(r.rangeType(), r.End)
+	if err != nil {
+		return rangeBound{}, rangeBound{}, false
+	}
+
+	if r.rangeType() == RangeTypeHexPrefix {
+		start.key = start.key + strings.Repeat("0", width-len(start.key))
+		end.key = end.key + strings.Repeat("f", width-len(end.key))
+	}
+
+	return start, end, true
+}
+
+/* the number of hex digits in the longest bound, dashes don't count as they are dropped when normalizing */
+func hexPrefixWidth(ranges ...TenantRangeMatch) int {
+	width := 0
+	for _, r := range ranges {
+		for _, v := range []string{r.Start, r.End} {
+			if n := len(strings.ReplaceAll(strings.TrimSpace(v), "-", "")); n > width {
+				width = n
+			}
+		}
+	}
+
+	return width
+}
+
+func rangesOverlap(a TenantRangeMatch, b TenantRangeMatch) bool {
+	if a.rangeType() != b.rangeType() {
+		return false
+	}
+
+	width := hexPrefixWidth(a, b)
+	aStart, aEnd, ok := rangeBounds(a, width)
+	if !ok {
+		return false
+	}
+
+	bStart, bEnd, ok := rangeBounds(b, width)
+	if !ok {
+		return false
+	}
+
+	cmp := func(x, y rangeBound) int {
+		if a.rangeType() == RangeTypeInteger {
+			return x.num.Cmp(y.num)
+		}
+		return strings.Compare(x.key, y.key)
+	}
+
+	return cmp(aStart, bEnd) <= 0 && cmp(bStart, aEnd) <= 0
+}
+
+func rangeContains(outer TenantRangeMatch, inner TenantRangeMatch) bool {
+	if outer.rangeType() != inner.rangeType() {
+		return false
+	}
+
+	width := hexPrefixWidth(outer, inner)
+	oStart, oEnd, ok := rangeBounds(outer, width)
+	if !ok {
+		return false
+	}
+
+	iStart, iEnd, ok := rangeBounds(inner, width)
+	if !ok {
+		return false
+	}
+
+	cmp := func(x, y rangeBound) int {
+		if outer.rangeType() == RangeTypeInteger {
+			return x.num.Cmp(y.num)
+		}
+		return strings.Compare(x.key, y.key)
+	}
+
+	return cmp(oStart, iStart) <= 0 && cmp(iEnd, oEnd) <= 0
+}
+
+func matcherEqual(a TenantMatch, b TenantMatch) bool {
+	aJSON, _ := json.Marshal(a)
+	bJSON, _ := json.Marshal(b)
+
+	return string(aJSON) == string(bJSON)
+}
+
+func isCatchAll(tm TenantMatch) bool {
+	if tm.ExactMatch != nil {
+		for _, v := range *tm.ExactMatch {
+			if v == "*" {
+				return true
+			}
+		}
+	}
+
+	if tm.PrefixMatch != nil {
+		for _, v := range *tm.PrefixMatch {
+			if v == "" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+/* conservatively decides whether every tenant matched by inner is also matched by outer */
+func matcherCovers(outer TenantMatch, inner TenantMatch) bool {
+	if isCatchAll(outer) || matcherEqual(outer, inner) {
+		return true
+	}
+
+	switch {
+	case outer.ExactMatch != nil && inner.ExactMatch != nil:
+		return allCovered(*inner.ExactMatch, func(v string) bool { return exactMatches(v, *outer.ExactMatch) })
+
+	case outer.PrefixMatch != nil && inner.ExactMatch != nil:
+		return allCovered(*inner.ExactMatch, func(v string) bool { return v != "*" && prefixMatches(v, *outer.PrefixMatch) })
+
+	case outer.PrefixMatch != nil && inner.PrefixMatch != nil:
+		return allCovered(*inner.PrefixMatch, func(v string) bool { return prefixMatches(v, *outer.PrefixMatch) })
+
+	case outer.SuffixMatch != nil && inner.SuffixMatch != nil:
+		return allCovered(*inner.SuffixMatch, func(v string) bool { return suffixMatches(v, *outer.SuffixMatch) })
+
+	case outer.RangeMatch != nil && inner.RangeMatch != nil:
+		for _, i := range *inner.RangeMatch {
+			covered := false
+			for _, o := range *outer.RangeMatch {
+				if rangeContains(o, i) {
+					covered = true
+					break
+				}
+			}
+
+			if !covered {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	// fall back to the keyspace for matchers that only talk about UUIDs
+	outerIntervals, ok := outer.UUIDIntervals()
+	if !ok {
+		return false
+	}
+
+	innerIntervals, ok := inner.UUIDIntervals()
+	if !ok {
+		return false
+	}
+
+	return len(SubtractIntervals(innerIntervals, outerIntervals)) == 0
+}
+
+func allCovered(values []string, covered func(string) bool) bool {
+	for _, v := range values {
+		if !covered(v) {
+			return false
+		}
+	}
+
+	return true
+}
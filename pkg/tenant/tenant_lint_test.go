@@ -0,0 +1,124 @@
+package tenant
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		warnings []string
+	}{
+		{
+			name:   "clean config",
+			config: "allowed_tenants:\n- prefix: [a]\ndenied_tenants:\n- exactMatch: [a1]\n",
+		},
+		{
+			name:     "empty list",
+			config:   "allowed_tenants:\n- prefix: []\n",
+			warnings: []string{"allowed_tenants[0]: prefix is empty and never matches"},
+		},
+		{
+			name:     "empty list in all_of",
+			config:   "allowed_tenants:\n- all_of: [{prefix: [a]}, {suffix: []}]\n",
+			warnings: []string{"allowed_tenants[0]: all_of[1].suffix is empty and never matches"},
+		},
+		{
+			name:     "empty list in any_of",
+			config:   "allowed_tenants:\n- any_of: [{prefix: [a]}, {any_of: [{exactMatch: []}]}]\n",
+			warnings: []string{"allowed_tenants[0]: any_of[1].any_of[0].exactMatch is empty and never matches"},
+		},
+		{
+			name:     "empty list under not",
+			config:   "allowed_tenants:\n- prefix: [a]\ndenied_tenants:\n- not: {all_of: [{regex: []}]}\n",
+			warnings: []string{"denied_tenants[0]: not.all_of[0].regex is empty and never matches"},
+		},
+		{
+			name:     "overlapping ranges",
+			config:   "allowed_tenants:\n- range: [{type: integer, start: \"1\", end: \"10\"}]\n- range: [{type: integer, start: \"10\", end: \"20\"}]\n",
+			warnings: []string{"allowed_tenants[1].range[0]: overlaps allowed_tenants[0].range[0]"},
+		},
+		{
+			name:   "adjacent ranges",
+			config: "allowed_tenants:\n- range: [{type: integer, start: \"1\", end: \"9\"}]\n- range: [{type: integer, start: \"10\", end: \"20\"}]\n",
+		},
+		{
+			name:     "overlapping hex-prefix ranges of different widths",
+			config:   "allowed_tenants:\n- range: [{type: hex-prefix, start: \"0\", end: \"3f\"}]\n- range: [{type: hex-prefix, start: \"3fff\", end: \"7\"}]\n",
+			warnings: []string{"allowed_tenants[1].range[0]: overlaps allowed_tenants[0].range[0]"},
+		},
+		{
+			name:   "adjacent hex-prefix ranges with dashes",
+			config: "allowed_tenants:\n- range: [{type: hex-prefix, start: \"0\", end: \"3fff-ffff\"}]\n- range: [{type: hex-prefix, start: \"4\", end: \"7f\"}]\n",
+		},
+		{
+			name:     "overlapping hex-prefix ranges with dashes",
+			config:   "allowed_tenants:\n- range: [{type: hex-prefix, start: \"0\", end: \"4000-0\"}]\n- range: [{type: hex-prefix, start: \"4\", end: \"7f\"}]\n",
+			warnings: []string{"allowed_tenants[1].range[0]: overlaps allowed_tenants[0].range[0]"},
+		},
+		{
+			name:   "ranges of different types",
+			config: "allowed_tenants:\n- range: [{type: integer, start: \"1\", end: \"10\"}]\n- range: [{start: \"1\", end: \"10\"}]\n",
+		},
+		{
+			name:     "allow unreachable behind deny",
+			config:   "allowed_tenants:\n- prefix: [a1]\ndenied_tenants:\n- prefix: [a]\n",
+			warnings: []string{"allowed_tenants[0]: unreachable, every tenant it matches is already denied by denied_tenants[0] (deny-overrides)"},
+		},
+		{
+			name:     "deny unreachable behind allow",
+			config:   "precedence: allow-overrides\nallowed_tenants:\n- exactMatch: [\"*\"]\ndenied_tenants:\n- exactMatch: [a]\n",
+			warnings: []string{"denied_tenants[0]: unreachable, every tenant it matches is already allowed by allowed_tenants[0] (allow-overrides)"},
+		},
+		{
+			name:     "first-match rule unreachable",
+			config:   "precedence: first-match\nrules:\n- action: deny\n  range: [{type: uuid, start: 00000000-0000-0000-0000-000000000000, end: 7fffffff-ffff-ffff-ffff-ffffffffffff}]\n- action: allow\n  range: [{type: uuid, start: 10000000-0000-0000-0000-000000000000, end: 2fffffff-ffff-ffff-ffff-ffffffffffff}]\n",
+			warnings: []string{"rules[1]: unreachable, every tenant it matches is already denied by rules[0] (first-match)"},
+		},
+		{
+			// a hex-prefix range also matches tenant ids that aren't UUIDs, which the uuid range doesn't
+			name:   "hex-prefix not covered by a uuid range",
+			config: "precedence: first-match\nrules:\n- action: deny\n  range: [{type: uuid, start: 00000000-0000-0000-0000-000000000000, end: 7fffffff-ffff-ffff-ffff-ffffffffffff}]\n- action: allow\n  range: [{type: hex-prefix, start: \"1\", end: \"2\"}]\n",
+		},
+		{
+			name:     "duplicate rule",
+			config:   "allowed_tenants:\n- prefix: [a]\n- prefix: [a]\n",
+			warnings: []string{"allowed_tenants[1]: duplicates allowed_tenants[0]"},
+		},
+		{
+			name:     "legacy matchers",
+			config:   "allowed_tenants:\n- exactMatch: [a]\n  prefix: [b]\n",
+			warnings: []string{"allowed_tenants[0]: deprecated: sets several matchers (exactMatch, prefix) but only exactMatch is used"},
+		},
+		{
+			name:     "method override",
+			config:   "allowed_tenants:\n- prefix: [a]\nmethods:\n  /helloworld.Greeter/:\n    allowed_tenants:\n    - prefix: []\n",
+			warnings: []string{"methods[/helloworld.Greeter/].allowed_tenants[0]: prefix is empty and never matches"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			warnings := parseTestConfig(t, test.config).Lint()
+
+			if len(warnings) != len(test.warnings) {
+				t.Fatalf("Lint() = %v, want %v", warnings, test.warnings)
+			}
+
+			for i, w := range warnings {
+				if !strings.HasPrefix(w.String(), test.warnings[i]) {
+					t.Errorf("Lint()[%d] = %q, want %q", i, w, test.warnings[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHexPrefixWidthIgnoresDashes(t *testing.T) {
+	width := hexPrefixWidth(TenantRangeMatch{Type: RangeTypeHexPrefix, Start: "0000-00", End: "3fff-ff"})
+	if width != 6 {
+		t.Errorf("hexPrefixWidth() = %d, want 6", width)
+	}
+}
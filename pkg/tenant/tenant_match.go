@@ -0,0 +1,220 @@
+package tenant
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* names of the matchers set on this entry, composite matchers can't be combined with any other */
+func (tm *TenantMatch) matcherNames() []string {
+	names := make([]string, 0, 1)
+
+	if tm.ExactMatch != nil {
+		names = append(names, "exactMatch")
+	}
+	if tm.PrefixMatch != nil {
+		names = append(names, "prefix")
+	}
+	if tm.SuffixMatch != nil {
+		names = append(names, "suffix")
+	}
+	if tm.RegexMatch != nil {
+		names = append(names, "regex")
+	}
+	if tm.GlobMatch != nil {
+		names = append(names, "glob")
+	}
+	if tm.RangeMatch != nil {
+		names = append(names, "range")
+	}
+	if tm.AllOf != nil {
+		names = append(names, "all_of")
+	}
+	if tm.AnyOf != nil {
+		names = append(names, "any_of")
+	}
+	if tm.Not != nil {
+		names = append(names, "not")
+	}
+
+	return names
+}
+
+/* validate the matcher and precompute anything needed to evaluate it */
+func (tm *TenantMatch) compile() error {
+	names := tm.matcherNames()
+	if len(names) == 0 {
+		return fmt.Errorf("no matcher set")
+	}
+
+	if len(names) > 1 {
+		if !legacyMatchers(names) {
+			return fmt.Errorf("multiple matchers set (%v), combine them with all_of or any_of", strings.Join(names, ", "))
+		}
+
+		// before composite matchers an entry that set several only used the first, keep those configs meaning the same
+		tm.keepFirstMatcher(names)
+	}
+
+	switch {
+	case tm.RangeMatch != nil:
+		for i := range *tm.RangeMatch {
+			if err := (*tm.RangeMatch)[i].compile(); err != nil {
+				return fmt.Errorf("range[%d]: %v", i, err)
+			}
+		}
+
+	case tm.RegexMatch != nil:
+		regexes, err := compileTenantRegexes(*tm.RegexMatch)
+		if err != nil {
+			return err
+		}
+
+		tm.regexes = regexes
+
+	case tm.GlobMatch != nil:
+		return validateTenantGlobs(*tm.GlobMatch)
+
+	case tm.AllOf != nil:
+		return compileTenantMatches("all_of", *tm.AllOf)
+
+	case tm.AnyOf != nil:
+		return compileTenantMatches("any_of", *tm.AnyOf)
+
+	case tm.Not != nil:
+		if err := tm.Not.compile(); err != nil {
+			return fmt.Errorf("not: %v", err)
+		}
+	}
+
+	return nil
+}
+
+/* only exactMatch, prefix and range existed before composite matchers, other combinations were never valid */
+func legacyMatchers(names []string) bool {
+	for _, name := range names {
+		if name != "exactMatch" && name != "prefix" && name != "range" {
+			return false
+		}
+	}
+
+	return true
+}
+
+/* drop all but the matcher that was used before composite matchers: exactMatch, then prefix, then range */
+func (tm *TenantMatch) keepFirstMatcher(names []string) {
+	switch {
+	case tm.ExactMatch != nil:
+		*tm = TenantMatch{ExactMatch: tm.ExactMatch}
+	case tm.PrefixMatch != nil:
+		*tm = TenantMatch{PrefixMatch: tm.PrefixMatch}
+	}
+
+	tm.legacyMatchers = names
+}
+
+func compileTenantMatches(name string, matches []TenantMatch) error {
+	if len(matches) == 0 {
+		return fmt.Errorf("%v must not be empty", name)
+	}
+
+	for i := range matches {
+		if err := matches[i].compile(); err != nil {
+			return fmt.Errorf("%v[%d]: %v", name, i, err)
+		}
+	}
+
+	return nil
+}
+
+/* evaluates a matcher against the tenant id, composite matchers are evaluated recursively */
+func tenantMatches(tenantIdToCheck string, tm TenantMatch) bool {
+	switch {
+	case tm.AllOf != nil:
+		for _, m := range *tm.AllOf {
+			if !tenantMatches(tenantIdToCheck, m) {
+				return false
+			}
+		}
+
+		return len(*tm.AllOf) > 0
+
+	case tm.AnyOf != nil:
+		for _, m := range *tm.AnyOf {
+			if tenantMatches(tenantIdToCheck, m) {
+				return true
+			}
+		}
+
+		return false
+
+	case tm.Not != nil:
+		return !tenantMatches(tenantIdToCheck, *tm.Not)
+
+	case tm.ExactMatch != nil:
+		return exactMatches(tenantIdToCheck, *tm.ExactMatch)
+
+	case tm.PrefixMatch != nil:
+		return prefixMatches(tenantIdToCheck, *tm.PrefixMatch)
+
+	case tm.SuffixMatch != nil:
+		return suffixMatches(tenantIdToCheck, *tm.SuffixMatch)
+
+	case tm.RegexMatch != nil:
+		return regexMatches(tenantIdToCheck, tm)
+
+	case tm.GlobMatch != nil:
+		return globMatches(tenantIdToCheck, tm)
+
+	case tm.RangeMatch != nil:
+		return rangeMatches(tenantIdToCheck, *tm.RangeMatch)
+	}
+
+	// how did we get here?  nothing is defined
+	return false
+}
+
+func exactMatches(tenantIdToCheck string, values []string) bool {
+	for _, t := range values {
+		if tenantIdToCheck == t {
+			return true
+		}
+
+		// special case: "*"
+		if t == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func prefixMatches(tenantIdToCheck string, values []string) bool {
+	for _, t := range values {
+		if strings.HasPrefix(tenantIdToCheck, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func suffixMatches(tenantIdToCheck string, values []string) bool {
+	for _, t := range values {
+		if strings.HasSuffix(tenantIdToCheck, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func rangeMatches(tenantIdToCheck string, ranges []TenantRangeMatch) bool {
+	for i := range ranges {
+		if ranges[i].contains(tenantIdToCheck) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,227 @@
+package tenant
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func compileMatch(t *testing.T, matchYAML string) (TenantMatch, error) {
+	t.Helper()
+
+	var tm TenantMatch
+	if err := yaml.Unmarshal([]byte(matchYAML), &tm); err != nil {
+		t.Fatalf("unable to parse %q: %v", matchYAML, err)
+	}
+
+	return tm, tm.compile()
+}
+
+func TestTenantMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		match   string
+		matches []string
+		misses  []string
+	}{
+		{
+			name:    "all_of",
+			match:   `all_of: [{prefix: ["acme-"]}, {suffix: ["-eu"]}]`,
+			matches: []string{"acme-1-eu"},
+			misses:  []string{"acme-1-us", "other-eu"},
+		},
+		{
+			name:    "any_of",
+			match:   `any_of: [{exactMatch: ["a"]}, {glob: ["b-*"]}]`,
+			matches: []string{"a", "b-1"},
+			misses:  []string{"c", "ab"},
+		},
+		{
+			name:    "not",
+			match:   `not: {prefix: ["test-"]}`,
+			matches: []string{"prod-1"},
+			misses:  []string{"test-1"},
+		},
+		{
+			name:    "all_of with not",
+			match:   `all_of: [{prefix: ["3"]}, {not: {exactMatch: ["3a", "3b"]}}]`,
+			matches: []string{"3c", "3"},
+			misses:  []string{"3a", "3b", "4"},
+		},
+		{
+			name:    "any_of with not",
+			match:   `any_of: [{exactMatch: ["x"]}, {not: {prefix: ["a"]}}]`,
+			matches: []string{"x", "b"},
+			misses:  []string{"a1"},
+		},
+		{
+			name:    "not over all_of",
+			match:   `not: {all_of: [{prefix: ["a"]}, {suffix: ["z"]}]}`,
+			matches: []string{"ab", "bz", "c"},
+			misses:  []string{"az", "abz"},
+		},
+		{
+			name:    "not over any_of",
+			match:   `not: {any_of: [{prefix: ["a"]}, {suffix: ["z"]}]}`,
+			matches: []string{"b", "c"},
+			misses:  []string{"ab", "bz", "az"},
+		},
+		{
+			name:    "not over not",
+			match:   `not: {not: {exactMatch: ["a"]}}`,
+			matches: []string{"a"},
+			misses:  []string{"b"},
+		},
+		{
+			name:    "nested any_of in all_of",
+			match:   `all_of: [{any_of: [{prefix: ["a"]}, {prefix: ["b"]}]}, {any_of: [{suffix: ["1"]}, {suffix: ["2"]}]}]`,
+			matches: []string{"a1", "b2", "ax2"},
+			misses:  []string{"a3", "c1"},
+		},
+		{
+			name:    "nested all_of in any_of",
+			match:   `any_of: [{all_of: [{prefix: ["a"]}, {suffix: ["1"]}]}, {all_of: [{prefix: ["b"]}, {suffix: ["2"]}]}]`,
+			matches: []string{"a1", "b2"},
+			misses:  []string{"a2", "b1"},
+		},
+		{
+			name:    "composite with range",
+			match:   `all_of: [{range: [{type: integer, start: "10", end: "20"}]}, {not: {exactMatch: ["15"]}}]`,
+			matches: []string{"10", "20", "14"},
+			misses:  []string{"15", "9", "21", "abc"},
+		},
+		{
+			name:   "empty list never matches",
+			match:  `exactMatch: []`,
+			misses: []string{"a", ""},
+		},
+		{
+			name:    "not over empty list matches everything",
+			match:   `not: {prefix: []}`,
+			matches: []string{"a", ""},
+		},
+		{
+			name:   "all_of with an empty list never matches",
+			match:  `all_of: [{prefix: ["a"]}, {suffix: []}]`,
+			misses: []string{"a", "ab"},
+		},
+		{
+			name:    "any_of with an empty list",
+			match:   `any_of: [{prefix: ["a"]}, {suffix: []}]`,
+			matches: []string{"ab"},
+			misses:  []string{"b"},
+		},
+		{
+			name:    "legacy exactMatch and prefix only use exactMatch",
+			match:   `{exactMatch: ["x"], prefix: ["a"]}`,
+			matches: []string{"x"},
+			misses:  []string{"a1", "b"},
+		},
+		{
+			name:    "legacy prefix and range only use prefix",
+			match:   `{prefix: ["a"], range: [{start: "m", end: "n"}]}`,
+			matches: []string{"a1"},
+			misses:  []string{"m1", "b", "o"},
+		},
+		{
+			name:    "legacy matchers nested in all_of",
+			match:   `all_of: [{exactMatch: ["x", "a2"], prefix: ["a"]}, {not: {exactMatch: ["a2"]}}]`,
+			matches: []string{"x"},
+			misses:  []string{"a1", "a2", "b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tm, err := compileMatch(t, test.match)
+			if err != nil {
+				t.Fatalf("compile() = %v", err)
+			}
+
+			for _, id := range test.matches {
+				if !tenantMatches(id, tm) {
+					t.Errorf("%q doesn't match, want a match", id)
+				}
+			}
+
+			for _, id := range test.misses {
+				if tenantMatches(id, tm) {
+					t.Errorf("%q matches, want no match", id)
+				}
+			}
+		})
+	}
+}
+
+func TestTenantMatchCompileErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		match string
+		err   string
+	}{
+		{"no matcher", `{}`, "no matcher set"},
+		{"empty all_of", `all_of: []`, "all_of must not be empty"},
+		{"empty any_of", `any_of: []`, "any_of must not be empty"},
+		{"empty not", `not: {}`, "not: no matcher set"},
+		{"composite with a plain matcher", `{all_of: [{prefix: ["a"]}], exactMatch: ["b"]}`, "multiple matchers set (exactMatch, all_of)"},
+		{"two composites", `{any_of: [{prefix: ["a"]}], not: {prefix: ["b"]}}`, "multiple matchers set (any_of, not)"},
+		{"nested error path", `all_of: [{prefix: ["a"]}, {any_of: [{regex: ["a("]}]}]`, "all_of[1]: any_of[0]: regex[0]: invalid regex"},
+		{"error under not", `not: {all_of: []}`, "not: all_of must not be empty"},
+		{"prefix and regex were never valid together", `{prefix: ["a"], regex: ["a"]}`, "multiple matchers set (prefix, regex)"},
+		{"exactMatch and suffix were never valid together", `{exactMatch: ["a"], suffix: ["a"]}`, "multiple matchers set (exactMatch, suffix)"},
+		{"legacy matchers with a composite", `{exactMatch: ["a"], prefix: ["a"], not: {prefix: ["b"]}}`, "multiple matchers set (exactMatch, prefix, not)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := compileMatch(t, test.match)
+			if err == nil {
+				t.Fatalf("compile() = nil, want an error containing %q", test.err)
+			}
+
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("compile() = %q, want an error containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestLegacyMatchersDeprecated(t *testing.T) {
+	config, err := ParseTenantConfig([]byte(`
+allowed_tenants:
+- exactMatch: ["x"]
+  prefix: ["a"]
+- any_of: [{prefix: ["b"]}]
+methods:
+  /helloworld.Greeter/SayHello:
+    denied_tenants:
+    - prefix: ["a1"]
+      range: [{start: "b", end: "c"}]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"allowed_tenants[0]", "methods[/helloworld.Greeter/SayHello].denied_tenants[0]"}
+
+	warnings := config.Deprecations()
+	if len(warnings) != len(want) {
+		t.Fatalf("Deprecations() = %v, want warnings for %v", warnings, want)
+	}
+
+	for i, w := range warnings {
+		if w.Rule != want[i] {
+			t.Errorf("Deprecations()[%d].Rule = %v, want %v", i, w.Rule, want[i])
+		}
+	}
+
+	if !strings.Contains(warnings[0].Message, "(exactMatch, prefix) but only exactMatch is used") {
+		t.Errorf("Deprecations()[0].Message = %q, want it to name the matcher that is used", warnings[0].Message)
+	}
+
+	// widening the entry to any_of would allow a2 through the prefix
+	if !config.CheckTenantId("x") || config.CheckTenantId("a2") || config.CheckTenantId("c") {
+		t.Errorf("legacy allowed_tenants[0] isn't evaluated with only its exactMatch")
+	}
+}
@@ -0,0 +1,48 @@
+package tenant
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/* method override keys in a stable order */
+func (t *TenantConfig) methodNames() []string {
+	names := make([]string, 0, len(t.Methods))
+	for name := range t.Methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+/* a full method name /package.Service/Method, or /package.Service/ for every method of the service */
+func validateMethodName(name string) error {
+	parts := strings.Split(name, "/")
+	if len(parts) != 3 || parts[0] != "" || parts[1] == "" {
+		return fmt.Errorf("invalid method %q, expected /package.Service/Method or /package.Service/", name)
+	}
+
+	return nil
+}
+
+/* Policy returns the policy for a full method name: the method's override, the service's, or the global policy */
+func (t *TenantConfig) Policy(fullMethod string) *TenantPolicy {
+	if policy, ok := t.Methods[fullMethod]; ok {
+		return policy
+	}
+
+	if i := strings.LastIndex(fullMethod, "/"); i > 0 {
+		if policy, ok := t.Methods[fullMethod[:i+1]]; ok {
+			return policy
+		}
+	}
+
+	return &t.TenantPolicy
+}
+
+/* EvaluateMethod checks the tenant id against the policy for a full gRPC method name */
+func (t *TenantConfig) EvaluateMethod(fullMethod string, tenantIdToCheck string) TenantDecision {
+	return t.Policy(fullMethod).Evaluate(tenantIdToCheck)
+}
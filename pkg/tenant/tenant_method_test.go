@@ -0,0 +1,96 @@
+package tenant
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPolicyLookup(t *testing.T) {
+	config := parseTestConfig(t, `
+allowed_tenants:
+- prefix: ["global-"]
+methods:
+  /helloworld.Greeter/:
+    allowed_tenants:
+    - prefix: ["service-"]
+  /helloworld.Greeter/StreamingHello:
+    allowed_tenants:
+    - prefix: ["method-"]
+  /other.Service/Call:
+    allowed_tenants:
+    - prefix: ["other-"]
+`)
+
+	tests := []struct {
+		name   string
+		method string
+		policy string
+		allows string
+	}{
+		{"method override", "/helloworld.Greeter/StreamingHello", "methods[/helloworld.Greeter/StreamingHello]", "method-1"},
+		{"service override", "/helloworld.Greeter/SayHello", "methods[/helloworld.Greeter/]", "service-1"},
+		{"unknown method of an overridden service", "/helloworld.Greeter/Unknown", "methods[/helloworld.Greeter/]", "service-1"},
+		{"method override without a service override", "/other.Service/Call", "methods[/other.Service/Call]", "other-1"},
+		{"other method of that service", "/other.Service/Other", "", "global-1"},
+		{"unknown service", "/unknown.Service/Call", "", "global-1"},
+		{"no method", "", "", "global-1"},
+		{"not a full method name", "SayHello", "", "global-1"},
+		// the service prefix is the whole method up to the last /, not a partial match
+		{"service name prefix", "/helloworld.GreeterV2/SayHello", "", "global-1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decision := config.EvaluateMethod(test.method, test.allows)
+			if !decision.Allowed {
+				t.Errorf("expected %v allowed through %v, got %+v", test.allows, test.method, decision)
+			}
+
+			if decision.Policy != test.policy {
+				t.Errorf("expected policy %q, got %q", test.policy, decision.Policy)
+			}
+
+			// and nothing from the policies it didn't pick
+			for _, other := range []string{"global-1", "service-1", "method-1", "other-1"} {
+				if other != test.allows && config.EvaluateMethod(test.method, other).Allowed {
+					t.Errorf("expected %v rejected through %v", other, test.method)
+				}
+			}
+		})
+	}
+}
+
+func TestPolicyLookupWithoutOverrides(t *testing.T) {
+	config := parseTestConfig(t, "allowed_tenants:\n- prefix: [a]\n")
+
+	if policy := config.Policy("/helloworld.Greeter/SayHello"); policy != &config.TenantPolicy {
+		t.Errorf("expected the global policy, got %+v", policy)
+	}
+}
+
+func TestMethodNameValidation(t *testing.T) {
+	tests := []struct {
+		method string
+		valid  bool
+	}{
+		{"/helloworld.Greeter/SayHello", true},
+		{"/helloworld.Greeter/", true},
+		{"helloworld.Greeter/SayHello", false},
+		{"/helloworld.Greeter", false},
+		{"//SayHello", false},
+		{"/helloworld.Greeter/SayHello/Extra", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method, func(t *testing.T) {
+			_, err := ParseTenantConfig([]byte("methods:\n  " + test.method + ":\n    allowed_tenants:\n    - prefix: [a]\n"))
+			if (err == nil) != test.valid {
+				t.Fatalf("expected valid %v, got %v", test.valid, err)
+			}
+
+			if err != nil && !strings.Contains(err.Error(), "invalid method") {
+				t.Errorf("expected an invalid method error, got %v", err)
+			}
+		})
+	}
+}
@@ -20,6 +20,7 @@ type TenantMetrics struct {
 type monitoredServerStream struct {
 	grpc.ServerStream
 	metrics *TenantMetrics
+	tenantId string
 }
 
 func NewTenantMetrics() *TenantMetrics {
@@ -72,11 +73,13 @@ func (metrics *TenantMetrics) decConnections(tenantId string) {
 
 
 func (metrics *TenantMetrics) TenantMetricsUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	tenantId, err := GetTenantId(ctx)
-
-	if err != nil {
-		return nil, err
+	// set by the authorization interceptor, which has to run first
+	identity, ok := FromContext(ctx)
+	if !ok {
+		// exempt from tenant authorization, e.g. health checks
+		return handler(ctx, req)
 	}
+	tenantId := identity.TenantId
 
 	metrics.incConnections(tenantId)
 	metrics.incRequests(tenantId)
@@ -90,11 +93,11 @@ func (metrics *TenantMetrics) TenantMetricsUnaryInterceptor(ctx context.Context,
 }
 
 func (metrics *TenantMetrics) TenantMetricsStreamInterceptor(req interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-	tenantId, err := GetTenantId(ss.Context())
-
-	if err != nil {
-		return err
+	identity, ok := FromContext(ss.Context())
+	if !ok {
+		return handler(req, ss)
 	}
+	tenantId := identity.TenantId
 
 	metrics.incConnections(tenantId)
 
@@ -102,9 +105,10 @@ func (metrics *TenantMetrics) TenantMetricsStreamInterceptor(req interface{}, ss
 	monitoredStream := &monitoredServerStream{
 		ss, 
 		metrics,
+		tenantId,
 	}
 
-	err = handler(req, monitoredStream)
+	err := handler(req, monitoredStream)
 
 	metrics.decConnections(tenantId)
 
@@ -116,13 +120,8 @@ func (stream *monitoredServerStream) SendMsg(m interface{}) error {
 }
 
 func (stream *monitoredServerStream) RecvMsg(m interface{}) error {
-	tenantId, err := GetTenantId(stream.Context())
-	if err != nil {
-		return err
-	}
-
-	err = stream.ServerStream.RecvMsg(m)
-	stream.metrics.incRequests(tenantId)
+	err := stream.ServerStream.RecvMsg(m)
+	stream.metrics.incRequests(stream.tenantId)
 
 	return err
 }
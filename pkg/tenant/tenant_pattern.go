@@ -0,0 +1,62 @@
+package tenant
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+)
+
+/* regex matchers must match the whole tenant id, so anchor them when compiling */
+func compileTenantRegex(pattern string) (*regexp.Regexp, error) {
+	if _, err := regexp.Compile(pattern); err != nil {
+		return nil, fmt.Errorf("invalid regex %q: %v", pattern, err)
+	}
+
+	return regexp.Compile(fmt.Sprintf("^(?:%v)$", pattern))
+}
+
+func compileTenantRegexes(patterns []string) ([]*regexp.Regexp, error) {
+	regexes := make([]*regexp.Regexp, 0, len(patterns))
+	for i, pattern := range patterns {
+		re, err := compileTenantRegex(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("regex[%d]: %v", i, err)
+		}
+
+		regexes = append(regexes, re)
+	}
+
+	return regexes, nil
+}
+
+/* globs use path.Match syntax: * matches any run of characters except /, ? matches one character, [a-z] a class */
+func validateTenantGlobs(patterns []string) error {
+	for i, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("glob[%d]: invalid glob %q: %v", i, pattern, err)
+		}
+	}
+
+	return nil
+}
+
+/* the regexes are compiled when the config is loaded, a matcher that wasn't compiled never matches */
+func regexMatches(tenantIdToCheck string, tm TenantMatch) bool {
+	for _, re := range tm.regexes {
+		if re.MatchString(tenantIdToCheck) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func globMatches(tenantIdToCheck string, tm TenantMatch) bool {
+	for _, pattern := range *tm.GlobMatch {
+		if matched, _ := path.Match(pattern, tenantIdToCheck); matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,133 @@
+package tenant
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPatternMatchers(t *testing.T) {
+	tests := []struct {
+		name    string
+		match   string
+		matches []string
+		misses  []string
+	}{
+		{
+			name:    "regex matches the whole tenant id",
+			match:   `regex: ["tenant-[0-9]+"]`,
+			matches: []string{"tenant-1", "tenant-42"},
+			misses:  []string{"xtenant-1", "tenant-1x", "my-tenant-1-test", "tenant-"},
+		},
+		{
+			name:    "regex alternation is anchored as a whole",
+			match:   `regex: ["a|b"]`,
+			matches: []string{"a", "b"},
+			misses:  []string{"ab", "xa", "bx"},
+		},
+		{
+			name:    "regex with its own anchors",
+			match:   `regex: ["^team-.*$"]`,
+			matches: []string{"team-a", "team-"},
+			misses:  []string{"a-team-a"},
+		},
+		{
+			name:    "any regex in the list",
+			match:   `regex: ["a+", "b+"]`,
+			matches: []string{"aaa", "bb"},
+			misses:  []string{"ab", "c"},
+		},
+		{
+			name:    "glob star",
+			match:   `glob: ["team-*"]`,
+			matches: []string{"team-a", "team-", "team-a-b"},
+			misses:  []string{"xteam-a", "team", "team-a/b"},
+		},
+		{
+			name:    "glob question mark and class",
+			match:   `glob: ["shard-?[0-3]"]`,
+			matches: []string{"shard-a0", "shard-b3"},
+			misses:  []string{"shard-a4", "shard-0", "shard-ab0"},
+		},
+		{
+			name:    "glob negated class",
+			match:   `glob: ["[^x]*"]`,
+			matches: []string{"abc"},
+			misses:  []string{"xyz"},
+		},
+		{
+			name:    "glob escape",
+			match:   `glob: ["a\\*"]`,
+			matches: []string{"a*"},
+			misses:  []string{"ab"},
+		},
+		{
+			name:    "suffix",
+			match:   `suffix: ["-test", "-staging"]`,
+			matches: []string{"tenant-test", "-test", "a-staging"},
+			misses:  []string{"tenant-test-a", "tenant-prod", "test"},
+		},
+		{
+			name:    "empty suffix matches everything",
+			match:   `suffix: [""]`,
+			matches: []string{"a", ""},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tm, err := compileMatch(t, test.match)
+			if err != nil {
+				t.Fatalf("compile() = %v", err)
+			}
+
+			for _, id := range test.matches {
+				if !tenantMatches(id, tm) {
+					t.Errorf("%q doesn't match, want a match", id)
+				}
+			}
+
+			for _, id := range test.misses {
+				if tenantMatches(id, tm) {
+					t.Errorf("%q matches, want no match", id)
+				}
+			}
+		})
+	}
+}
+
+func TestPatternCompileErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		match string
+		err   string
+	}{
+		{"invalid regex", `regex: ["ok", "a("]`, `regex[1]: invalid regex "a("`},
+		{"regex that would escape the anchoring group", `regex: ["a)|(b"]`, `regex[0]: invalid regex "a)|(b"`},
+		{"invalid glob", `glob: ["[a-"]`, `glob[0]: invalid glob "[a-"`},
+		{"invalid glob escape", `glob: ["a\\"]`, `glob[0]: invalid glob`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := compileMatch(t, test.match)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("compile() = %v, want an error containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestPatternErrorsFailConfigLoad(t *testing.T) {
+	_, err := ParseTenantConfig([]byte("allowed_tenants:\n- prefix: [a]\ndenied_tenants:\n- prefix: [b]\n- regex: [\"a(\"]\n"))
+	if err == nil || !strings.Contains(err.Error(), `denied_tenants[1]: regex[0]: invalid regex "a("`) {
+		t.Errorf("expected the invalid regex to fail loading with the rule name, got %v", err)
+	}
+}
+
+func TestRegexNotCompiled(t *testing.T) {
+	// regexes are only compiled when the config is loaded
+	tm := TenantMatch{RegexMatch: &[]string{"a"}}
+	if tenantMatches("a", tm) {
+		t.Error("expected a regex matcher that wasn't compiled not to match")
+	}
+}
@@ -0,0 +1,169 @@
+package tenant
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+/* how the start, end and tenant id of a range rule are normalized and compared */
+const (
+	// plain string comparison after trimming whitespace (default)
+	RangeTypeLexical = "lexical"
+	// tenant ids are UUIDs, compared by value regardless of case, braces or urn:uuid: prefix
+	RangeTypeUUID = "uuid"
+	// tenant ids are base 10 integers, compared numerically so "999" < "1000"
+	RangeTypeInteger = "integer"
+	// start and end are hex prefixes compared against the leading hex digits of the tenant id, ignoring
+	// case and dashes, e.g. start: "0", end: "3f" covers every tenant id beginning with 00 through 3f
+	RangeTypeHexPrefix = "hex-prefix"
+)
+
+type rangeBound struct {
+	key string
+	num *big.Int
+}
+
+func (r *TenantRangeMatch) rangeType() string {
+	if r.Type == "" {
+		return RangeTypeLexical
+	}
+
+	return r.Type
+}
+
+/* normalize a tenant id or range bound into its comparable form for the range type */
+func normalizeRangeKey(rangeType string, value string) (rangeBound, error) {
+	value = strings.TrimSpace(value)
+
+	switch rangeType {
+	case RangeTypeLexical:
+		return rangeBound{key: value}, nil
+
+	case RangeTypeUUID:
+		u, err := uuid.Parse(value)
+		if err != nil {
+			return rangeBound{}, fmt.Errorf("%q is not a valid uuid: %v", value, err)
+		}
+
+		return rangeBound{key: hex.EncodeToString(u[:])}, nil
+
+	case RangeTypeInteger:
+		n, ok := new(big.Int).SetString(value, 10)
+		if !ok {
+			return rangeBound{}, fmt.Errorf("%q is not a valid integer", value)
+		}
+
+		return rangeBound{key: n.String(), num: n}, nil
+
+	case RangeTypeHexPrefix:
+		key := strings.ToLower(strings.ReplaceAll(value, "-", ""))
+		for _, c := range key {
+			if !strings.ContainsRune("0123456789abcdef", c) {
+				return rangeBound{}, fmt.Errorf("%q is not a hex string", value)
+			}
+		}
+
+		return rangeBound{key: key}, nil
+	}
+
+	return rangeBound{}, fmt.Errorf("unknown range type %q, must be one of %v, %v, %v, %v",
+		rangeType, RangeTypeLexical, RangeTypeUUID, RangeTypeInteger, RangeTypeHexPrefix)
+}
+
+/* compare a tenant id to a range bound, for hex-prefix only the leading digits covered by the bound are compared */
+func compareRangeKey(rangeType string, tenant rangeBound, bound rangeBound) int {
+	switch rangeType {
+	case RangeTypeInteger:
+		return tenant.num.Cmp(bound.num)
+
+	case RangeTypeHexPrefix:
+		key := tenant.key
+		if len(key) > len(bound.key) {
+			key = key[:len(bound.key)]
+		}
+
+		return strings.Compare(key, bound.key)
+	}
+
+	return strings.Compare(tenant.key, bound.key)
+}
+
+/* validate and normalize the range bounds, called once when the config is loaded */
+func (r *TenantRangeMatch) compile() error {
+	start, err := normalizeRangeKey(r.rangeType(), r.Start)
+	if err != nil {
+		return fmt.Errorf("start: %v", err)
+	}
+
+	end, err := normalizeRangeKey(r.rangeType(), r.End)
+	if err != nil {
+		return fmt.Errorf("end: %v", err)
+	}
+
+	if r.rangeType() == RangeTypeHexPrefix && (start.key == "" || end.key == "") {
+		return fmt.Errorf("hex-prefix start and end must not be empty")
+	}
+
+	if compareRangeKey(r.rangeType(), start, end) > 0 {
+		return fmt.Errorf("start %q is after end %q", r.Start, r.End)
+	}
+
+	r.start = &start
+	r.end = &end
+
+	return nil
+}
+
+/*
+normalize a tenant id to compare against the bounds of a range.  For hex-prefix only the leading hex digits count, so
+the rest of the tenant id may be anything, e.g. 3f-team-a is compared as 3f.
+*/
+func normalizeRangeTenant(rangeType string, tenantIdToCheck string) (rangeBound, error) {
+	if rangeType != RangeTypeHexPrefix {
+		return normalizeRangeKey(rangeType, tenantIdToCheck)
+	}
+
+	key := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tenantIdToCheck), "-", ""))
+	end := 0
+	for end < len(key) && strings.IndexByte("0123456789abcdef", key[end]) >= 0 {
+		end++
+	}
+
+	if end == 0 {
+		return rangeBound{}, fmt.Errorf("%q doesn't start with a hex digit", tenantIdToCheck)
+	}
+
+	return rangeBound{key: key[:end]}, nil
+}
+
+func (r *TenantRangeMatch) contains(tenantIdToCheck string) bool {
+	start, end := r.start, r.end
+	if start == nil || end == nil {
+		// range was not loaded through ParseTenantConfig, normalize without modifying the shared config
+		compiled := TenantRangeMatch{Type: r.Type, Start: r.Start, End: r.End}
+		if err := compiled.compile(); err != nil {
+			return false
+		}
+		start, end = compiled.start, compiled.end
+	}
+
+	tenant, err := normalizeRangeTenant(r.rangeType(), tenantIdToCheck)
+	if err != nil {
+		// tenant id isn't the right type for this range
+		return false
+	}
+
+	if compareRangeKey(r.rangeType(), tenant, *start) < 0 {
+		return false
+	}
+
+	if compareRangeKey(r.rangeType(), tenant, *end) > 0 {
+		return false
+	}
+
+	return true
+}
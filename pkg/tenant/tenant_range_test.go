@@ -0,0 +1,118 @@
+package tenant
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRangeContains(t *testing.T) {
+	tests := []struct {
+		name     string
+		r        TenantRangeMatch
+		tenantId string
+		contains bool
+	}{
+		// lexical
+		{"lexical inside", TenantRangeMatch{Start: "b", End: "d"}, "c", true},
+		{"lexical bounds are inclusive", TenantRangeMatch{Start: "b", End: "d"}, "d", true},
+		{"lexical after end", TenantRangeMatch{Start: "b", End: "d"}, "da", false},
+		{"lexical is case sensitive", TenantRangeMatch{Start: "b", End: "d"}, "C", false},
+		{"lexical trims bounds", TenantRangeMatch{Start: " b", End: "d\t"}, "d", true},
+		{"lexical trims tenant ids", TenantRangeMatch{Start: "b", End: "d"}, " c ", true},
+
+		// uuid
+		{"uuid inside", TenantRangeMatch{Type: RangeTypeUUID, Start: "00000000-0000-0000-0000-000000000000", End: "3fffffff-ffff-ffff-ffff-ffffffffffff"}, "12345678-1234-1234-1234-123456789abc", true},
+		{"uuid upper case", TenantRangeMatch{Type: RangeTypeUUID, Start: "a0000000-0000-0000-0000-000000000000", End: "bfffffff-ffff-ffff-ffff-ffffffffffff"}, "ABCDEF01-1234-1234-1234-123456789ABC", true},
+		{"uuid upper case bounds", TenantRangeMatch{Type: RangeTypeUUID, Start: "A0000000-0000-0000-0000-000000000000", End: "BFFFFFFF-FFFF-FFFF-FFFF-FFFFFFFFFFFF"}, "abcdef01-1234-1234-1234-123456789abc", true},
+		{"uuid braces", TenantRangeMatch{Type: RangeTypeUUID, Start: "a0000000-0000-0000-0000-000000000000", End: "bfffffff-ffff-ffff-ffff-ffffffffffff"}, "{abcdef01-1234-1234-1234-123456789abc}", true},
+		{"uuid urn", TenantRangeMatch{Type: RangeTypeUUID, Start: "a0000000-0000-0000-0000-000000000000", End: "bfffffff-ffff-ffff-ffff-ffffffffffff"}, "urn:uuid:abcdef01-1234-1234-1234-123456789abc", true},
+		{"uuid without dashes", TenantRangeMatch{Type: RangeTypeUUID, Start: "a0000000-0000-0000-0000-000000000000", End: "bfffffff-ffff-ffff-ffff-ffffffffffff"}, "abcdef01123412341234123456789abc", true},
+		{"uuid whitespace", TenantRangeMatch{Type: RangeTypeUUID, Start: "a0000000-0000-0000-0000-000000000000", End: "bfffffff-ffff-ffff-ffff-ffffffffffff\t"}, " abcdef01-1234-1234-1234-123456789abc\n", true},
+		{"uuid outside", TenantRangeMatch{Type: RangeTypeUUID, Start: "a0000000-0000-0000-0000-000000000000", End: "bfffffff-ffff-ffff-ffff-ffffffffffff"}, "c0000000-0000-0000-0000-000000000000", false},
+		{"uuid not a uuid", TenantRangeMatch{Type: RangeTypeUUID, Start: "00000000-0000-0000-0000-000000000000", End: "ffffffff-ffff-ffff-ffff-ffffffffffff"}, "tenant-a", false},
+
+		// integer
+		{"integer different widths", TenantRangeMatch{Type: RangeTypeInteger, Start: "1", End: "1000"}, "999", true},
+		{"integer not lexical", TenantRangeMatch{Type: RangeTypeInteger, Start: "1", End: "999"}, "1000", false},
+		{"integer leading zeros", TenantRangeMatch{Type: RangeTypeInteger, Start: "10", End: "20"}, "0015", true},
+		{"integer negative", TenantRangeMatch{Type: RangeTypeInteger, Start: "-10", End: "10"}, "-5", true},
+		{"integer below negative start", TenantRangeMatch{Type: RangeTypeInteger, Start: "-10", End: "10"}, "-11", false},
+		{"integer plus sign", TenantRangeMatch{Type: RangeTypeInteger, Start: "1", End: "10"}, "+5", true},
+		{"integer larger than int64", TenantRangeMatch{Type: RangeTypeInteger, Start: "1", End: "100000000000000000000000"}, "99999999999999999999", true},
+		{"integer whitespace", TenantRangeMatch{Type: RangeTypeInteger, Start: "1", End: "10\t"}, " 5 ", true},
+		{"integer not a number", TenantRangeMatch{Type: RangeTypeInteger, Start: "1", End: "10"}, "5a", false},
+
+		// hex-prefix
+		{"hex-prefix inside", TenantRangeMatch{Type: RangeTypeHexPrefix, Start: "0", End: "3f"}, "2a000000-0000-0000-0000-000000000000", true},
+		{"hex-prefix end covers its prefix", TenantRangeMatch{Type: RangeTypeHexPrefix, Start: "0", End: "3f"}, "3fffffff-ffff-ffff-ffff-ffffffffffff", true},
+		{"hex-prefix after end", TenantRangeMatch{Type: RangeTypeHexPrefix, Start: "0", End: "3f"}, "40000000-0000-0000-0000-000000000000", false},
+		{"hex-prefix start", TenantRangeMatch{Type: RangeTypeHexPrefix, Start: "40", End: "7f"}, "40", true},
+		{"hex-prefix before start", TenantRangeMatch{Type: RangeTypeHexPrefix, Start: "40", End: "7f"}, "3fffffff", false},
+		{"hex-prefix upper case", TenantRangeMatch{Type: RangeTypeHexPrefix, Start: "a0", End: "bf"}, "AB000000-0000-0000-0000-000000000000", true},
+		{"hex-prefix upper case bounds", TenantRangeMatch{Type: RangeTypeHexPrefix, Start: "A0", End: "BF"}, "ab", true},
+		{"hex-prefix dashes in bounds", TenantRangeMatch{Type: RangeTypeHexPrefix, Start: "0000-0", End: "3fff-f"}, "3fff-ffff", true},
+		{"hex-prefix dashes in tenant id", TenantRangeMatch{Type: RangeTypeHexPrefix, Start: "1230", End: "123f"}, "123-4", true},
+		{"hex-prefix leading hex digits only", TenantRangeMatch{Type: RangeTypeHexPrefix, Start: "0", End: "3f"}, "3f-team-a", true},
+		{"hex-prefix leading hex digits outside", TenantRangeMatch{Type: RangeTypeHexPrefix, Start: "0", End: "3f"}, "4-team-a", false},
+		{"hex-prefix no hex digits", TenantRangeMatch{Type: RangeTypeHexPrefix, Start: "0", End: "f"}, "tenant-a", false},
+		{"hex-prefix braces", TenantRangeMatch{Type: RangeTypeHexPrefix, Start: "0", End: "f"}, "{abcdef01-1234-1234-1234-123456789abc}", false},
+		{"hex-prefix whitespace", TenantRangeMatch{Type: RangeTypeHexPrefix, Start: "0", End: "3f"}, " 2a ", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.r.compile(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := test.r.contains(test.tenantId); got != test.contains {
+				t.Errorf("contains(%q) = %v, want %v", test.tenantId, got, test.contains)
+			}
+		})
+	}
+}
+
+func TestRangeCompileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		r    TenantRangeMatch
+		err  string
+	}{
+		{"unknown type", TenantRangeMatch{Type: "semver", Start: "1", End: "2"}, `unknown range type "semver"`},
+		{"start after end", TenantRangeMatch{Start: "d", End: "b"}, `start "d" is after end "b"`},
+		{"invalid uuid", TenantRangeMatch{Type: RangeTypeUUID, Start: "00000000-0000-0000-0000", End: "ffffffff-ffff-ffff-ffff-ffffffffffff"}, "start: \"00000000-0000-0000-0000\" is not a valid uuid"},
+		{"uuid start after end", TenantRangeMatch{Type: RangeTypeUUID, Start: "ffffffff-ffff-ffff-ffff-ffffffffffff", End: "00000000-0000-0000-0000-000000000000"}, "is after end"},
+		{"invalid integer", TenantRangeMatch{Type: RangeTypeInteger, Start: "1", End: "1e3"}, `end: "1e3" is not a valid integer`},
+		{"integer start after end", TenantRangeMatch{Type: RangeTypeInteger, Start: "1000", End: "999"}, "is after end"},
+		{"invalid hex", TenantRangeMatch{Type: RangeTypeHexPrefix, Start: "0", End: "3g"}, `end: "3g" is not a hex string`},
+		{"empty hex", TenantRangeMatch{Type: RangeTypeHexPrefix, Start: "", End: "3f"}, "must not be empty"},
+		{"hex start after end", TenantRangeMatch{Type: RangeTypeHexPrefix, Start: "4", End: "3f"}, "is after end"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.r.compile()
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestRangeErrorsFailConfigLoad(t *testing.T) {
+	_, err := ParseTenantConfig([]byte("allowed_tenants:\n- range:\n  - type: integer\n    start: \"10\"\n    end: \"x\"\n"))
+	if err == nil || !strings.Contains(err.Error(), "allowed_tenants[0]") {
+		t.Errorf("expected the invalid range to fail loading with the rule name, got %v", err)
+	}
+}
+
+func TestRangeNotCompiled(t *testing.T) {
+	// ranges built in code rather than loaded are normalized on first use
+	r := TenantRangeMatch{Type: RangeTypeInteger, Start: "1", End: "1000"}
+	if !r.contains("999") {
+		t.Error("expected an uncompiled range to match")
+	}
+	if r.start != nil {
+		t.Error("expected contains not to modify an uncompiled range")
+	}
+}
@@ -0,0 +1,36 @@
+package tenant
+
+import (
+	"fmt"
+)
+
+/* another shard and the tenants it owns, only used as a redirect hint so the matcher can be approximate */
+type ShardRef struct {
+	Name string `yaml:"name" json:"name"`
+	// where clients should connect to reach the shard, e.g. hellogrpc-b.example.com:443
+	Address     string `yaml:"address,omitempty" json:"address,omitempty"`
+	TenantMatch `yaml:",inline"`
+}
+
+func (s *ShardRef) compile() error {
+	if s.Name == "" {
+		return fmt.Errorf("shard name is required")
+	}
+
+	return s.TenantMatch.compile()
+}
+
+/* OwningShard returns the first other shard that owns the tenant, or nil if none is known */
+func (t *TenantConfig) OwningShard(tenantId string) *ShardRef {
+	for i := range t.Shards {
+		if t.Shards[i].Name == t.Shard {
+			continue
+		}
+
+		if tenantMatches(tenantId, t.Shards[i].TenantMatch) {
+			return &t.Shards[i]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLoadTenantConfigFailurePolicy(t *testing.T) {
+	sources := []struct {
+		name   string
+		source *fakeTenantConfigSource
+		err    string
+	}{
+		{"fetch error", &fakeTenantConfigSource{err: fmt.Errorf("unreachable")}, "unable to load tenantConfig: unreachable"},
+		{"invalid config", &fakeTenantConfigSource{yaml: []byte("allowed_tenants:\n- bogus: [\n")}, "yaml"},
+	}
+
+	policies := []struct {
+		policy   string
+		fallback string
+		message  string
+		// nil fallback config
+		refuse  bool
+		accepts bool
+		serving bool
+	}{
+		{FailurePolicyFailOpen, FailurePolicyFailOpen, "accept all tenants", false, true, true},
+		{FailurePolicyFailClosed, FailurePolicyFailClosed, "reject all tenants", false, false, false},
+		{FailurePolicyRefuseToStart, "", "refusing to start", true, false, false},
+		// ValidateFailurePolicy rejects anything else before it gets here, fail open as before failure policies
+		{"", FailurePolicyFailOpen, "accept all tenants", false, true, true},
+	}
+
+	for _, source := range sources {
+		for _, policy := range policies {
+			t.Run(source.name+"/"+policy.policy, func(t *testing.T) {
+				config, err := LoadTenantConfigFromSource(context.Background(), source.source, policy.policy)
+				if err == nil {
+					t.Fatalf("LoadTenantConfigFromSource() error = nil, want an error")
+				}
+
+				if !strings.Contains(err.Error(), source.err) || !strings.HasSuffix(err.Error(), policy.message) {
+					t.Errorf("LoadTenantConfigFromSource() error = %q, want it to contain %q and end with %q", err, source.err, policy.message)
+				}
+
+				if policy.refuse {
+					if config != nil {
+						t.Errorf("LoadTenantConfigFromSource() = %+v, want no fallback config", config)
+					}
+					return
+				}
+
+				if config == nil {
+					t.Fatalf("LoadTenantConfigFromSource() = nil, want a %v fallback config", policy.fallback)
+				}
+
+				if got := config.Fallback(); got != policy.fallback {
+					t.Errorf("Fallback() = %q, want %q", got, policy.fallback)
+				}
+
+				for _, tenantId := range []string{"tenant-a", "", "*"} {
+					if got := config.CheckTenantId(tenantId); got != policy.accepts {
+						t.Errorf("CheckTenantId(%q) = %v, want %v", tenantId, got, policy.accepts)
+					}
+				}
+
+				if got := NewTenantConfigStore(config).Serving(); got != policy.serving {
+					t.Errorf("Serving() = %v, want %v", got, policy.serving)
+				}
+			})
+		}
+	}
+}
+
+func TestLoadTenantConfigIgnoresPolicyOnSuccess(t *testing.T) {
+	source := &fakeTenantConfigSource{yaml: []byte("allowed_tenants:\n- exactMatch: [tenant-a]\n")}
+
+	for _, policy := range []string{FailurePolicyFailOpen, FailurePolicyFailClosed, FailurePolicyRefuseToStart} {
+		config, err := LoadTenantConfigFromSource(context.Background(), source, policy)
+		if err != nil {
+			t.Fatalf("%v: LoadTenantConfigFromSource() error = %v", policy, err)
+		}
+
+		if config.Fallback() != "" {
+			t.Errorf("%v: Fallback() = %q, want none for a loaded config", policy, config.Fallback())
+		}
+
+		if !config.CheckTenantId("tenant-a") || config.CheckTenantId("tenant-b") {
+			t.Errorf("%v: loaded config isn't the one from the source", policy)
+		}
+
+		if !NewTenantConfigStore(config).Serving() {
+			t.Errorf("%v: Serving() = false for a loaded config", policy)
+		}
+	}
+}
+
+func TestDenyAllTenantConfig(t *testing.T) {
+	config := makeDenyAllTenantConfig()
+
+	decision := config.Evaluate("tenant-a")
+	if decision.Allowed {
+		t.Errorf("Evaluate() allowed a tenant with the fail-closed config")
+	}
+
+	// a method policy can't let a tenant through either
+	if config.EvaluateMethod("/helloworld.Greeter/SayHello", "tenant-a").Allowed {
+		t.Errorf("EvaluateMethod() allowed a tenant with the fail-closed config")
+	}
+
+	store := NewTenantConfigStore(makeDefaultTenantConfig())
+	if !store.Serving() {
+		t.Errorf("Serving() = false with the fail-open config")
+	}
+
+	store.Set(config)
+	if store.Serving() {
+		t.Errorf("Serving() = true with the fail-closed config")
+	}
+}
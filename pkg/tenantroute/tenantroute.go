@@ -0,0 +1,392 @@
+// Package tenantroute translates per-shard tenant configs into header based routing rules for load balancers
+// and gateways that sit in front of the shards.
+package tenantroute
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	tenant "helloworld/pkg/tenant"
+)
+
+const DefaultTenantHeader = "X-Tenant-Id"
+
+/* a single condition on the tenant header, exactly one of the match fields is set */
+type HeaderMatch struct {
+	Exact   string
+	Prefix  string
+	Suffix  string
+	Regex   string
+	Present bool
+
+	// the header must NOT match
+	Invert bool
+}
+
+/* all of the header matches must hold */
+type Conjunction []HeaderMatch
+
+/* the routing rules for one shard, a tenant is routed to the shard if any conjunction holds */
+type ShardRoute struct {
+	Name    string
+	Config  string
+	Matches []Conjunction
+}
+
+type Options struct {
+	// also emit upper case variants of UUID prefixes, header matching in load balancers is case sensitive while the
+	// shards accept UUIDs in any case
+	UppercaseUUIDs bool
+}
+
+/* a shard name and the path to its tenant config */
+type Shard struct {
+	Name   string
+	Config string
+}
+
+/* parse NAME=CONFIG shard flags */
+func ParseShard(value string) (Shard, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Shard{}, fmt.Errorf("invalid shard %q, expected NAME=CONFIG", value)
+	}
+
+	return Shard{Name: parts[0], Config: parts[1]}, nil
+}
+
+/* a repeatable NAME=CONFIG flag */
+type ShardFlags []Shard
+
+func (s *ShardFlags) String() string {
+	values := make([]string, 0, len(*s))
+	for _, shard := range *s {
+		values = append(values, shard.Name+"="+shard.Config)
+	}
+
+	return strings.Join(values, ",")
+}
+
+func (s *ShardFlags) Set(value string) error {
+	shard, err := ParseShard(value)
+	if err != nil {
+		return err
+	}
+
+	*s = append(*s, shard)
+
+	return nil
+}
+
+/*
+LoadShardRoutes loads each shard's tenant config and converts it to routes.  Warnings are returned for rules that
+can't be expressed exactly, where the generated routes may send some tenants to a shard that will reject them.
+*/
+func LoadShardRoutes(shards []Shard, opts Options) ([]ShardRoute, []string, error) {
+	routes := make([]ShardRoute, 0, len(shards))
+	warnings := make([]string, 0)
+
+	for _, shard := range shards {
+		t, err := tenant.LoadTenantConfigFile(shard.Config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("shard %v: %v", shard.Name, err)
+		}
+
+		route, shardWarnings, err := ShardRoutes(shard.Name, t, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("shard %v (%v): %v", shard.Name, shard.Config, err)
+		}
+		route.Config = shard.Config
+
+		for _, w := range shardWarnings {
+			warnings = append(warnings, fmt.Sprintf("shard %v (%v): %v", shard.Name, shard.Config, w))
+		}
+
+		routes = append(routes, route)
+	}
+
+	return routes, warnings, nil
+}
+
+/*
+ShardRoutes converts a tenant config to header matches, applying the config's precedence so tenants a rule denies
+aren't routed by a rule evaluated after it.  Rules on the UUID keyspace are turned into the minimal set of UUID
+prefixes, which like the hand written rules also match other tenant ids that happen to start with them.  Other allow
+rules are translated directly, minus the tenants of the deny rules evaluated before them.  Warnings are returned
+where that can't be done exactly.
+*/
+func ShardRoutes(name string, t *tenant.TenantConfig, opts Options) (ShardRoute, []string, error) {
+	route := ShardRoute{Name: name, Matches: make([]Conjunction, 0)}
+	warnings := make([]string, 0)
+
+	// UUID prefix routes keyed by prefix so they can be listed in keyspace order, ahead of the others in rule order
+	uuidRoutes := make(map[string]Conjunction)
+	otherRoutes := make([]Conjunction, 0)
+
+	// the UUID keyspace decided by the rules so far, and the part of it that was denied
+	decided := make([]tenant.KeyInterval, 0)
+	denied := make([]tenant.KeyInterval, 0)
+	// the other deny rules so far, as header matches every later allow route must not match
+	exclusions := Conjunction{}
+	// deny rules so far that can't be turned into exclusions
+	unexpressed := make([]string, 0)
+
+	for _, rule := range t.EvaluationOrder() {
+		matches, matchErr := matchConjunctions(rule.Match)
+		intervals, isUUID := rule.Match.UUIDIntervals()
+		if isUUID && matchErr == nil && isCatchAll(matches) {
+			// "*" is on the keyspace but also matches every other tenant id
+			isUUID = false
+		}
+
+		if rule.Action == tenant.ActionDeny {
+			if isUUID {
+				denied = tenant.MergeIntervals(append(denied, intervals...))
+				decided = tenant.MergeIntervals(append(decided, intervals...))
+				continue
+			}
+
+			inverted, ok := invertConjunctions(matches)
+			if matchErr != nil || !ok {
+				unexpressed = append(unexpressed, rule.Name)
+				continue
+			}
+
+			exclusions = append(exclusions, inverted...)
+			if intervals != nil {
+				decided = tenant.MergeIntervals(append(decided, intervals...))
+			}
+			continue
+		}
+
+		for _, deny := range unexpressed {
+			warnings = append(warnings, fmt.Sprintf("%v: deny rule can't be expressed as a route, tenants it denies may still be routed to this shard by %v and the allow rules after it", deny, rule.Name))
+		}
+		unexpressed = unexpressed[:0]
+
+		if isUUID {
+			for _, interval := range tenant.SubtractIntervals(intervals, decided) {
+				for _, prefix := range uuidPrefixes(interval, opts) {
+					m := HeaderMatch{Prefix: prefix}
+					if prefix == "" {
+						m = HeaderMatch{Present: true}
+					}
+
+					uuidRoutes[prefix] = append(Conjunction{m}, exclusions...)
+				}
+			}
+
+			decided = tenant.MergeIntervals(append(decided, intervals...))
+			continue
+		}
+
+		if matchErr != nil {
+			return ShardRoute{}, nil, fmt.Errorf("%v: %v", rule.Name, matchErr)
+		}
+
+		// the denied UUIDs are excluded by prefix, whatever the rest of the tenant id
+		uuidExclusions := Conjunction{}
+		for _, interval := range denied {
+			for _, prefix := range interval.UUIDPrefixes() {
+				uuidExclusions = append(uuidExclusions, HeaderMatch{Regex: "(?i)" + regexp.QuoteMeta(prefix) + ".*", Invert: true})
+			}
+		}
+		if len(uuidExclusions) > 0 {
+			warnings = append(warnings, fmt.Sprintf("%v: routed without the tenant ids starting with a denied UUID prefix, "+
+				"so tenants it allows that aren't UUIDs but share such a prefix aren't routed to this shard, and denied UUIDs "+
+				"written with braces, urn:uuid: or without dashes still are", rule.Name))
+		}
+
+		for _, c := range matches {
+			c = append(append(append(Conjunction{}, c...), exclusions...), uuidExclusions...)
+			otherRoutes = append(otherRoutes, c)
+		}
+
+		if intervals != nil {
+			decided = tenant.MergeIntervals(append(decided, intervals...))
+		}
+	}
+
+	prefixes := make([]string, 0, len(uuidRoutes))
+	for prefix := range uuidRoutes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	for _, prefix := range prefixes {
+		route.Matches = append(route.Matches, uuidRoutes[prefix])
+	}
+	route.Matches = append(route.Matches, otherRoutes...)
+
+	return route, warnings, nil
+}
+
+/* the lower case UUID prefixes of the interval, plus upper case variants if asked for */
+func uuidPrefixes(interval tenant.KeyInterval, opts Options) []string {
+	prefixes := make([]string, 0)
+	for _, prefix := range interval.UUIDPrefixes() {
+		prefixes = append(prefixes, prefix)
+
+		if upper := strings.ToUpper(prefix); opts.UppercaseUUIDs && upper != prefix {
+			prefixes = append(prefixes, upper)
+		}
+	}
+
+	return prefixes
+}
+
+/* does one of the alternatives match every tenant */
+func isCatchAll(matches []Conjunction) bool {
+	for _, c := range matches {
+		if len(c) == 1 && c[0].Present && !c[0].Invert {
+			return true
+		}
+	}
+
+	return false
+}
+
+/* not (a or b) == (not a) and (not b), only possible when each alternative is a single header match */
+func invertConjunctions(matches []Conjunction) (Conjunction, bool) {
+	inverted := Conjunction{}
+	for _, c := range matches {
+		if len(c) != 1 {
+			return nil, false
+		}
+
+		m := c[0]
+		m.Invert = !m.Invert
+		inverted = append(inverted, m)
+	}
+
+	return inverted, true
+}
+
+/* convert a matcher to OR-of-AND header matches */
+func matchConjunctions(tm tenant.TenantMatch) ([]Conjunction, error) {
+	result := make([]Conjunction, 0)
+
+	switch {
+	case tm.ExactMatch != nil:
+		for _, v := range *tm.ExactMatch {
+			if v == "*" {
+				return []Conjunction{{{Present: true}}}, nil
+			}
+			result = append(result, Conjunction{{Exact: v}})
+		}
+
+	case tm.PrefixMatch != nil:
+		for _, v := range *tm.PrefixMatch {
+			if v == "" {
+				return []Conjunction{{{Present: true}}}, nil
+			}
+			result = append(result, Conjunction{{Prefix: v}})
+		}
+
+	case tm.SuffixMatch != nil:
+		for _, v := range *tm.SuffixMatch {
+			result = append(result, Conjunction{{Suffix: v}})
+		}
+
+	case tm.RegexMatch != nil:
+		for _, v := range *tm.RegexMatch {
+			result = append(result, Conjunction{{Regex: v}})
+		}
+
+	case tm.GlobMatch != nil:
+		for _, v := range *tm.GlobMatch {
+			result = append(result, Conjunction{{Regex: GlobToRegex(v)}})
+		}
+
+	case tm.RangeMatch != nil:
+		return nil, fmt.Errorf("only uuid and hex-prefix ranges can be converted to routes")
+
+	case tm.AnyOf != nil:
+		for _, m := range *tm.AnyOf {
+			matches, err := matchConjunctions(m)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, matches...)
+		}
+
+	case tm.AllOf != nil:
+		// distribute AND over OR
+		result = []Conjunction{{}}
+		for _, m := range *tm.AllOf {
+			matches, err := matchConjunctions(m)
+			if err != nil {
+				return nil, err
+			}
+
+			product := make([]Conjunction, 0, len(result)*len(matches))
+			for _, left := range result {
+				for _, right := range matches {
+					c := append(append(Conjunction{}, left...), right...)
+					product = append(product, c)
+				}
+			}
+			result = product
+		}
+
+	case tm.Not != nil:
+		matches, err := matchConjunctions(*tm.Not)
+		if err != nil {
+			return nil, err
+		}
+
+		inverted, ok := invertConjunctions(matches)
+		if !ok {
+			return nil, fmt.Errorf("not can only be converted to a route when it negates single matchers")
+		}
+		result = append(result, inverted)
+
+	default:
+		return nil, fmt.Errorf("no matcher set")
+	}
+
+	return result, nil
+}
+
+/* translate a path.Match glob into an RE2 regex that matches the whole value */
+func GlobToRegex(glob string) string {
+	var b strings.Builder
+	inClass := false
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+
+		switch {
+		case inClass:
+			if c == ']' {
+				inClass = false
+			}
+			if c == '\\' && i+1 < len(glob) {
+				b.WriteByte(c)
+				i++
+				c = glob[i]
+			}
+			b.WriteByte(c)
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			inClass = true
+			b.WriteByte('[')
+			if i+1 < len(glob) && glob[i+1] == '^' {
+				b.WriteByte('^')
+				i++
+			}
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,256 @@
+package tenantroute
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	tenant "helloworld/pkg/tenant"
+)
+
+/* evaluate a header match the way a load balancer would, regexes match the whole value */
+func (m HeaderMatch) matches(value string) bool {
+	var matched bool
+
+	switch {
+	case m.Present:
+		matched = true
+	case m.Exact != "":
+		matched = value == m.Exact
+	case m.Prefix != "":
+		matched = strings.HasPrefix(value, m.Prefix)
+	case m.Suffix != "":
+		matched = strings.HasSuffix(value, m.Suffix)
+	case m.Regex != "":
+		matched = regexp.MustCompile("^(?:" + m.Regex + ")$").MatchString(value)
+	}
+
+	return matched != m.Invert
+}
+
+func (r ShardRoute) routes(tenantId string) bool {
+	for _, c := range r.Matches {
+		all := true
+		for _, m := range c {
+			if !m.matches(tenantId) {
+				all = false
+				break
+			}
+		}
+
+		if all {
+			return true
+		}
+	}
+
+	return false
+}
+
+var sampleTenants = []string{
+	"00000000-0000-0000-0000-000000000000",
+	"0fffffff-ffff-ffff-ffff-ffffffffffff",
+	"10000000-0000-0000-0000-000000000000",
+	"1fffffff-ffff-ffff-ffff-ffffffffffff",
+	"20000000-0000-0000-0000-000000000001",
+	"3fffffff-ffff-ffff-ffff-ffffffffffff",
+	"40000000-0000-0000-0000-000000000000",
+	"8aaaaaaa-0000-0000-0000-000000000000",
+	"ffffffff-ffff-ffff-ffff-ffffffffffff",
+	"acme-eu",
+	"acme-us",
+	"acme-test",
+	"other",
+}
+
+func TestShardRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		// the routes may leave out tenants the shard accepts, but never include one it rejects
+		inexact bool
+		// warnings containing these, in order
+		warnings []string
+	}{
+		{
+			name: "uuid ranges only",
+			config: `
+allowed_tenants:
+- range: [{type: uuid, start: 00000000-0000-0000-0000-000000000000, end: 3fffffff-ffff-ffff-ffff-ffffffffffff}]
+denied_tenants:
+- range: [{type: uuid, start: 10000000-0000-0000-0000-000000000000, end: 1fffffff-ffff-ffff-ffff-ffffffffffff}]
+`,
+		},
+		{
+			name: "deny-overrides subtracts denied uuids from a regex allow",
+			config: `
+allowed_tenants:
+- regex: ["acme-.*", "[0-9a-f-]{36}"]
+denied_tenants:
+- range: [{type: uuid, start: 10000000-0000-0000-0000-000000000000, end: 1fffffff-ffff-ffff-ffff-ffffffffffff}]
+`,
+			inexact:  true,
+			warnings: []string{"allowed_tenants[0]: routed without the tenant ids starting with a denied UUID prefix"},
+		},
+		{
+			name: "allow everything but a uuid range keeps non-uuid tenants",
+			config: `
+allowed_tenants:
+- exactMatch: ["*"]
+denied_tenants:
+- range: [{type: uuid, start: 10000000-0000-0000-0000-000000000000, end: 1fffffff-ffff-ffff-ffff-ffffffffffff}]
+`,
+			inexact:  true,
+			warnings: []string{"allowed_tenants[0]: routed without"},
+		},
+		{
+			name: "deny suffix excluded from a uuid range and a glob",
+			config: `
+allowed_tenants:
+- range: [{type: hex-prefix, start: "0", end: "3"}]
+- glob: ["acme-*"]
+denied_tenants:
+- suffix: ["-test", "0000"]
+`,
+		},
+		{
+			name: "allow-overrides ignores later denies",
+			config: `
+precedence: allow-overrides
+allowed_tenants:
+- glob: ["acme-*"]
+- prefix: ["4"]
+denied_tenants:
+- suffix: ["-eu"]
+- range: [{type: uuid, start: 40000000-0000-0000-0000-000000000000, end: ffffffff-ffff-ffff-ffff-ffffffffffff}]
+`,
+		},
+		{
+			name: "first-match only excludes earlier denies",
+			config: `
+precedence: first-match
+rules:
+- action: deny
+  exactMatch: ["acme-us"]
+- action: allow
+  prefix: ["acme-"]
+- action: deny
+  prefix: ["acme-"]
+- action: allow
+  any_of: [{exactMatch: ["other"]}, {prefix: ["acme-test"]}]
+`,
+		},
+		{
+			name: "deny that can't be expressed",
+			config: `
+allowed_tenants:
+- prefix: ["acme-"]
+- prefix: ["o"]
+denied_tenants:
+- all_of: [{prefix: ["acme-"]}, {suffix: ["-us"]}]
+`,
+			warnings: []string{"denied_tenants[0]: deny rule can't be expressed as a route, tenants it denies may still be routed to this shard by allowed_tenants[0]"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := tenant.ParseTenantConfig([]byte(test.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			route, warnings, err := ShardRoutes("a", config, Options{})
+			if err != nil {
+				t.Fatalf("ShardRoutes() = %v", err)
+			}
+
+			if len(warnings) != len(test.warnings) {
+				t.Errorf("warnings = %q, want %d", warnings, len(test.warnings))
+			}
+			for i := range test.warnings {
+				if i < len(warnings) && !strings.Contains(warnings[i], test.warnings[i]) {
+					t.Errorf("warnings[%d] = %q, want it to contain %q", i, warnings[i], test.warnings[i])
+				}
+			}
+
+			for _, id := range sampleTenants {
+				routed, allowed := route.routes(id), config.CheckTenantId(id)
+
+				if routed && !allowed {
+					if len(test.warnings) > 0 && !test.inexact {
+						// an unexpressed deny is the documented exception
+						continue
+					}
+					t.Errorf("%v is routed but the shard rejects it", id)
+				}
+
+				if allowed && !routed && !test.inexact {
+					t.Errorf("%v is accepted but not routed", id)
+				}
+			}
+		})
+	}
+}
+
+func TestShardRoutesCatchAllExclusions(t *testing.T) {
+	config, err := tenant.ParseTenantConfig([]byte(`
+allowed_tenants:
+- exactMatch: ["*"]
+denied_tenants:
+- range: [{type: uuid, start: 10000000-0000-0000-0000-000000000000, end: 1fffffff-ffff-ffff-ffff-ffffffffffff}]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route, _, err := ShardRoutes("a", config, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id, want := range map[string]bool{
+		"other":                                true,
+		"acme-eu":                              true,
+		"00000000-0000-0000-0000-000000000000": true,
+		"10000000-0000-0000-0000-000000000000": false,
+		"1FFFFFFF-FFFF-FFFF-FFFF-FFFFFFFFFFFF": false,
+		"20000000-0000-0000-0000-000000000000": true,
+		// not a UUID, but shares the denied prefix
+		"1com": false,
+	} {
+		if got := route.routes(id); got != want {
+			t.Errorf("routes(%v) = %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestGlobToRegex(t *testing.T) {
+	tests := []struct {
+		glob    string
+		matches []string
+		misses  []string
+	}{
+		{"acme-*", []string{"acme-", "acme-eu"}, []string{"acme", "acme-a/b"}},
+		{"a?c", []string{"abc"}, []string{"ac", "abbc"}},
+		{"[a-c]x", []string{"bx"}, []string{"dx"}},
+		{"[^a-c]x", []string{"dx"}, []string{"ax"}},
+		{"a.b+c", []string{"a.b+c"}, []string{"axbbc"}},
+		{`a\*`, []string{"a*"}, []string{"ab"}},
+	}
+
+	for _, test := range tests {
+		re := regexp.MustCompile("^(?:" + GlobToRegex(test.glob) + ")$")
+
+		for _, v := range test.matches {
+			if !re.MatchString(v) {
+				t.Errorf("GlobToRegex(%q) = %q doesn't match %q", test.glob, re, v)
+			}
+		}
+
+		for _, v := range test.misses {
+			if re.MatchString(v) {
+				t.Errorf("GlobToRegex(%q) = %q matches %q", test.glob, re, v)
+			}
+		}
+	}
+}
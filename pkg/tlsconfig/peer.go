@@ -0,0 +1,84 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/x509"
+	"strings"
+
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+/* who the client is according to its verified certificate */
+type PeerIdentity struct {
+	SPIFFEID   string
+	DNSNames   []string
+	CommonName string
+}
+
+/* PeerIdentityFromContext returns the identity of an mTLS client, false if it didn't present a verified certificate */
+func PeerIdentityFromContext(ctx context.Context) (PeerIdentity, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return PeerIdentity{}, false
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return PeerIdentity{}, false
+	}
+
+	return peerIdentity(tlsInfo.State.VerifiedChains[0][0]), true
+}
+
+func peerIdentity(cert *x509.Certificate) PeerIdentity {
+	identity := PeerIdentity{
+		DNSNames:   cert.DNSNames,
+		CommonName: cert.Subject.CommonName,
+	}
+
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			identity.SPIFFEID = uri.String()
+			break
+		}
+	}
+
+	return identity
+}
+
+/* add the peer identity to the request's log fields */
+func tagPeerIdentity(ctx context.Context) {
+	identity, ok := PeerIdentityFromContext(ctx)
+	if !ok {
+		return
+	}
+
+	tags := grpc_ctxtags.Extract(ctx)
+	if identity.SPIFFEID != "" {
+		tags.Set("peer.spiffe_id", identity.SPIFFEID)
+	}
+
+	if len(identity.DNSNames) > 0 {
+		tags.Set("peer.dns", strings.Join(identity.DNSNames, ","))
+	}
+
+	if identity.CommonName != "" {
+		tags.Set("peer.cn", identity.CommonName)
+	}
+}
+
+/* PeerIdentityUnaryInterceptor logs the mTLS peer identity with the request, it has to run after the ctxtags interceptor */
+func PeerIdentityUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	tagPeerIdentity(ctx)
+
+	return handler(ctx, req)
+}
+
+func PeerIdentityStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	tagPeerIdentity(ss.Context())
+
+	return handler(srv, ss)
+}
@@ -0,0 +1,152 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return u
+}
+
+/* a request context from a TLS peer with the verified chains */
+func tlsPeerContext(chains [][]*x509.Certificate) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr:     &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50051},
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{VerifiedChains: chains}},
+	})
+}
+
+func TestPeerIdentityFromContext(t *testing.T) {
+	spiffe := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "tenant-a"},
+		DNSNames: []string{"tenant-a.example.com", "a.example.com"},
+		URIs: []*url.URL{
+			mustParseURL(t, "https://example.com/tenant-a"),
+			mustParseURL(t, "spiffe://example.org/tenant/tenant-a"),
+			mustParseURL(t, "spiffe://example.org/tenant/other"),
+		},
+	}
+	cnOnly := &x509.Certificate{Subject: pkix.Name{CommonName: "tenant-b"}}
+	intermediate := &x509.Certificate{Subject: pkix.Name{CommonName: "intermediate"}}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want PeerIdentity
+		ok   bool
+	}{
+		{
+			name: "SANs and CN",
+			ctx:  tlsPeerContext([][]*x509.Certificate{{spiffe, intermediate}}),
+			want: PeerIdentity{
+				SPIFFEID:   "spiffe://example.org/tenant/tenant-a",
+				DNSNames:   []string{"tenant-a.example.com", "a.example.com"},
+				CommonName: "tenant-a",
+			},
+			ok: true,
+		},
+		{
+			name: "CN only",
+			ctx:  tlsPeerContext([][]*x509.Certificate{{cnOnly}}),
+			want: PeerIdentity{CommonName: "tenant-b"},
+			ok:   true,
+		},
+		{
+			name: "first verified chain",
+			ctx:  tlsPeerContext([][]*x509.Certificate{{cnOnly}, {spiffe}}),
+			want: PeerIdentity{CommonName: "tenant-b"},
+			ok:   true,
+		},
+		{
+			name: "no peer certificate",
+			ctx:  tlsPeerContext(nil),
+		},
+		{
+			name: "empty chain",
+			ctx:  tlsPeerContext([][]*x509.Certificate{{}}),
+		},
+		{
+			name: "not TLS",
+			ctx:  peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{}}),
+		},
+		{
+			name: "no peer",
+			ctx:  context.Background(),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := PeerIdentityFromContext(test.ctx)
+			if ok != test.ok || !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %+v, %v, got %+v, %v", test.want, test.ok, got, ok)
+			}
+		})
+	}
+}
+
+func TestPeerIdentityInterceptor(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "tenant-a"},
+		DNSNames: []string{"tenant-a.example.com", "a.example.com"},
+		URIs:     []*url.URL{mustParseURL(t, "spiffe://example.org/tenant/tenant-a")},
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want map[string]interface{}
+	}{
+		{
+			name: "mTLS client",
+			ctx:  tlsPeerContext([][]*x509.Certificate{{cert}}),
+			want: map[string]interface{}{
+				"peer.spiffe_id": "spiffe://example.org/tenant/tenant-a",
+				"peer.dns":       "tenant-a.example.com,a.example.com",
+				"peer.cn":        "tenant-a",
+			},
+		},
+		{
+			name: "no client certificate",
+			ctx:  tlsPeerContext(nil),
+			want: map[string]interface{}{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tags := grpc_ctxtags.NewTags()
+			ctx := grpc_ctxtags.SetInContext(test.ctx, tags)
+
+			_, err := PeerIdentityUnaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(tags.Values(), test.want) {
+				t.Errorf("expected tags %v, got %v", test.want, tags.Values())
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package tlsconfig
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultCertReloadInterval = 30 * time.Second
+)
+
+var (
+	certMetricsOnce sync.Once
+	certMetrics     *certificateMetrics
+)
+
+type certificateMetrics struct {
+	expiry  prometheus.GaugeVec
+	reloads prometheus.CounterVec
+}
+
+func (metrics *certificateMetrics) init() error {
+	metrics.expiry = *prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tls_certificate_expiry_timestamp_seconds",
+			Help: "NotAfter of the loaded serving certificate",
+		},
+		[]string{"cert"},
+	)
+
+	metrics.reloads = *prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tls_certificate_reloads_total",
+			Help: "Number of serving certificate reloads by result",
+		},
+		[]string{"cert", "result"},
+	)
+
+	for _, c := range []prometheus.Collector{metrics.expiry, metrics.reloads} {
+		if err := prometheus.Register(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/* the metrics are shared by every reloader, e.g. one per certificate when serving several */
+func getCertificateMetrics(logger *zap.Logger) *certificateMetrics {
+	certMetricsOnce.Do(func() {
+		certMetrics = &certificateMetrics{}
+		if err := certMetrics.init(); err != nil {
+			logger.Warn("Unable to register certificate metrics", zap.Error(err))
+		}
+	})
+
+	return certMetrics
+}
+
+/*
+serves a keypair from disk and picks up renewals, e.g. cert-manager updating the mounted Secret.  New handshakes get
+the new keypair through GetCertificate, connections that are already open keep the one they started with.
+*/
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	logger   *zap.Logger
+	metrics  *certificateMetrics
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	certPEM  []byte
+	keyPEM   []byte
+	notAfter time.Time
+	// the last reload error logged, so a broken keypair is logged once rather than every interval
+	lastError string
+}
+
+/* NewCertReloader loads the keypair, failing if it can't */
+func NewCertReloader(certFile string, keyFile string, logger *zap.Logger) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   logger,
+		metrics:  getCertificateMetrics(logger),
+	}
+
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
+
+func (r *CertReloader) Certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert
+}
+
+func (r *CertReloader) NotAfter() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.notAfter
+}
+
+/* Valid returns an error if the certificate expired or isn't valid yet */
+func (r *CertReloader) Valid() error {
+	cert := r.Certificate()
+	if cert == nil || cert.Leaf == nil {
+		return fmt.Errorf("no certificate loaded from %v", r.certFile)
+	}
+
+	now := time.Now()
+	if now.After(cert.Leaf.NotAfter) {
+		return fmt.Errorf("certificate %v expired at %v", r.certFile, cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	if now.Before(cert.Leaf.NotBefore) {
+		return fmt.Errorf("certificate %v is not valid before %v", r.certFile, cert.Leaf.NotBefore.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+/* Watch checks the files for changes every interval until the context is done */
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Reload()
+		}
+	}
+}
+
+/*
+Reload loads the keypair again if the files changed, returning true if a new keypair is now served.  A keypair that
+doesn't load (e.g. the cert was written but the key not yet) is logged and the current one is kept.
+*/
+func (r *CertReloader) Reload() (bool, error) {
+	certPEM, err := ioutil.ReadFile(r.certFile)
+	if err != nil {
+		return false, r.failed(fmt.Errorf("unable to read certificate: %v", err))
+	}
+
+	keyPEM, err := ioutil.ReadFile(r.keyFile)
+	if err != nil {
+		return false, r.failed(fmt.Errorf("unable to read key: %v", err))
+	}
+
+	r.mu.RLock()
+	unchanged := bytes.Equal(certPEM, r.certPEM) && bytes.Equal(keyPEM, r.keyPEM)
+	r.mu.RUnlock()
+
+	if unchanged {
+		// nothing changed, or the files were reverted to the current keypair after a bad update
+		r.mu.Lock()
+		r.lastError = ""
+		r.mu.Unlock()
+
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, r.failed(fmt.Errorf("unable to load keypair: %v", err))
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false, r.failed(fmt.Errorf("unable to parse certificate: %v", err))
+	}
+	cert.Leaf = leaf
+
+	r.mu.Lock()
+	first := r.cert == nil
+	r.cert = &cert
+	r.certPEM = certPEM
+	r.keyPEM = keyPEM
+	r.notAfter = leaf.NotAfter
+	r.lastError = ""
+	r.mu.Unlock()
+
+	r.metrics.expiry.WithLabelValues(r.certFile).Set(float64(leaf.NotAfter.Unix()))
+
+	msg := "Rotated TLS certificate"
+	if first {
+		msg = "Loaded TLS certificate"
+	} else {
+		r.metrics.reloads.WithLabelValues(r.certFile, "success").Inc()
+	}
+
+	r.logger.Info(msg,
+		zap.String("cert", r.certFile),
+		zap.String("subject", leaf.Subject.String()),
+		zap.Strings("dnsNames", leaf.DNSNames),
+		zap.String("serial", leaf.SerialNumber.String()),
+		zap.Time("notAfter", leaf.NotAfter),
+	)
+
+	return true, nil
+}
+
+func (r *CertReloader) failed(err error) error {
+	if r.Certificate() == nil {
+		// still loading the first keypair, the caller decides what to do
+		return err
+	}
+
+	r.metrics.reloads.WithLabelValues(r.certFile, "failure").Inc()
+
+	// count every failure but only log each distinct one, a pair that stays broken is retried every interval
+	r.mu.Lock()
+	repeated := err.Error() == r.lastError
+	r.lastError = err.Error()
+	r.mu.Unlock()
+
+	if repeated {
+		return err
+	}
+
+	r.logger.Warn("Unable to reload TLS certificate, keeping the current one",
+		zap.String("cert", r.certFile),
+		zap.String("key", r.keyFile),
+		zap.Error(err),
+	)
+
+	return err
+}
@@ -0,0 +1,166 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+/* a self-signed keypair for the DNS names, as PEM */
+func newTestKeypair(t *testing.T, commonName string, dnsNames ...string) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertReloaderRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cert-reloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	certPEM, keyPEM := newTestKeypair(t, "first", "a.example.com")
+	writeTestFile(t, certFile, certPEM)
+	writeTestFile(t, keyFile, keyPEM)
+
+	r, err := NewCertReloader(certFile, keyFile, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Valid(); err != nil {
+		t.Fatal(err)
+	}
+
+	if changed, err := r.Reload(); changed || err != nil {
+		t.Errorf("expected unchanged files not to reload, got %v, %v", changed, err)
+	}
+
+	certPEM, keyPEM = newTestKeypair(t, "second", "a.example.com")
+	writeTestFile(t, certFile, certPEM)
+	writeTestFile(t, keyFile, keyPEM)
+
+	if changed, err := r.Reload(); !changed || err != nil {
+		t.Fatalf("expected the renewed keypair to load, got %v, %v", changed, err)
+	}
+	if cn := r.Certificate().Leaf.Subject.CommonName; cn != "second" {
+		t.Errorf("expected the renewed certificate, got %v", cn)
+	}
+}
+
+func TestCertReloaderFailures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cert-reloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	certPEM, keyPEM := newTestKeypair(t, "first", "a.example.com")
+	writeTestFile(t, certFile, certPEM)
+	writeTestFile(t, keyFile, keyPEM)
+
+	core, logs := observer.New(zap.WarnLevel)
+	r, err := NewCertReloader(certFile, keyFile, zap.New(core))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failures := func() float64 {
+		return testutil.ToFloat64(r.metrics.reloads.WithLabelValues(certFile, "failure"))
+	}
+	before := failures()
+
+	// a renewed cert whose key hasn't been written yet
+	newCertPEM, newKeyPEM := newTestKeypair(t, "second", "a.example.com")
+	writeTestFile(t, certFile, newCertPEM)
+
+	for i := 0; i < 3; i++ {
+		if changed, err := r.Reload(); changed || err == nil {
+			t.Fatalf("reload %d: expected the mismatched keypair to fail, got %v, %v", i, changed, err)
+		}
+	}
+
+	if n := failures() - before; n != 3 {
+		t.Errorf("expected every failed reload to be counted, got %v", n)
+	}
+	if n := logs.Len(); n != 1 {
+		t.Errorf("expected the repeated failure to be logged once, got %d", n)
+	}
+	if cn := r.Certificate().Leaf.Subject.CommonName; cn != "first" {
+		t.Errorf("expected the current certificate to be kept, got %v", cn)
+	}
+
+	// a different error is logged
+	writeTestFile(t, certFile, []byte("garbage"))
+	r.Reload()
+	if n := logs.Len(); n != 2 {
+		t.Errorf("expected a new error to be logged, got %d logs", n)
+	}
+
+	// once the pair loads, the same failure is logged again if it comes back
+	writeTestFile(t, certFile, newCertPEM)
+	writeTestFile(t, keyFile, newKeyPEM)
+	if changed, err := r.Reload(); !changed || err != nil {
+		t.Fatalf("expected the complete keypair to load, got %v, %v", changed, err)
+	}
+
+	writeTestFile(t, certFile, []byte("garbage"))
+	r.Reload()
+	if n := logs.Len(); n != 3 {
+		t.Errorf("expected the failure after a successful reload to be logged, got %d logs", n)
+	}
+}
@@ -0,0 +1,309 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+/* a keypair found in a certificate directory */
+type certPair struct {
+	certFile string
+	keyFile  string
+}
+
+/*
+serves the keypairs in a directory, choosing one by the SNI server name of each handshake.  A pair is either
+<name>.crt (or <name>.pem) next to <name>.key, or a <name>/ subdirectory with tls.crt and tls.key like a mounted
+kubernetes.io/tls Secret.  Clients that send no server name or one no certificate covers get the default.
+*/
+type CertDirectory struct {
+	dir         string
+	defaultName string
+	fallback    *CertReloader
+	logger      *zap.Logger
+
+	mu    sync.RWMutex
+	certs map[string]*CertReloader
+	// the pair names in order, a server name covered by several pairs gets the first
+	names []string
+
+	// the last error logged for each pair that is being skipped, so it is logged once rather than every rescan.
+	// Only used by Reload, which the Watch loop calls one at a time
+	skipped        map[string]string
+	defaultRemoved bool
+}
+
+/*
+NewCertDirectory loads the keypairs in dir.  The default is the pair called defaultName in the directory, or fallback
+(e.g. the --crt/--key keypair) if defaultName is empty.
+*/
+func NewCertDirectory(dir string, defaultName string, fallback *CertReloader, logger *zap.Logger) (*CertDirectory, error) {
+	if defaultName == "" && fallback == nil {
+		return nil, fmt.Errorf("certificate directory %v needs a default certificate", dir)
+	}
+
+	d := &CertDirectory{
+		dir:         dir,
+		defaultName: defaultName,
+		fallback:    fallback,
+		logger:      logger,
+		certs:       make(map[string]*CertReloader),
+		skipped:     make(map[string]string),
+	}
+
+	if err := d.Reload(); err != nil {
+		return nil, err
+	}
+
+	if defaultName != "" && d.certs[defaultName] == nil {
+		return nil, fmt.Errorf("default certificate %q not found in %v", defaultName, dir)
+	}
+
+	return d, nil
+}
+
+func (d *CertDirectory) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	serverName := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if serverName != "" {
+		// exact names win over wildcards
+		for _, wildcard := range []bool{false, true} {
+			for _, name := range d.names {
+				cert := d.certs[name].Certificate()
+				if coversServerName(cert, serverName, wildcard) {
+					return cert, nil
+				}
+			}
+		}
+	}
+
+	if d.defaultName != "" {
+		return d.certs[d.defaultName].Certificate(), nil
+	}
+
+	return d.fallback.Certificate(), nil
+}
+
+/* Valid returns an error if the default certificate isn't valid, the others only matter to their own server names */
+func (d *CertDirectory) Valid() error {
+	if d.defaultName == "" {
+		return d.fallback.Valid()
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.certs[d.defaultName].Valid()
+}
+
+/* does one of the certificate's DNS SANs match the server name, either exactly or as a *. wildcard */
+func coversServerName(cert *tls.Certificate, serverName string, wildcard bool) bool {
+	if cert == nil || cert.Leaf == nil {
+		return false
+	}
+
+	for _, dnsName := range cert.Leaf.DNSNames {
+		dnsName = strings.ToLower(dnsName)
+
+		if !wildcard {
+			if dnsName == serverName {
+				return true
+			}
+			continue
+		}
+
+		// a wildcard only covers a single label, *.example.com matches a.example.com but not a.b.example.com
+		if strings.HasPrefix(dnsName, "*.") {
+			if i := strings.Index(serverName, "."); i > 0 && serverName[i+1:] == dnsName[2:] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+/* Watch rescans the directory every interval until the context is done, picking up new, renewed and removed pairs */
+func (d *CertDirectory) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastError := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := d.Reload()
+			if err == nil {
+				lastError = ""
+				continue
+			}
+
+			if err.Error() != lastError {
+				d.logger.Warn("Unable to scan certificate directory", zap.String("dir", d.dir), zap.Error(err))
+			}
+			lastError = err.Error()
+		}
+	}
+}
+
+/*
+Reload rescans the directory.  Renewed pairs are reloaded, new ones that fail to load are skipped until they do and
+removed ones stop being served, except for the default which is kept.
+*/
+func (d *CertDirectory) Reload() error {
+	pairs, err := scanCertDir(d.dir)
+	if err != nil {
+		return err
+	}
+
+	d.mu.RLock()
+	current := make(map[string]*CertReloader, len(d.certs))
+	for name, r := range d.certs {
+		current[name] = r
+	}
+	d.mu.RUnlock()
+
+	certs := make(map[string]*CertReloader, len(pairs))
+	for name, pair := range pairs {
+		if r, ok := current[name]; ok && r.certFile == pair.certFile && r.keyFile == pair.keyFile {
+			r.Reload()
+			certs[name] = r
+			continue
+		}
+
+		r, err := NewCertReloader(pair.certFile, pair.keyFile, d.logger)
+		if err != nil {
+			if d.skipped[name] != err.Error() {
+				d.logger.Warn("Unable to load TLS certificate, skipping it",
+					zap.String("name", name),
+					zap.String("cert", pair.certFile),
+					zap.String("key", pair.keyFile),
+					zap.Error(err),
+				)
+			}
+			d.skipped[name] = err.Error()
+
+			if r, ok := current[name]; ok {
+				certs[name] = r
+			}
+			continue
+		}
+
+		delete(d.skipped, name)
+		certs[name] = r
+	}
+
+	for name := range d.skipped {
+		if _, ok := pairs[name]; !ok {
+			delete(d.skipped, name)
+		}
+	}
+	if _, ok := pairs[d.defaultName]; ok {
+		d.defaultRemoved = false
+	}
+
+	for name, r := range current {
+		if _, ok := certs[name]; ok {
+			continue
+		}
+
+		if name == d.defaultName {
+			if !d.defaultRemoved {
+				d.logger.Warn("Default TLS certificate was removed, keeping the current one",
+					zap.String("name", name),
+					zap.String("cert", r.certFile),
+				)
+			}
+			d.defaultRemoved = true
+			certs[name] = r
+			continue
+		}
+
+		d.logger.Info("Removed TLS certificate", zap.String("name", name), zap.String("cert", r.certFile))
+		r.metrics.expiry.DeleteLabelValues(r.certFile)
+	}
+
+	names := make([]string, 0, len(certs))
+	for name := range certs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	d.mu.Lock()
+	d.certs = certs
+	d.names = names
+	d.mu.Unlock()
+
+	return nil
+}
+
+/* find the keypairs in a directory, keyed by name */
+func scanCertDir(dir string) (map[string]certPair, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read certificate directory: %v", err)
+	}
+
+	pairs := make(map[string]certPair)
+	for _, entry := range entries {
+		// kubernetes keeps the real files of a mounted volume in ..data and friends
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+
+		// follow symlinks, mounted Secrets are links into ..data
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		if info.IsDir() {
+			pair := certPair{filepath.Join(path, "tls.crt"), filepath.Join(path, "tls.key")}
+			if fileExists(pair.certFile) && fileExists(pair.keyFile) {
+				pairs[entry.Name()] = pair
+			}
+			continue
+		}
+
+		ext := filepath.Ext(entry.Name())
+		if ext != ".crt" && ext != ".pem" {
+			continue
+		}
+
+		name := strings.TrimSuffix(entry.Name(), ext)
+		keyFile := filepath.Join(dir, name+".key")
+		if !fileExists(keyFile) {
+			continue
+		}
+
+		// name.crt wins over name.pem
+		if _, ok := pairs[name]; ok && ext == ".pem" {
+			continue
+		}
+
+		pairs[name] = certPair{path, keyFile}
+	}
+
+	return pairs, nil
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,208 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+/* write a keypair as <dir>/<name>.crt and <name>.key */
+func writeTestPair(t *testing.T, dir string, name string, dnsNames ...string) {
+	t.Helper()
+
+	certPEM, keyPEM := newTestKeypair(t, name, dnsNames...)
+	writeTestFile(t, filepath.Join(dir, name+".crt"), certPEM)
+	writeTestFile(t, filepath.Join(dir, name+".key"), keyPEM)
+}
+
+func servedName(t *testing.T, d *CertDirectory, serverName string) string {
+	t.Helper()
+
+	cert, err := d.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert.Leaf.Subject.CommonName
+}
+
+func newTestCertDir(t *testing.T) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "cert-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestCertDirectorySelection(t *testing.T) {
+	dir, cleanup := newTestCertDir(t)
+	defer cleanup()
+
+	// the wildcard pair sorts first, exact names still win over it
+	writeTestPair(t, dir, "0-wildcard", "*.example.com")
+	writeTestPair(t, dir, "a", "a.example.com")
+	writeTestPair(t, dir, "default", "default.example.com")
+
+	// a mounted kubernetes.io/tls Secret
+	if err := os.Mkdir(filepath.Join(dir, "b"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	certPEM, keyPEM := newTestKeypair(t, "b", "b.example.com", "*.b.example.com")
+	writeTestFile(t, filepath.Join(dir, "b", "tls.crt"), certPEM)
+	writeTestFile(t, filepath.Join(dir, "b", "tls.key"), keyPEM)
+
+	d, err := NewCertDirectory(dir, "default", nil, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		serverName string
+		cert       string
+	}{
+		{"a.example.com", "a"},
+		{"A.Example.COM.", "a"},
+		{"x.example.com", "0-wildcard"},
+		{"b.example.com", "b"},
+		{"x.b.example.com", "b"},
+		// a wildcard covers a single label
+		{"x.y.example.com", "default"},
+		{"example.com", "default"},
+		{"other.org", "default"},
+		{"", "default"},
+	}
+
+	for _, test := range tests {
+		if cert := servedName(t, d, test.serverName); cert != test.cert {
+			t.Errorf("%q: expected %v, got %v", test.serverName, test.cert, cert)
+		}
+	}
+}
+
+func TestCertDirectoryFallback(t *testing.T) {
+	dir, cleanup := newTestCertDir(t)
+	defer cleanup()
+
+	writeTestPair(t, dir, "a", "a.example.com")
+	writeTestPair(t, dir, "fallback", "fallback.example.com")
+
+	fallback, err := NewCertReloader(filepath.Join(dir, "fallback.crt"), filepath.Join(dir, "fallback.key"), zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(dir, "fallback.crt"))
+
+	d, err := NewCertDirectory(dir, "", fallback, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert := servedName(t, d, "a.example.com"); cert != "a" {
+		t.Errorf("expected a, got %v", cert)
+	}
+	if cert := servedName(t, d, "other.example.com"); cert != "fallback" {
+		t.Errorf("expected the fallback, got %v", cert)
+	}
+
+	if _, err := NewCertDirectory(dir, "", nil, zap.NewNop()); err == nil {
+		t.Error("expected an error without a default or fallback")
+	}
+	if _, err := NewCertDirectory(dir, "missing", nil, zap.NewNop()); err == nil {
+		t.Error("expected an error when the default isn't in the directory")
+	}
+}
+
+func TestScanCertDir(t *testing.T) {
+	dir, cleanup := newTestCertDir(t)
+	defer cleanup()
+
+	for _, file := range []string{"a.crt", "a.pem", "a.key", "b.pem", "b.key", "no-key.crt", "c.txt", "..data"} {
+		writeTestFile(t, filepath.Join(dir, file), nil)
+	}
+
+	pairs, err := scanCertDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]certPair{
+		"a": {filepath.Join(dir, "a.crt"), filepath.Join(dir, "a.key")},
+		"b": {filepath.Join(dir, "b.pem"), filepath.Join(dir, "b.key")},
+	}
+	if len(pairs) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, pairs)
+	}
+	for name, pair := range expected {
+		if pairs[name] != pair {
+			t.Errorf("%v: expected %v, got %v", name, pair, pairs[name])
+		}
+	}
+}
+
+func TestCertDirectoryReload(t *testing.T) {
+	dir, cleanup := newTestCertDir(t)
+	defer cleanup()
+
+	writeTestPair(t, dir, "a", "a.example.com")
+	writeTestPair(t, dir, "default", "default.example.com")
+
+	core, logs := observer.New(zap.WarnLevel)
+	d, err := NewCertDirectory(dir, "default", nil, zap.New(core))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// added pairs are served
+	writeTestPair(t, dir, "c", "c.example.com")
+	if err := d.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if cert := servedName(t, d, "c.example.com"); cert != "c" {
+		t.Errorf("expected the added pair, got %v", cert)
+	}
+
+	// removed pairs aren't, except for the default
+	for _, name := range []string{"a", "default"} {
+		os.Remove(filepath.Join(dir, name+".crt"))
+		os.Remove(filepath.Join(dir, name+".key"))
+	}
+	for i := 0; i < 2; i++ {
+		if err := d.Reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cert := servedName(t, d, "a.example.com"); cert != "default" {
+		t.Errorf("expected the removed pair to fall back to the default, got %v", cert)
+	}
+	if n := logs.FilterMessage("Default TLS certificate was removed, keeping the current one").Len(); n != 1 {
+		t.Errorf("expected the removed default to be logged once, got %d", n)
+	}
+
+	// broken pairs are skipped and logged once
+	writeTestFile(t, filepath.Join(dir, "broken.crt"), []byte("garbage"))
+	writeTestFile(t, filepath.Join(dir, "broken.key"), []byte("garbage"))
+	for i := 0; i < 3; i++ {
+		if err := d.Reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := logs.FilterMessage("Unable to load TLS certificate, skipping it").Len(); n != 1 {
+		t.Errorf("expected the broken pair to be logged once, got %d", n)
+	}
+
+	writeTestPair(t, dir, "broken", "broken.example.com")
+	if err := d.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if cert := servedName(t, d, "broken.example.com"); cert != "broken" {
+		t.Errorf("expected the pair to be served once it loads, got %v", cert)
+	}
+}
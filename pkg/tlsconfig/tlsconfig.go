@@ -0,0 +1,152 @@
+// Package tlsconfig builds the server and client TLS configurations and exposes the verified client identity of mTLS peers.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+/* how client certificates are handled */
+const (
+	// don't ask for a client certificate (default)
+	ClientAuthNone = "none"
+	// ask for a client certificate and verify it if one is sent
+	ClientAuthRequest = "request"
+	// reject clients without a certificate signed by the client CA
+	ClientAuthRequireAndVerify = "require-and-verify"
+)
+
+func ParseClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "", ClientAuthNone:
+		return tls.NoClientCert, nil
+	case ClientAuthRequest:
+		return tls.VerifyClientCertIfGiven, nil
+	case ClientAuthRequireAndVerify:
+		return tls.RequireAndVerifyClientCert, nil
+	}
+
+	return tls.NoClientCert, fmt.Errorf("unknown client auth mode %q, must be one of %v, %v, %v",
+		mode, ClientAuthNone, ClientAuthRequest, ClientAuthRequireAndVerify)
+}
+
+/* LoadCertPool loads a PEM bundle of CA certificates */
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load CA bundle: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %v", file)
+	}
+
+	return pool, nil
+}
+
+type ServerOptions struct {
+	// the serving keypair, ignored if GetCertificate is set
+	CertFile string
+	KeyFile  string
+
+	// picks the serving certificate for each handshake, e.g. CertReloader.GetCertificate
+	GetCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+	// PEM bundle of CAs that sign client certificates, required unless ClientAuth is none
+	ClientCAFile string
+	ClientAuth   string
+
+	// ALPN protocols, h2 for gRPC if empty
+	NextProtos []string
+}
+
+/* ServerConfig builds the TLS config for the gRPC listener, or an HTTP one with NextProtos */
+func ServerConfig(opts ServerOptions) (*tls.Config, error) {
+	clientAuth, err := ParseClientAuth(opts.ClientAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		ClientAuth: clientAuth,
+		NextProtos: []string{"h2"},
+	}
+
+	if len(opts.NextProtos) > 0 {
+		config.NextProtos = opts.NextProtos
+	}
+
+	if opts.GetCertificate != nil {
+		config.GetCertificate = opts.GetCertificate
+	} else {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load keypair: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if clientAuth != tls.NoClientCert {
+		if opts.ClientCAFile == "" {
+			return nil, fmt.Errorf("client auth %v needs a client CA bundle", opts.ClientAuth)
+		}
+
+		config.ClientCAs, err = LoadCertPool(opts.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+type ClientOptions struct {
+	// PEM bundle of CAs to verify the server with, the system roots if empty
+	CAFile string
+
+	// client keypair for mutual TLS
+	CertFile string
+	KeyFile  string
+
+	// SNI and the name the server certificate is verified against, instead of the host dialed
+	ServerName string
+
+	// ALPN protocols to offer, gRPC adds h2 if it's missing
+	NextProtos []string
+
+	InsecureSkipVerify bool
+}
+
+/* ClientConfig builds the TLS config for dialing the server */
+func ClientConfig(opts ClientOptions) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         opts.ServerName,
+		NextProtos:         opts.NextProtos,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+
+	if opts.CAFile != "" {
+		pool, err := LoadCertPool(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		if opts.CertFile == "" || opts.KeyFile == "" {
+			return nil, fmt.Errorf("a client certificate needs both a cert and a key")
+		}
+
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client keypair: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
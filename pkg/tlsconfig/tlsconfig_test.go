@@ -0,0 +1,196 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* handshake with the server config, returning the server's view of the connection */
+func handshake(t *testing.T, server *tls.Config, clientCert *tls.Certificate) (tls.ConnectionState, error) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	client := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}}
+	if clientCert != nil {
+		// sent even if the server doesn't list its issuer as acceptable
+		client.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return clientCert, nil
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		conn, err := tls.Dial("tcp", ln.Addr().String(), client)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// TLS 1.3 clients only see a rejected certificate on the first read
+		conn.Read(make([]byte, 1))
+	}()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConn := tls.Server(conn, server)
+	err = serverConn.Handshake()
+	state := serverConn.ConnectionState()
+	serverConn.Close()
+	<-done
+
+	return state, err
+}
+
+func TestServerConfigClientAuth(t *testing.T) {
+	dir, cleanup := newTestCertDir(t)
+	defer cleanup()
+
+	serverCert, serverKey := newTestKeypair(t, "server", "localhost")
+	writeTestFile(t, filepath.Join(dir, "server.crt"), serverCert)
+	writeTestFile(t, filepath.Join(dir, "server.key"), serverKey)
+
+	// self-signed, so the client certificate is its own CA
+	clientCertPEM, clientKeyPEM := newTestKeypair(t, "tenant-a", "tenant-a.example.com")
+	writeTestFile(t, filepath.Join(dir, "client-ca.crt"), clientCertPEM)
+
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	untrustedCertPEM, untrustedKeyPEM := newTestKeypair(t, "untrusted")
+	untrustedCert, err := tls.X509KeyPair(untrustedCertPEM, untrustedKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		mode     string
+		cert     *tls.Certificate
+		rejected bool
+		verified bool
+	}{
+		{"none without a certificate", ClientAuthNone, nil, false, false},
+		{"none ignores a certificate", ClientAuthNone, &clientCert, false, false},
+		{"default is none", "", &untrustedCert, false, false},
+		{"request without a certificate", ClientAuthRequest, nil, false, false},
+		{"request with a trusted certificate", ClientAuthRequest, &clientCert, false, true},
+		{"request with an untrusted certificate", ClientAuthRequest, &untrustedCert, true, false},
+		{"require without a certificate", ClientAuthRequireAndVerify, nil, true, false},
+		{"require with a trusted certificate", ClientAuthRequireAndVerify, &clientCert, false, true},
+		{"require with an untrusted certificate", ClientAuthRequireAndVerify, &untrustedCert, true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := ServerOptions{
+				CertFile:   filepath.Join(dir, "server.crt"),
+				KeyFile:    filepath.Join(dir, "server.key"),
+				ClientAuth: test.mode,
+			}
+			if test.mode != "" && test.mode != ClientAuthNone {
+				opts.ClientCAFile = filepath.Join(dir, "client-ca.crt")
+			}
+
+			config, err := ServerConfig(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			state, err := handshake(t, config, test.cert)
+			if (err != nil) != test.rejected {
+				t.Fatalf("expected rejected %v, got %v", test.rejected, err)
+			}
+
+			if verified := len(state.VerifiedChains) > 0; verified != test.verified {
+				t.Errorf("expected a verified client certificate %v, got %v", test.verified, verified)
+			}
+
+			if test.verified {
+				if identity := peerIdentity(state.VerifiedChains[0][0]); identity.CommonName != "tenant-a" {
+					t.Errorf("expected the client certificate, got %+v", identity)
+				}
+			}
+
+			if err == nil && state.NegotiatedProtocol != "h2" {
+				t.Errorf("expected h2 to be negotiated, got %q", state.NegotiatedProtocol)
+			}
+		})
+	}
+}
+
+func TestServerConfigErrors(t *testing.T) {
+	dir, cleanup := newTestCertDir(t)
+	defer cleanup()
+
+	cert, key := newTestKeypair(t, "server", "localhost")
+	writeTestFile(t, filepath.Join(dir, "server.crt"), cert)
+	writeTestFile(t, filepath.Join(dir, "server.key"), key)
+	writeTestFile(t, filepath.Join(dir, "empty.crt"), []byte("not a certificate"))
+
+	tests := []struct {
+		name string
+		opts ServerOptions
+		err  string
+	}{
+		{"unknown client auth", ServerOptions{ClientAuth: "optional"}, `unknown client auth mode "optional"`},
+		{"request without a client CA", ServerOptions{ClientAuth: ClientAuthRequest}, "needs a client CA bundle"},
+		{"require without a client CA", ServerOptions{ClientAuth: ClientAuthRequireAndVerify}, "needs a client CA bundle"},
+		{"client CA without certificates", ServerOptions{ClientAuth: ClientAuthRequest, ClientCAFile: filepath.Join(dir, "empty.crt")}, "empty.crt"},
+		{"missing keypair", ServerOptions{CertFile: filepath.Join(dir, "missing.crt"), KeyFile: filepath.Join(dir, "server.key")}, "unable to load keypair"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.opts.CertFile == "" {
+				test.opts.CertFile = filepath.Join(dir, "server.crt")
+				test.opts.KeyFile = filepath.Join(dir, "server.key")
+			}
+
+			_, err := ServerConfig(test.opts)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestServerConfigNextProtos(t *testing.T) {
+	reloader := func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return nil, nil }
+
+	config, err := ServerConfig(ServerOptions{GetCertificate: reloader})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(config.NextProtos) != 1 || config.NextProtos[0] != "h2" {
+		t.Errorf("expected h2 by default, got %v", config.NextProtos)
+	}
+
+	if config.GetCertificate == nil || len(config.Certificates) != 0 {
+		t.Errorf("expected the certificate from GetCertificate only")
+	}
+
+	config, err = ServerConfig(ServerOptions{GetCertificate: reloader, NextProtos: []string{"http/1.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(config.NextProtos) != 1 || config.NextProtos[0] != "http/1.1" {
+		t.Errorf("expected http/1.1, got %v", config.NextProtos)
+	}
+}